@@ -0,0 +1,295 @@
+package assets
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Precompressed variants looked for next to each file, in order of
+// preference. The key is the content coding, the value the file suffix
+var precompressed = []struct {
+	encoding string
+	suffix   string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// A static file and its content hashed name
+type Asset struct {
+	Name       string    // Path of the file in the FS, i.e. css/output.css
+	HashedName string    // Name with the content hash, i.e. css/output.3fa9c1d2.css
+	ETag       string    // Quoted strong ETag from the content hash
+	Integrity  string    // Subresource integrity hash, i.e. sha384-...
+	ModTime    time.Time // Modification time of the file. Zero if embedded
+	size       int64
+	content    []byte            // Cached content. Nil if the manifest reloads
+	variants   map[string]string // Content coding to precompressed file name
+}
+
+// Set of static files with content hashed names. Hashed names never change
+// content so they can be cached forever
+type Manifest struct {
+	fsys   fs.FS
+	reload bool
+	mu     sync.RWMutex
+	assets map[string]*Asset // Keyed by Name
+	hashed map[string]*Asset // Keyed by HashedName
+}
+
+// Build a manifest of the files in fsys. If reload is true, files are rehashed
+// when they change and are read from fsys on each request (for development).
+// Otherwise file contents are cached in memory
+func NewManifest(fsys fs.FS, reload bool) (*Manifest, error) {
+	m := &Manifest{
+		fsys:   fsys,
+		reload: reload,
+		assets: map[string]*Asset{},
+		hashed: map[string]*Asset{},
+	}
+	err := m.refresh()
+	if err != nil {
+		return nil, errors.Wrap(err, "m.refresh")
+	}
+	return m, nil
+}
+
+// Walk the FS and hash any new or changed files. Precompressed variants are
+// attached to the file they were made from rather than added as assets
+func (m *Manifest) refresh() error {
+	assets := map[string]*Asset{}
+	hashed := map[string]*Asset{}
+	m.mu.RLock()
+	previous := m.assets
+	m.mu.RUnlock()
+
+	err := fs.WalkDir(m.fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || isVariant(name) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return errors.Wrap(err, "d.Info")
+		}
+		asset := previous[name]
+		if asset == nil || asset.size != info.Size() || !asset.ModTime.Equal(info.ModTime()) {
+			asset, err = m.hash(name, info)
+			if err != nil {
+				return errors.Wrap(err, "m.hash")
+			}
+		}
+		assets[name] = asset
+		hashed[asset.HashedName] = asset
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "fs.WalkDir")
+	}
+
+	m.mu.Lock()
+	m.assets = assets
+	m.hashed = hashed
+	m.mu.Unlock()
+	return nil
+}
+
+// Read and hash a file, finding any precompressed variants of it
+func (m *Manifest) hash(name string, info fs.FileInfo) (*Asset, error) {
+	content, err := fs.ReadFile(m.fsys, name)
+	if err != nil {
+		return nil, errors.Wrap(err, "fs.ReadFile")
+	}
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])[:8]
+	sri := sha512.Sum384(content)
+	ext := path.Ext(name)
+	asset := &Asset{
+		Name:       name,
+		HashedName: strings.TrimSuffix(name, ext) + "." + hash + ext,
+		ETag:       strconv.Quote(hash),
+		Integrity:  "sha384-" + base64.StdEncoding.EncodeToString(sri[:]),
+		ModTime:    info.ModTime(),
+		size:       info.Size(),
+		variants:   map[string]string{},
+	}
+	if !m.reload {
+		asset.content = content
+	}
+	for _, p := range precompressed {
+		if _, err := fs.Stat(m.fsys, name+p.suffix); err == nil {
+			asset.variants[p.encoding] = name + p.suffix
+		}
+	}
+	return asset, nil
+}
+
+// Check if the file is a precompressed variant of another file
+func isVariant(name string) bool {
+	for _, p := range precompressed {
+		if strings.HasSuffix(name, p.suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Get the URL of the static file with the content hashed name. Returns the
+// unhashed URL if the file isn't in the manifest
+func (m *Manifest) URL(name string) string {
+	name = strings.TrimPrefix(name, "/")
+	if m.reload {
+		// Errors leave the previous manifest in place
+		m.refresh()
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if asset, ok := m.assets[name]; ok {
+		return "/static/" + asset.HashedName
+	}
+	return "/static/" + name
+}
+
+// Get the subresource integrity hash of the static file. Returns an empty
+// string if the file isn't in the manifest
+func (m *Manifest) Integrity(name string) string {
+	name = strings.TrimPrefix(name, "/")
+	if m.reload {
+		m.refresh()
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if asset, ok := m.assets[name]; ok {
+		return asset.Integrity
+	}
+	return ""
+}
+
+// Find the asset for a path under /static/. immutable is true if the path was
+// the content hashed name
+func (m *Manifest) Lookup(name string) (asset *Asset, immutable bool, ok bool) {
+	asset, immutable, ok = m.lookup(name)
+	if !ok && m.reload {
+		// File may have been added or changed since the last refresh
+		m.refresh()
+		asset, immutable, ok = m.lookup(name)
+	}
+	return asset, immutable, ok
+}
+
+func (m *Manifest) lookup(name string) (*Asset, bool, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if asset, ok := m.hashed[name]; ok {
+		return asset, true, true
+	}
+	if asset, ok := m.assets[name]; ok {
+		return asset, false, true
+	}
+	return nil, false, false
+}
+
+// Check if the asset has precompressed variants
+func (a *Asset) HasVariants() bool {
+	return len(a.variants) > 0
+}
+
+// Get the precompressed variant to send for the Accept-Encoding header.
+// Returns an empty string if the file should be sent as is
+func (a *Asset) Encoding(acceptEncoding string) string {
+	for _, p := range precompressed {
+		if _, ok := a.variants[p.encoding]; ok && accepts(acceptEncoding, p.encoding) {
+			return p.encoding
+		}
+	}
+	return ""
+}
+
+// Open the asset, or the precompressed variant for the encoding
+func (m *Manifest) Open(asset *Asset, encoding string) (io.ReadSeeker, error) {
+	if encoding == "" {
+		if asset.content != nil {
+			return bytes.NewReader(asset.content), nil
+		}
+		content, err := fs.ReadFile(m.fsys, asset.Name)
+		if err != nil {
+			return nil, errors.Wrap(err, "fs.ReadFile")
+		}
+		return bytes.NewReader(content), nil
+	}
+	variant, ok := asset.variants[encoding]
+	if !ok {
+		return nil, errors.Errorf("No %s variant of %s", encoding, asset.Name)
+	}
+	content, err := fs.ReadFile(m.fsys, variant)
+	if err != nil {
+		return nil, errors.Wrap(err, "fs.ReadFile")
+	}
+	return bytes.NewReader(content), nil
+}
+
+// Check if the content coding is accepted with a q-value above 0
+func accepts(acceptEncoding string, encoding string) bool {
+	wildcard := false
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != encoding && name != "*" {
+			continue
+		}
+		accepted := true
+		if key, val, found := strings.Cut(strings.TrimSpace(params), "="); found &&
+			strings.ToLower(strings.TrimSpace(key)) == "q" {
+			q, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+			accepted = err == nil && q > 0
+		}
+		if name == encoding {
+			return accepted
+		}
+		wildcard = accepted
+	}
+	return wildcard
+}
+
+// Manifest used by URL
+var defaultManifest atomic.Pointer[Manifest]
+
+// Set the manifest used by URL
+func SetDefault(m *Manifest) {
+	defaultManifest.Store(m)
+}
+
+// Get the URL of the static file with the content hashed name, using the
+// default manifest. For use in templ components
+func URL(name string) string {
+	m := defaultManifest.Load()
+	if m == nil {
+		return "/static/" + strings.TrimPrefix(name, "/")
+	}
+	return m.URL(name)
+}
+
+// Get the subresource integrity hash of the static file, using the default
+// manifest. For use in templ components
+func Integrity(name string) string {
+	m := defaultManifest.Load()
+	if m == nil {
+		return ""
+	}
+	return m.Integrity(name)
+}
@@ -0,0 +1,113 @@
+package assets
+
+import (
+	"io"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManifest(t *testing.T) {
+	fsys := fstest.MapFS{
+		"css/output.css":    {Data: []byte("body{}"), ModTime: time.Unix(100, 0)},
+		"css/output.css.br": {Data: []byte("brotli")},
+		"css/output.css.gz": {Data: []byte("gzip")},
+		"favicon.ico":       {Data: []byte("icon")},
+	}
+	manifest, err := NewManifest(fsys, false)
+	require.NoError(t, err)
+
+	t.Run("URLs use the content hash", func(t *testing.T) {
+		assert.Equal(t, "/static/css/output.7c98040a.css", manifest.URL("css/output.css"))
+		assert.Equal(t, "/static/css/output.7c98040a.css", manifest.URL("/css/output.css"))
+		assert.Equal(t, "/static/missing.js", manifest.URL("missing.js"))
+	})
+	t.Run("Lookup finds hashed and plain names", func(t *testing.T) {
+		asset, immutable, ok := manifest.Lookup("css/output.7c98040a.css")
+		require.True(t, ok)
+		assert.True(t, immutable)
+		assert.Equal(t, "css/output.css", asset.Name)
+		assert.Equal(t, `"7c98040a"`, asset.ETag)
+
+		asset, immutable, ok = manifest.Lookup("css/output.css")
+		require.True(t, ok)
+		assert.False(t, immutable)
+		assert.Equal(t, "css/output.css", asset.Name)
+
+		_, _, ok = manifest.Lookup("css/output.00000000.css")
+		assert.False(t, ok)
+		_, _, ok = manifest.Lookup("css/output.css.br")
+		assert.False(t, ok)
+	})
+	t.Run("Precompressed variants are negotiated", func(t *testing.T) {
+		asset, _, ok := manifest.Lookup("css/output.css")
+		require.True(t, ok)
+		assert.True(t, asset.HasVariants())
+		assert.Equal(t, "br", asset.Encoding("gzip, deflate, br"))
+		assert.Equal(t, "gzip", asset.Encoding("gzip, br;q=0"))
+		assert.Equal(t, "br", asset.Encoding("*"))
+		assert.Equal(t, "", asset.Encoding("deflate"))
+		assert.Equal(t, "", asset.Encoding(""))
+
+		content, err := manifest.Open(asset, "br")
+		require.NoError(t, err)
+		data, err := io.ReadAll(content)
+		require.NoError(t, err)
+		assert.Equal(t, "brotli", string(data))
+
+		icon, _, ok := manifest.Lookup("favicon.ico")
+		require.True(t, ok)
+		assert.False(t, icon.HasVariants())
+	})
+	t.Run("Reloading manifest picks up changed files", func(t *testing.T) {
+		reloading, err := NewManifest(fsys, true)
+		require.NoError(t, err)
+		before := reloading.URL("favicon.ico")
+		fsys["favicon.ico"] = &fstest.MapFile{Data: []byte("new icon"), ModTime: time.Unix(200, 0)}
+		after := reloading.URL("favicon.ico")
+		assert.NotEqual(t, before, after)
+		_, _, ok := reloading.Lookup(after[len("/static/"):])
+		assert.True(t, ok)
+	})
+	t.Run("Integrity is the sha384 of the content", func(t *testing.T) {
+		assert.Equal(t,
+			"sha384-myyg/hQ74aSgjBBvVME/QXAXEkT4Y9dHbVQ5C0lIyGpldvNLJV2IWc5ElXbqLi06",
+			manifest.Integrity("css/output.css"),
+		)
+		assert.Empty(t, manifest.Integrity("missing.js"))
+	})
+	t.Run("Default URL falls back without a manifest", func(t *testing.T) {
+		assert.Equal(t, "/static/css/output.css", URL("css/output.css"))
+		SetDefault(manifest)
+		defer SetDefault(nil)
+		assert.Equal(t, "/static/css/output.7c98040a.css", URL("css/output.css"))
+	})
+}
+
+func TestScripts(t *testing.T) {
+	assert.Equal(t, DefaultScripts(false), Scripts())
+	names := func(scripts []Script) []string {
+		out := []string{}
+		for _, s := range scripts {
+			out = append(out, s.Name)
+		}
+		return out
+	}
+	assert.NotContains(t, names(DefaultScripts(false)), "js/htmx-debug.js")
+	assert.Contains(t, names(DefaultScripts(true)), "js/htmx-debug.js")
+
+	SetScripts(DefaultScripts(true))
+	defer SetScripts(DefaultScripts(false))
+	assert.Equal(t, DefaultScripts(true), Scripts())
+
+	fsys := fstest.MapFS{}
+	for _, s := range vendorScripts {
+		fsys[s.Name] = &fstest.MapFile{Data: []byte("//")}
+	}
+	assert.NoError(t, CheckVendored(fsys))
+	delete(fsys, "js/vendor/alpine.min.js")
+	assert.ErrorContains(t, CheckVendored(fsys), "alpine.min.js")
+}
@@ -0,0 +1,88 @@
+// Writes brotli (.br) and gzip (.gz) variants of the compressible files in a
+// directory so they can be embedded and served without compressing on each
+// request. Run by go generate before the static files are embedded
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Extensions of files worth compressing
+var compressible = map[string]bool{
+	".css":  true,
+	".js":   true,
+	".mjs":  true,
+	".json": true,
+	".svg":  true,
+	".html": true,
+	".txt":  true,
+	".xml":  true,
+	".map":  true,
+}
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Println("Usage: precompress <dir>")
+		os.Exit(1)
+	}
+	err := filepath.WalkDir(os.Args[1], func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !compressible[strings.ToLower(filepath.Ext(path))] {
+			return nil
+		}
+		err = compress(path, path+".br", func(w io.Writer) io.WriteCloser {
+			return brotli.NewWriterLevel(w, brotli.BestCompression)
+		})
+		if err != nil {
+			return err
+		}
+		return compress(path, path+".gz", func(w io.Writer) io.WriteCloser {
+			gz, _ := gzip.NewWriterLevel(w, gzip.BestCompression)
+			return gz
+		})
+	})
+	if err != nil {
+		log.Fatalf("Failed to precompress files: %v", err)
+	}
+}
+
+// Compress the file at src into dst, skipping it if dst is newer than src
+func compress(src string, dst string, newWriter func(io.Writer) io.WriteCloser) error {
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if dstInfo, err := os.Stat(dst); err == nil && dstInfo.ModTime().After(srcInfo.ModTime()) {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	w := newWriter(out)
+	if _, err := io.Copy(w, in); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("Compressed %s\n", dst)
+	return nil
+}
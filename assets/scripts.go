@@ -0,0 +1,65 @@
+package assets
+
+import (
+	"io/fs"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// A script loaded by the global layout
+type Script struct {
+	Name  string // Path of the file in the static FS
+	Defer bool   // Flag for loading the script after the document is parsed
+}
+
+// Vendored frontend libraries, in load order. Alpine plugins must load
+// before Alpine itself
+var vendorScripts = []Script{
+	{Name: "js/vendor/htmx.min.js"},
+	{Name: "js/vendor/alpine-persist.min.js", Defer: true},
+	{Name: "js/vendor/alpine.min.js", Defer: true},
+}
+
+// Check the vendored libraries are in the static files. They are downloaded
+// by go generate, so a build that skipped it would serve pages without them
+func CheckVendored(fsys fs.FS) error {
+	for _, script := range vendorScripts {
+		if _, err := fs.Stat(fsys, script.Name); err != nil {
+			return errors.Errorf("%s is missing, run go generate to vendor it", script.Name)
+		}
+	}
+	return nil
+}
+
+// Scripts that log htmx events to the console
+var debugScripts = []Script{
+	{Name: "js/htmx-debug.js"},
+}
+
+// Get the scripts to load on each page. Debug adds the htmx event logging
+func DefaultScripts(debug bool) []Script {
+	scripts := append([]Script{}, vendorScripts...)
+	if debug {
+		scripts = append(scripts, debugScripts...)
+	}
+	return scripts
+}
+
+// Scripts loaded by the global layout
+var scripts atomic.Pointer[[]Script]
+
+// Set the scripts loaded by the global layout
+func SetScripts(s []Script) {
+	scripts.Store(&s)
+}
+
+// Get the scripts loaded by the global layout. Defaults to the scripts for
+// production if SetScripts hasn't been called
+func Scripts() []Script {
+	s := scripts.Load()
+	if s == nil {
+		return DefaultScripts(false)
+	}
+	return *s
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"flag"
+
+	"projectreshoot/jobs"
+
+	"github.com/pkg/errors"
+)
+
+// Options for the backup command
+type backupOptions struct {
+	Dir  string // Directory to write the backup to, instead of BACKUP_DIR
+	Keep int    // Number of automatic backups to keep, instead of BACKUP_KEEP
+}
+
+// Write a backup of the SQLite database now, the same as the scheduled job
+func backupCommand() *command {
+	var opts backupOptions
+	return &command{
+		name:    "backup",
+		summary: "Write a copy of the SQLite database, removing the oldest automatic copies",
+		setup:   setupConfig,
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&opts.Dir, "dir", "", "Directory to write the backup to (default BACKUP_DIR)")
+			fs.IntVar(&opts.Keep, "keep", 0, "Number of backups to keep (default BACKUP_KEEP)")
+		},
+		run: func(ctx context.Context, env *cmdEnv, args []string) error {
+			if opts.Dir == "" {
+				opts.Dir = env.config.BackupDir
+			}
+			if opts.Dir == "" {
+				return errors.New("No backup directory: set BACKUP_DIR or use --dir")
+			}
+			if opts.Keep == 0 {
+				opts.Keep = env.config.BackupKeep
+			}
+			conn, err := env.connect()
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			job := jobs.Backup(nil, opts.Dir, env.config.DBName, opts.Keep, env.logger)
+			return job.Run(ctx, conn)
+		},
+	}
+}
@@ -0,0 +1,165 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// Serves the certificate from a cert and key file, loading them again when
+// either file changes so renewed certificates are used without a restart
+type Reloader struct {
+	certFile string
+	keyFile  string
+	logger   *zerolog.Logger
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	modTimes [2]time.Time // Modification times of the cert and key when loaded
+}
+
+// Create a Reloader and load the certificate. Returns an error if the
+// certificate can't be loaded
+func NewReloader(certFile, keyFile string, logger *zerolog.Logger) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile, logger: logger}
+	modTimes, err := r.stat()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.load(modTimes); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Get the modification times of the cert and key files
+func (r *Reloader) stat() ([2]time.Time, error) {
+	var modTimes [2]time.Time
+	for i, path := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return modTimes, errors.Wrap(err, "os.Stat")
+		}
+		modTimes[i] = info.ModTime()
+	}
+	return modTimes, nil
+}
+
+// Load the cert and key pair and swap it in
+func (r *Reloader) load(modTimes [2]time.Time) error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return errors.Wrap(err, "tls.LoadX509KeyPair")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert = &cert
+	r.modTimes = modTimes
+	return nil
+}
+
+// Load the certificate again if the cert or key file changed since it was
+// last loaded. If the new files can't be loaded the current certificate is
+// kept. Returns true if a new certificate was loaded
+func (r *Reloader) Reload() (bool, error) {
+	modTimes, err := r.stat()
+	if err != nil {
+		return false, err
+	}
+	r.mu.RLock()
+	changed := modTimes != r.modTimes
+	r.mu.RUnlock()
+	if !changed {
+		return false, nil
+	}
+	if err := r.load(modTimes); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Check the files for changes every interval until the context is done.
+// Certificates are often renewed by writing the cert and key separately, so
+// a pair that fails to load is tried again on the next check
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		reloaded, err := r.Reload()
+		if err != nil {
+			r.logger.Warn().Err(err).Msg("Failed to reload TLS certificate, keeping the current one")
+			continue
+		}
+		if reloaded {
+			r.logger.Info().Str("cert", r.certFile).Msg("TLS certificate reloaded")
+		}
+	}
+}
+
+// Get the current certificate. Set as the GetCertificate hook of a
+// tls.Config
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// Get the TLS version for a config value of "1.2" or "1.3"
+func Version(value string) (uint16, error) {
+	switch value {
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, errors.Errorf("unsupported TLS version %q", value)
+}
+
+// Get a server tls.Config with modern defaults serving the certificate from
+// the Reloader. Only forward secret AEAD cipher suites are allowed for TLS
+// 1.2, TLS 1.3 suites aren't configurable
+func ServerConfig(r *Reloader, minVersion uint16) *tls.Config {
+	return &tls.Config{
+		MinVersion:     minVersion,
+		GetCertificate: r.GetCertificate,
+		CurvePreferences: []tls.CurveID{
+			tls.X25519,
+			tls.CurveP256,
+		},
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+		},
+		NextProtos: []string{"h2", "http/1.1"},
+	}
+}
+
+// Require clients to present a certificate signed by one of the CAs in the
+// PEM file at caFile
+func RequireClientCerts(cfg *tls.Config, caFile string) error {
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return errors.Wrap(err, "os.ReadFile")
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return errors.Errorf("%s: no PEM certificates found", caFile)
+	}
+	cfg.ClientCAs = pool
+	cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	return nil
+}
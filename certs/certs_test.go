@@ -0,0 +1,215 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Generate a certificate for 127.0.0.1 signed by parent, or self-signed if
+// parent is nil. Returns the certificate, its key and both PEM encoded
+func generateCert(
+	t *testing.T,
+	name string,
+	parent *x509.Certificate,
+	parentKey *ecdsa.PrivateKey,
+) (*x509.Certificate, *ecdsa.PrivateKey, []byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage: []x509.ExtKeyUsage{
+			x509.ExtKeyUsageServerAuth,
+			x509.ExtKeyUsageClientAuth,
+		},
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return cert, key, certPEM, keyPEM
+}
+
+// Write a new self-signed certificate to the cert and key files
+func writeCert(t *testing.T, name, certFile, keyFile string) *x509.Certificate {
+	cert, _, certPEM, keyPEM := generateCert(t, name, nil, nil)
+	require.NoError(t, os.WriteFile(certFile, certPEM, 0600))
+	require.NoError(t, os.WriteFile(keyFile, keyPEM, 0600))
+	return cert
+}
+
+// Start a TLS server using cfg the same way the server does. Returns its URL
+func startServer(t *testing.T, cfg *tls.Config) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}),
+		TLSConfig: cfg,
+		ErrorLog:  log.New(io.Discard, "", 0),
+	}
+	go server.ServeTLS(ln, "", "")
+	t.Cleanup(func() { server.Close() })
+	return "https://" + ln.Addr().String()
+}
+
+// Get a client trusting the given certificate
+func client(trusted *x509.Certificate, clientCerts ...tls.Certificate) *http.Client {
+	pool := x509.NewCertPool()
+	pool.AddCert(trusted)
+	return &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{RootCAs: pool, Certificates: clientCerts},
+	}}
+}
+
+func TestReloader(t *testing.T) {
+	logger := zerolog.Nop()
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	first := writeCert(t, "first", certFile, keyFile)
+
+	tlsCerts, err := NewReloader(certFile, keyFile, &logger)
+	require.NoError(t, err)
+	url := startServer(t, ServerConfig(tlsCerts, tls.VersionTLS12))
+
+	resp, err := client(first).Get(url)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "first", resp.TLS.PeerCertificates[0].Subject.CommonName)
+
+	t.Run("Unchanged files aren't reloaded", func(t *testing.T) {
+		reloaded, err := tlsCerts.Reload()
+		require.NoError(t, err)
+		assert.False(t, reloaded)
+	})
+
+	t.Run("Changed files are reloaded", func(t *testing.T) {
+		// Makes sure the modification times change
+		time.Sleep(10 * time.Millisecond)
+		second := writeCert(t, "second", certFile, keyFile)
+		reloaded, err := tlsCerts.Reload()
+		require.NoError(t, err)
+		assert.True(t, reloaded)
+
+		resp, err := client(second).Get(url)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, "second", resp.TLS.PeerCertificates[0].Subject.CommonName)
+	})
+
+	t.Run("Invalid files keep the current certificate", func(t *testing.T) {
+		time.Sleep(10 * time.Millisecond)
+		require.NoError(t, os.WriteFile(keyFile, []byte("not a key"), 0600))
+		reloaded, err := tlsCerts.Reload()
+		require.Error(t, err)
+		assert.False(t, reloaded)
+		cert, err := tlsCerts.GetCertificate(nil)
+		require.NoError(t, err)
+		assert.NotNil(t, cert)
+	})
+}
+
+func TestServerConfig(t *testing.T) {
+	logger := zerolog.Nop()
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	cert := writeCert(t, "server", certFile, keyFile)
+	tlsCerts, err := NewReloader(certFile, keyFile, &logger)
+	require.NoError(t, err)
+	url := startServer(t, ServerConfig(tlsCerts, tls.VersionTLS13))
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	old := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{RootCAs: pool, MaxVersion: tls.VersionTLS12},
+	}}
+	_, err = old.Get(url)
+	assert.Error(t, err, "TLS 1.2 should be refused")
+
+	resp, err := client(cert).Get(url)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, uint16(tls.VersionTLS13), resp.TLS.Version)
+}
+
+func TestRequireClientCerts(t *testing.T) {
+	logger := zerolog.Nop()
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	serverCert := writeCert(t, "server", certFile, keyFile)
+	tlsCerts, err := NewReloader(certFile, keyFile, &logger)
+	require.NoError(t, err)
+
+	ca, caKey, caPEM, _ := generateCert(t, "ca", nil, nil)
+	caFile := filepath.Join(dir, "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, caPEM, 0600))
+	cfg := ServerConfig(tlsCerts, tls.VersionTLS12)
+	require.NoError(t, RequireClientCerts(cfg, caFile))
+	url := startServer(t, cfg)
+
+	t.Run("Client without a cert is refused", func(t *testing.T) {
+		_, err := client(serverCert).Get(url)
+		assert.Error(t, err)
+	})
+
+	t.Run("Client with a cert from another CA is refused", func(t *testing.T) {
+		_, _, certPEM, keyPEM := generateCert(t, "other", nil, nil)
+		clientCert, err := tls.X509KeyPair(certPEM, keyPEM)
+		require.NoError(t, err)
+		_, err = client(serverCert, clientCert).Get(url)
+		assert.Error(t, err)
+	})
+
+	t.Run("Client with a cert from the CA is accepted", func(t *testing.T) {
+		_, _, certPEM, keyPEM := generateCert(t, "client", ca, caKey)
+		clientCert, err := tls.X509KeyPair(certPEM, keyPEM)
+		require.NoError(t, err)
+		resp, err := client(serverCert, clientCert).Get(url)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("CA file without certificates is an error", func(t *testing.T) {
+		empty := filepath.Join(dir, "empty.pem")
+		require.NoError(t, os.WriteFile(empty, []byte("nothing"), 0600))
+		assert.Error(t, RequireClientCerts(&tls.Config{}, empty))
+	})
+}
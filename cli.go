@@ -0,0 +1,414 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"projectreshoot/config"
+	"projectreshoot/db"
+	"projectreshoot/logging"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// Name of the program shown in the usage
+const programName = "projectreshoot"
+
+// How much of the shared setup run does before a command runs
+const (
+	setupNone    = iota // The command loads what it needs itself
+	setupConfig         // Config without the secrets, logging to stderr
+	setupSecrets        // Config with the secrets, logging to stderr
+	setupServer         // Config with the secrets, logging to stdout
+)
+
+// A command in the command tree. Commands have subcommands or a run
+// function, not both
+type command struct {
+	name     string              // Name used on the commandline
+	args     string              // Positional arguments shown in the usage
+	minArgs  int                 // Fewest positional arguments accepted
+	maxArgs  int                 // Most positional arguments accepted
+	summary  string              // One line description shown in the help
+	flags    func(*flag.FlagSet) // Registers the command's own flags
+	run      func(context.Context, *cmdEnv, []string) error
+	commands []*command // Subcommands
+	fallback *command   // Subcommand run when none is given
+	setup    int        // setupNone, setupConfig, setupSecrets or setupServer
+}
+
+// State shared by the commands, set up by run
+type cmdEnv struct {
+	stdin    io.Reader
+	stdout   io.Writer
+	stderr   io.Writer
+	flags    config.Flags       // Global flags, and the config overrides from serve
+	config   *config.Config     // Loaded config. nil for setupNone
+	logger   *zerolog.Logger    // Logger from the config. nil for setupNone
+	logFiles []*logging.LogFile // Log files the logger writes to
+}
+
+// Build the command tree. Flags that override the config are bound to global
+func commandTree(global *config.Flags) *command {
+	serve := serveCommand(global)
+	return &command{
+		name:    programName,
+		summary: "Run the web server or manage its data",
+		commands: []*command{
+			serve,
+			migrateCommand(),
+			userCommand(),
+			tokenCommand(),
+			backupCommand(),
+			queueCommand(),
+			configCommand(),
+			versionCommand(),
+		},
+		fallback: serve,
+	}
+}
+
+// Get the subcommand with the name, or nil if there isn't one
+func (c *command) sub(name string) *command {
+	for _, cmd := range c.commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// Get a flag set for the command with the global flags and its own flags.
+// path is the full name of the command shown in the usage
+func (c *command) flagSet(path string, global *config.Flags, w io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(path, flag.ContinueOnError)
+	fs.SetOutput(w)
+	// Defaults are the current values so flags given to a parent command
+	// aren't reset
+	fs.StringVar(&global.File, "config", global.File, "Path to a TOML or YAML config file")
+	fs.StringVar(&global.LogLevel, "loglevel", global.LogLevel, "Set log level")
+	fs.StringVar(&global.LogOutput, "logoutput", global.LogOutput,
+		"Set log destination (file, console or both)")
+	if c.flags != nil {
+		c.flags(fs)
+	}
+	fs.Usage = func() { c.usage(fs, path, w) }
+	return fs
+}
+
+// Write the help for the command
+func (c *command) usage(fs *flag.FlagSet, path string, w io.Writer) {
+	switch {
+	case len(c.commands) > 0:
+		fmt.Fprintf(w, "Usage: %s <command> [flags]\n", path)
+	case c.args != "":
+		fmt.Fprintf(w, "Usage: %s [flags] %s\n", path, c.args)
+	default:
+		fmt.Fprintf(w, "Usage: %s [flags]\n", path)
+	}
+	fmt.Fprintf(w, "\n%s\n", c.summary)
+	if len(c.commands) > 0 {
+		fmt.Fprintln(w, "\nCommands:")
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		for _, cmd := range c.commands {
+			fmt.Fprintf(tw, "  %s\t%s\n", cmd.name, cmd.summary)
+		}
+		tw.Flush()
+		fmt.Fprintf(w, "\nRun '%s <command> -h' for help with a command\n", path)
+	}
+	fmt.Fprintln(w, "\nFlags:")
+	fs.PrintDefaults()
+}
+
+// Find the command to run from the commandline, parsing the flags of each
+// command on the way. Returns a nil command if the help was shown instead
+func (c *command) find(
+	args []string,
+	global *config.Flags,
+	w io.Writer,
+) (*command, []string, error) {
+	cmd, path := c, c.name
+	for {
+		fs := cmd.flagSet(path, global, w)
+		if len(cmd.commands) == 0 {
+			positional, err := parseInterleaved(fs, args)
+			if err != nil {
+				return nil, nil, helpErr(err)
+			}
+			if len(positional) < cmd.minArgs || len(positional) > cmd.maxArgs {
+				fs.Usage()
+				return nil, nil, errors.Errorf("%s: wrong number of arguments", path)
+			}
+			return cmd, positional, nil
+		}
+		if err := fs.Parse(args); err != nil {
+			return nil, nil, helpErr(err)
+		}
+		args = fs.Args()
+		var next *command
+		switch {
+		case len(args) == 0 && cmd.fallback != nil:
+			next = cmd.fallback
+		case len(args) == 0:
+			fs.Usage()
+			return nil, nil, errors.Errorf("%s: missing command", path)
+		case args[0] == "help":
+			fs.Usage()
+			return nil, nil, nil
+		default:
+			next = cmd.sub(args[0])
+			if next == nil {
+				fs.Usage()
+				return nil, nil, errors.Errorf("%s: unknown command %q", path, args[0])
+			}
+			args = args[1:]
+		}
+		cmd, path = next, path+" "+next.name
+	}
+}
+
+// Parse the flags, which can be mixed in with the positional arguments.
+// Everything after "--" is positional
+func parseInterleaved(fs *flag.FlagSet, args []string) ([]string, error) {
+	positional := []string{}
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		rest := fs.Args()
+		if len(rest) == 0 {
+			return positional, nil
+		}
+		if len(rest) < len(args) && args[len(args)-len(rest)-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+// Asking for help isn't an error, the usage has already been shown
+func helpErr(err error) error {
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+	return err
+}
+
+// Load the config and start the logger as the command needs. Returns a
+// function to close the log files
+func (env *cmdEnv) setup(setup int) (func(), error) {
+	if setup == setupNone {
+		return func() {}, nil
+	}
+	env.flags.SkipSecrets = setup == setupConfig
+	cfg, err := config.GetConfig(env.flags)
+	if err != nil {
+		return nil, errors.Wrap(err, "config.GetConfig")
+	}
+
+	closeLogs := func() {
+		for _, file := range env.logFiles {
+			file.Close()
+		}
+	}
+	openLogFile := func(name string) (*logging.LogFile, error) {
+		file, err := logging.OpenLogFile(logging.FileOptions{
+			Dir:            cfg.LogDir,
+			Name:           name,
+			MaxSize:        int64(cfg.LogMaxSize) << 20,
+			RotateInterval: cfg.LogRotateInterval * time.Hour,
+			MaxAge:         time.Duration(cfg.LogMaxAge) * 24 * time.Hour,
+			MaxBackups:     cfg.LogMaxBackups,
+			Compress:       cfg.LogCompress,
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "logging.OpenLogFile")
+		}
+		env.logFiles = append(env.logFiles, file)
+		return file, nil
+	}
+	opts := logging.Options{
+		Level:         cfg.LogLevel,
+		ConsoleFormat: cfg.LogConsoleFormat,
+		FileFormat:    cfg.LogFileFormat,
+		Redact:        cfg.LogRedact,
+		Sample:        map[zerolog.Level]logging.SampleRate{},
+	}
+	if cfg.LogOutput == "both" || cfg.LogOutput == "file" {
+		file, err := openLogFile(cfg.LogFileName())
+		if err != nil {
+			return nil, err
+		}
+		opts.File = file
+	}
+	if cfg.LogErrorFile != "" {
+		file, err := openLogFile(cfg.LogErrorFileName())
+		if err != nil {
+			closeLogs()
+			return nil, err
+		}
+		opts.ErrorSink = file
+	}
+	sampled := map[zerolog.Level]config.Rate{
+		zerolog.DebugLevel: cfg.LogSampleDebug,
+		zerolog.TraceLevel: cfg.LogSampleTrace,
+	}
+	for level, rate := range sampled {
+		if rate.Requests > 0 {
+			opts.Sample[level] = logging.SampleRate{
+				Burst:  uint32(rate.Requests),
+				Period: rate.Window,
+			}
+		}
+	}
+
+	// Logs from the tools go to stderr so their output can be piped
+	if cfg.LogOutput == "both" || cfg.LogOutput == "console" {
+		opts.Console = env.stderr
+		if setup == setupServer {
+			opts.Console = env.stdout
+		}
+	}
+
+	logger, err := logging.GetLogger(opts)
+	if err != nil {
+		closeLogs()
+		return nil, errors.Wrap(err, "logging.GetLogger")
+	}
+	env.config = cfg
+	env.logger = logger
+	return closeLogs, nil
+}
+
+// Connect to the database set in the config
+func (env *cmdEnv) connect() (*db.SafeConn, error) {
+	env.logger.Debug().Msg("Connecting to database")
+	if env.config.DBDriver == "postgres" {
+		conn, err := db.ConnectToPostgres(
+			env.config.DatabaseURL,
+			env.config.DBName,
+			dbOptions(env.config),
+			env.logger,
+		)
+		if err != nil {
+			return nil, errors.Wrap(err, "db.ConnectToPostgres")
+		}
+		return conn, nil
+	}
+	conn, err := db.ConnectToDatabase(env.config.DBName, dbOptions(env.config), env.logger)
+	if err != nil {
+		return nil, errors.Wrap(err, "db.ConnectToDatabase")
+	}
+	return conn, nil
+}
+
+// Parses the commandline, does the shared setup and runs the command
+func run(
+	ctx context.Context,
+	stdin io.Reader,
+	stdout io.Writer,
+	stderr io.Writer,
+	args []string,
+) error {
+	// systemd stops the server with SIGTERM
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	env := &cmdEnv{stdin: stdin, stdout: stdout, stderr: stderr}
+	cmd, positional, err := commandTree(&env.flags).find(args, &env.flags, stderr)
+	if err != nil || cmd == nil {
+		return err
+	}
+	closeLog, err := env.setup(cmd.setup)
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+	if env.logger != nil {
+		env.logger.Debug().Str("command", cmd.name).Msg("Config loaded and logger started")
+	}
+	return cmd.run(ctx, env, positional)
+}
+
+// How long a command's transaction can run for, including waiting for
+// maintenance to finish
+const commandTimeout = 15 * time.Second
+
+// Returned when a command can't write because the database is paused
+var errMaintenance = errors.New("Database is paused for maintenance, try again later")
+
+// Wait until no instance has the database paused for maintenance
+func waitMaintenance(ctx context.Context, config *config.Config) error {
+	for inMaintenance(config) {
+		select {
+		case <-ctx.Done():
+			return errMaintenance
+		case <-time.After(250 * time.Millisecond):
+		}
+	}
+	return nil
+}
+
+// Connect to the database and run fn in a transaction, committing it if fn
+// succeeds. Waits for maintenance to finish first, and rolls back if it
+// started while fn was running
+func withTx(
+	ctx context.Context,
+	env *cmdEnv,
+	fn func(ctx context.Context, tx *db.SafeTX) error,
+) error {
+	ctx, cancel := context.WithTimeout(ctx, commandTimeout)
+	defer cancel()
+	err := waitMaintenance(ctx, env.config)
+	if err != nil {
+		return err
+	}
+	conn, err := env.connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return errors.Wrap(err, "conn.Begin")
+	}
+	err = fn(ctx, tx)
+	if err == nil && inMaintenance(env.config) {
+		err = errMaintenance
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return errors.Wrap(tx.Commit(), "tx.Commit")
+}
+
+// Connect to the database and run fn in a read only transaction
+func withReadTx(
+	ctx context.Context,
+	env *cmdEnv,
+	fn func(ctx context.Context, tx *db.SafeTX) error,
+) error {
+	ctx, cancel := context.WithTimeout(ctx, commandTimeout)
+	defer cancel()
+	conn, err := env.connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	tx, err := conn.BeginRead(ctx)
+	if err != nil {
+		return errors.Wrap(err, "conn.BeginRead")
+	}
+	defer tx.Commit()
+	return fn(ctx, tx)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"projectreshoot/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommandTree(t *testing.T) {
+	find := func(args ...string) (*command, []string, config.Flags, string, error) {
+		var flags config.Flags
+		var out bytes.Buffer
+		cmd, positional, err := commandTree(&flags).find(args, &flags, &out)
+		return cmd, positional, flags, out.String(), err
+	}
+
+	t.Run("No command runs the server", func(t *testing.T) {
+		cmd, _, flags, _, err := find("--config", "app.toml")
+		require.NoError(t, err)
+		assert.Equal(t, "serve", cmd.name)
+		assert.Equal(t, "app.toml", flags.File)
+	})
+	t.Run("Flags can be mixed with arguments", func(t *testing.T) {
+		cmd, args, flags, _, err := find(
+			"--loglevel", "warn", "token", "inspect", "abc", "--config", "x.yaml",
+		)
+		require.NoError(t, err)
+		assert.Equal(t, "inspect", cmd.name)
+		assert.Equal(t, []string{"abc"}, args)
+		assert.Equal(t, "warn", flags.LogLevel)
+		assert.Equal(t, "x.yaml", flags.File)
+	})
+	t.Run("Arguments after -- aren't flags", func(t *testing.T) {
+		_, args, _, _, err := find("token", "revoke", "--", "-abc")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"-abc"}, args)
+	})
+	t.Run("Serve flags override the config", func(t *testing.T) {
+		_, _, flags, _, err := find("serve", "--host", "127.0.0.1", "--port", "4000")
+		require.NoError(t, err)
+		assert.Equal(t, config.Flags{Host: "127.0.0.1", Port: "4000"}, flags)
+	})
+	t.Run("Help is shown without an error", func(t *testing.T) {
+		cmd, _, _, out, err := find("migrate", "-h")
+		require.NoError(t, err)
+		assert.Nil(t, cmd)
+		assert.Contains(t, out, "Usage: projectreshoot migrate <command> [flags]")
+		assert.Contains(t, out, "status")
+
+		cmd, _, _, out, err = find("help")
+		require.NoError(t, err)
+		assert.Nil(t, cmd)
+		assert.Contains(t, out, "version")
+	})
+	t.Run("Unknown commands are errors", func(t *testing.T) {
+		_, _, _, out, err := find("user", "frobnicate")
+		require.ErrorContains(t, err, `unknown command "frobnicate"`)
+		assert.Contains(t, out, "Usage: projectreshoot user")
+
+		_, _, _, _, err = find("--dbver")
+		require.Error(t, err)
+	})
+	t.Run("Argument counts are checked", func(t *testing.T) {
+		_, _, _, _, err := find("token", "inspect")
+		require.ErrorContains(t, err, "wrong number of arguments")
+		_, _, _, _, err = find("migrate", "up-to", "1", "2")
+		require.ErrorContains(t, err, "wrong number of arguments")
+	})
+	t.Run("Version prints the database version", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		err := run(context.Background(), nil, &stdout, &stderr, []string{"version"})
+		require.NoError(t, err)
+		assert.Contains(t, stdout.String(), "Database version: "+config.DBVersion+"\n")
+	})
+}
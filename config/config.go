@@ -2,105 +2,408 @@ package config
 
 import (
 	"errors"
-	"fmt"
-	"os"
+	"io/fs"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
 	"time"
 
-	"projectreshoot/logging"
-
-	"github.com/joho/godotenv"
 	"github.com/rs/zerolog"
 )
 
 type Config struct {
-	Host               string        // Host to listen on
-	Port               string        // Port to listen on
-	TrustedHost        string        // Domain/Hostname to accept as trusted
-	SSL                bool          // Flag for SSL Mode
-	GZIP               bool          // Flag for GZIP compression on requests
-	ReadHeaderTimeout  time.Duration // Timeout for reading request headers in seconds
-	WriteTimeout       time.Duration // Timeout for writing requests in seconds
-	IdleTimeout        time.Duration // Timeout for idle connections in seconds
-	DBName             string        // Filename of the db - hardcoded and doubles as DB version
-	DBLockTimeout      time.Duration // Timeout for acquiring database lock
-	SecretKey          string        // Secret key for signing tokens
-	AccessTokenExpiry  int64         // Access token expiry in minutes
-	RefreshTokenExpiry int64         // Refresh token expiry in minutes
-	TokenFreshTime     int64         // Time for tokens to stay fresh in minutes
-	LogLevel           zerolog.Level // Log level for global logging. Defaults to info
-	LogOutput          string        // "file", "console", or "both". Defaults to console
-	LogDir             string        // Path to create log files
+	Host               string         // Host to listen on
+	Port               string         // Port to listen on
+	Listen             []string       // Addresses to serve the site on, "host:port" or "unix:<path>". Uses HOST and PORT if empty
+	AdminListen        string         // Address to serve metrics, health and maintenance on. Disabled if empty
+	UnixSocketMode     fs.FileMode    // Permissions of the Unix sockets. Defaults to 0660
+	UnixSocketOwner    string         // "user:group" to own the Unix sockets. Unchanged if empty
+	TrustedHost        string         // Domain/Hostname to accept as trusted
+	TrustedProxies     []netip.Prefix // Proxies whose forwarding headers are used for the client IP
+	TrustUnixProxies   bool           // Use the forwarding headers from peers on a Unix socket. Set by "unix" in TRUSTED_PROXIES
+	SSL                bool           // Flag for SSL Mode. Defaults to true when serving TLS
+	TLSCertFile        string         // Path to the TLS certificate. Serves TLS when set
+	TLSKeyFile         string         // Path to the TLS private key
+	TLSMinVersion      string         // Minimum TLS version, "1.2" or "1.3". Defaults to 1.2
+	TLSReloadInterval  time.Duration  // Time between checking the cert and key for changes in seconds
+	TLSRedirectAddr    string         // Address to redirect plain HTTP to HTTPS from. Disabled if empty
+	TLSClientCA        string         // CA file for client certs required on the admin listener
+	Compress           bool           // Flag for compressing responses
+	CompressMinSize    int            // Min response size in bytes to compress
+	CompressTypes      []string       // MIME types to compress
+	FrontendDebug      bool           // Flag for loading the htmx event logging script
+	CSP                string         // Content-Security-Policy. {nonce} is replaced per request
+	CSPReportOnly      bool           // Flag for sending the CSP as Content-Security-Policy-Report-Only
+	HSTSMaxAge         int            // Max age of Strict-Transport-Security in seconds. Sent only in SSL mode
+	Metrics            bool           // Flag for serving Prometheus metrics at /metrics
+	TraceExporter      string         // "none", "otlp", "stdout" or "file". Defaults to none
+	TraceEndpoint      string         // OTLP HTTP endpoint. Uses the OTEL_EXPORTER_OTLP_* envars if empty
+	TraceFile          string         // Path to write spans to with the file exporter
+	TraceSampleRatio   float64        // Ratio of new traces to sample, 0 to 1
+	RateLimitStore     string         // "memory", "db" or "off". The db store is shared by all instances but fails open during maintenance
+	RateLimitClient    Rate           // Requests per client IP to the pages
+	RateLimitAuth      Rate           // Login and register attempts per client IP
+	RateLimitAccount   Rate           // Account changes per user
+	ReadHeaderTimeout  time.Duration  // Timeout for reading request headers in seconds
+	WriteTimeout       time.Duration  // Timeout for writing requests in seconds
+	IdleTimeout        time.Duration  // Timeout for idle connections in seconds
+	ShutdownTimeout    time.Duration  // Time to let requests finish when shutting down in seconds
+	DBName             string         // Filename of the db - hardcoded and doubles as DB version
+	DBDriver           string         // "sqlite" or "postgres". Defaults to sqlite
+	DatabaseURL        string         // Connection string for the postgres driver
+	DBJournalMode      string         // SQLite journal_mode pragma. Defaults to WAL
+	DBBusyTimeout      time.Duration  // SQLite busy_timeout pragma in milliseconds
+	DBSynchronous      string         // SQLite synchronous pragma. Defaults to NORMAL
+	DBForeignKeys      bool           // SQLite foreign_keys pragma
+	DBMaxReadConns     int            // Max open connections in the read pool
+	DBMaxWriteConns    int            // Max open connections in the write pool
+	DBLockTimeout      time.Duration  // Timeout for acquiring database lock
+	JobsEnabled        bool           // Flag for running the scheduled jobs
+	JobJitter          time.Duration  // Max random delay added to job runs in seconds
+	JobTokenCleanup    string         // Schedule for removing expired revoked tokens
+	JobAuditRetention  string         // Schedule for removing old audit log events
+	JobRateLimitPrune  string         // Schedule for removing expired rate limit state
+	AuditRetentionDays int64          // Days to keep audit log events
+	JobBackup          string         // Schedule for database backups
+	BackupDir          string         // Path to write backups to. Backups disabled if empty
+	BackupKeep         int            // Number of automatic backups to keep
+	QueueWorkers       int            // Number of queue workers. 0 disables the workers
+	QueuePollInterval  time.Duration  // Time to wait when the queue is empty in seconds
+	QueueVisibility    time.Duration  // Time a queued job can run before it is reclaimed in seconds
+	QueueMaxAttempts   int            // Default attempts before a queued job is dead
+	QueueBackoff       time.Duration  // Delay before retrying a failed queued job in seconds
+	SecretKey          string         // Secret key for signing tokens
+	AccessTokenExpiry  int64          // Access token expiry in minutes
+	RefreshTokenExpiry int64          // Refresh token expiry in minutes
+	TokenFreshTime     int64          // Time for tokens to stay fresh in minutes
+	LogLevel           zerolog.Level  // Log level for global logging. Defaults to info
+	LogOutput          string         // "file", "console", or "both". Defaults to console
+	LogConsoleFormat   string         // "pretty", "json" or "logfmt". Defaults to pretty
+	LogFileFormat      string         // "json", "pretty" or "logfmt". Defaults to json
+	LogSampleDebug     Rate           // Max debug events logged in a window. No sampling if zero
+	LogSampleTrace     Rate           // Max trace events logged in a window. No sampling if zero
+	LogRedact          []string       // Names of fields to redact in the logs
+	LogErrorFile       string         // Name of a file to also write error logs to. Disabled if empty
+	LogDir             string         // Path to create log files
+	LogFile            string         // Name of the log file. {port} is replaced with PORT
+	LogMaxSize         int            // Size in MB to rotate the log file at. 0 disables
+	LogRotateInterval  time.Duration  // Time to rotate the log file after in hours. 0 disables
+	LogMaxAge          int            // Days to keep rotated log files. 0 keeps them
+	LogMaxBackups      int            // Number of rotated log files to keep. 0 keeps them all
+	LogCompress        bool           // Flag for gzipping rotated log files
+	CrashDir           string         // Path to write crash reports to. Disabled if empty
+	settings           []Setting      // Settings as loaded, for printing
+}
+
+// MIME types compressed by default. Images other than SVG, fonts and
+// archives are already compressed
+var defaultCompressTypes = []string{
+	"text/*",
+	"application/javascript",
+	"application/json",
+	"application/problem+json",
+	"application/xml",
+	"application/wasm",
+	"image/svg+xml",
+}
+
+// Fields redacted from the logs by default
+var defaultLogRedact = []string{
+	"password",
+	"secret",
+	"secret_key",
+	"token",
+	"access_token",
+	"refresh_token",
+	"authorization",
+	"cookie",
+	"database_url",
+}
+
+// Content-Security-Policy used by default. Alpine evaluates its attribute
+// expressions with Function so it needs 'unsafe-eval'
+const defaultCSP = "default-src 'self'; " +
+	"script-src 'self' 'nonce-{nonce}' 'unsafe-eval'; " +
+	"style-src 'self' 'nonce-{nonce}' https://fonts.googleapis.com; " +
+	"font-src 'self' https://fonts.gstatic.com; " +
+	"img-src 'self' data:; " +
+	"connect-src 'self'; " +
+	"object-src 'none'; " +
+	"base-uri 'self'; " +
+	"form-action 'self'; " +
+	"frame-ancestors 'none'"
+
+// Number of requests allowed in a window of time
+type Rate struct {
+	Requests int
+	Window   time.Duration
 }
 
-// Load the application configuration and get a pointer to the Config object
-func GetConfig(args map[string]string) (*Config, error) {
-	godotenv.Load(".env")
-	var (
-		host      string
-		port      string
-		logLevel  zerolog.Level
-		logOutput string
-		valid     bool
-	)
-
-	if args["host"] != "" {
-		host = args["host"]
-	} else {
-		host = GetEnvDefault("HOST", "127.0.0.1")
-	}
-	if args["port"] != "" {
-		port = args["port"]
-	} else {
-		port = GetEnvDefault("PORT", "3010")
-	}
-	if args["loglevel"] != "" {
-		logLevel = logging.GetLogLevel(args["loglevel"])
-	} else {
-		logLevel = logging.GetLogLevel(GetEnvDefault("LOG_LEVEL", "info"))
-	}
-	if args["logoutput"] != "" {
-		opts := map[string]string{
-			"both":    "both",
-			"file":    "file",
-			"console": "console",
+// Parse a rate in the form "<requests>/<window>", i.e. "100/1m"
+func parseRate(value string) (Rate, error) {
+	requests, window, found := strings.Cut(value, "/")
+	if !found {
+		return Rate{}, errors.New("must be <requests>/<window>")
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(requests))
+	if err != nil || count < 1 {
+		return Rate{}, errors.New("requests must be a number greater than 0")
+	}
+	dur, err := time.ParseDuration(strings.TrimSpace(window))
+	if err != nil || dur < time.Second {
+		return Rate{}, errors.New("window must be a duration of at least 1s")
+	}
+	return Rate{Requests: count, Window: dur}, nil
+}
+
+// Parse a list of CIDR prefixes. Single IP addresses are treated as a
+// prefix containing only that address
+func parsePrefixes(list []string) ([]netip.Prefix, error) {
+	prefixes := []netip.Prefix{}
+	for _, item := range list {
+		if !strings.Contains(item, "/") {
+			addr, err := netip.ParseAddr(item)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
 		}
-		logOutput, valid = opts[args["logoutput"]]
-		if !valid {
-			logOutput = "console"
-			fmt.Println(
-				"Log output type was not parsed correctly. Defaulting to console only",
-			)
+		prefix, err := netip.ParsePrefix(item)
+		if err != nil {
+			return nil, err
 		}
-	} else {
-		logOutput = GetEnvDefault("LOG_OUTPUT", "console")
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// Version of the database schema the server needs. Doubles as the SQLite
+// database filename
+const DBVersion = "00005"
+
+// Settings from the commandline that override the other layers. Empty
+// values aren't used
+type Flags struct {
+	File        string // Path to the config file, instead of CONFIG_FILE
+	Host        string // Overrides HOST
+	Port        string // Overrides PORT
+	LogLevel    string // Overrides LOG_LEVEL
+	LogOutput   string // Overrides LOG_OUTPUT
+	SkipSecrets bool   // Don't require SECRET_KEY or DATABASE_URL to be set
+}
+
+// Load the application configuration and get a pointer to the Config object.
+// Settings are layered with defaults, then the config file set by --config
+// or CONFIG_FILE, then .env, then environment variables, then flags.
+// Every invalid setting is reported in the returned error
+func GetConfig(flags Flags) (*Config, error) {
+	overrides := map[string]string{
+		"HOST":       flags.Host,
+		"PORT":       flags.Port,
+		"LOG_LEVEL":  flags.LogLevel,
+		"LOG_OUTPUT": flags.LogOutput,
 	}
-	if logOutput != "both" && logOutput != "console" && logOutput != "file" {
-		logOutput = "console"
+	l, err := newLoader(flags.File, overrides)
+	if err != nil {
+		return nil, errors.New("Failed to read config file: " + err.Error())
 	}
 
 	config := &Config{
-		Host:               host,
-		Port:               port,
-		TrustedHost:        GetEnvDefault("TRUSTED_HOST", "127.0.0.1"),
-		SSL:                GetEnvBool("SSL_MODE", false),
-		GZIP:               GetEnvBool("GZIP", false),
-		ReadHeaderTimeout:  GetEnvDur("READ_HEADER_TIMEOUT", 2),
-		WriteTimeout:       GetEnvDur("WRITE_TIMEOUT", 10),
-		IdleTimeout:        GetEnvDur("IDLE_TIMEOUT", 120),
-		DBName:             "00001",
-		DBLockTimeout:      GetEnvDur("DB_LOCK_TIMEOUT", 60),
-		SecretKey:          os.Getenv("SECRET_KEY"),
-		AccessTokenExpiry:  GetEnvInt64("ACCESS_TOKEN_EXPIRY", 5),
-		RefreshTokenExpiry: GetEnvInt64("REFRESH_TOKEN_EXPIRY", 1440), // defaults to 1 day
-		TokenFreshTime:     GetEnvInt64("TOKEN_FRESH_TIME", 5),
-		LogLevel:           logLevel,
-		LogOutput:          logOutput,
-		LogDir:             GetEnvDefault("LOG_DIR", ""),
-	}
-
-	if config.SecretKey == "" && args["dbver"] != "true" {
-		return nil, errors.New("Envar not set: SECRET_KEY")
+		Host:               l.str("HOST", "127.0.0.1"),
+		Port:               l.str("PORT", "3010"),
+		Listen:             l.addresses("LISTEN", []string{}),
+		AdminListen:        l.address("ADMIN_LISTEN", l.address("METRICS_ADDR", "")),
+		UnixSocketMode:     l.fileMode("UNIX_SOCKET_MODE", 0660),
+		UnixSocketOwner:    l.str("UNIX_SOCKET_OWNER", ""),
+		TrustedHost:        l.str("TRUSTED_HOST", "127.0.0.1"),
+		TLSCertFile:        l.str("TLS_CERT_FILE", ""),
+		TLSKeyFile:         l.str("TLS_KEY_FILE", ""),
+		TLSMinVersion:      l.oneOf("TLS_MIN_VERSION", "1.2", "1.2", "1.3"),
+		TLSReloadInterval:  l.duration("TLS_RELOAD_INTERVAL", 60, time.Second),
+		TLSRedirectAddr:    l.str("TLS_REDIRECT_ADDR", ""),
+		TLSClientCA:        l.str("TLS_CLIENT_CA", ""),
+		Compress:           l.boolean("COMPRESS", l.boolean("GZIP", false)),
+		CompressMinSize:    l.integer("COMPRESS_MIN_SIZE", 1024),
+		CompressTypes:      l.list("COMPRESS_TYPES", defaultCompressTypes),
+		FrontendDebug:      l.boolean("FRONTEND_DEBUG", false),
+		CSP:                l.str("CSP", defaultCSP),
+		CSPReportOnly:      l.boolean("CSP_REPORT_ONLY", false),
+		HSTSMaxAge:         l.integer("HSTS_MAX_AGE", 31536000),
+		Metrics:            l.boolean("METRICS", true),
+		TraceExporter:      l.oneOf("TRACE_EXPORTER", "none", "none", "otlp", "stdout", "file"),
+		TraceEndpoint:      l.str("TRACE_ENDPOINT", ""),
+		TraceFile:          l.str("TRACE_FILE", "traces.json"),
+		TraceSampleRatio:   l.float("TRACE_SAMPLE_RATIO", 1),
+		RateLimitStore:     l.oneOf("RATE_LIMIT_STORE", "memory", "memory", "db", "off"),
+		RateLimitClient:    l.rate("RATE_LIMIT_CLIENT", "100/1m"),
+		RateLimitAuth:      l.rate("RATE_LIMIT_AUTH", "4/1m"),
+		RateLimitAccount:   l.rate("RATE_LIMIT_ACCOUNT", "10/1m"),
+		ReadHeaderTimeout:  l.duration("READ_HEADER_TIMEOUT", 2, time.Second),
+		WriteTimeout:       l.duration("WRITE_TIMEOUT", 10, time.Second),
+		IdleTimeout:        l.duration("IDLE_TIMEOUT", 120, time.Second),
+		ShutdownTimeout:    l.duration("SHUTDOWN_TIMEOUT", 10, time.Second),
+		DBName:             DBVersion,
+		DBDriver:           l.oneOf("DB_DRIVER", "sqlite", "sqlite", "postgres"),
+		DatabaseURL:        l.secret("DATABASE_URL"),
+		DBJournalMode:      strings.ToUpper(l.str("DB_JOURNAL_MODE", "WAL")),
+		DBBusyTimeout:      l.duration("DB_BUSY_TIMEOUT", 5000, time.Millisecond),
+		DBSynchronous:      strings.ToUpper(l.str("DB_SYNCHRONOUS", "NORMAL")),
+		DBForeignKeys:      l.boolean("DB_FOREIGN_KEYS", true),
+		DBMaxReadConns:     l.integer("DB_MAX_READ_CONNS", 4),
+		DBMaxWriteConns:    l.integer("DB_MAX_WRITE_CONNS", 1),
+		DBLockTimeout:      l.duration("DB_LOCK_TIMEOUT", 60, time.Second),
+		JobsEnabled:        l.boolean("JOBS_ENABLED", true),
+		JobJitter:          l.duration("JOB_JITTER", 30, time.Second),
+		JobTokenCleanup:    l.str("JOB_TOKEN_CLEANUP", "*/15 * * * *"),
+		JobAuditRetention:  l.str("JOB_AUDIT_RETENTION", "30 3 * * *"),
+		JobRateLimitPrune:  l.str("JOB_RATE_LIMIT_PRUNE", "*/5 * * * *"),
+		AuditRetentionDays: l.integer64("AUDIT_RETENTION_DAYS", 90),
+		JobBackup:          l.str("JOB_BACKUP", "0 4 * * *"),
+		BackupDir:          l.str("BACKUP_DIR", ""),
+		BackupKeep:         l.integer("BACKUP_KEEP", 7),
+		QueueWorkers:       l.integer("QUEUE_WORKERS", 2),
+		QueuePollInterval:  l.duration("QUEUE_POLL_INTERVAL", 2, time.Second),
+		QueueVisibility:    l.duration("QUEUE_VISIBILITY_TIMEOUT", 300, time.Second),
+		QueueMaxAttempts:   l.integer("QUEUE_MAX_ATTEMPTS", 5),
+		QueueBackoff:       l.duration("QUEUE_BACKOFF", 10, time.Second),
+		SecretKey:          l.secret("SECRET_KEY"),
+		AccessTokenExpiry:  l.integer64("ACCESS_TOKEN_EXPIRY", 5),
+		RefreshTokenExpiry: l.integer64("REFRESH_TOKEN_EXPIRY", 1440), // defaults to 1 day
+		TokenFreshTime:     l.integer64("TOKEN_FRESH_TIME", 5),
+		LogLevel:           l.logLevel("LOG_LEVEL", "info"),
+		LogOutput:          l.oneOf("LOG_OUTPUT", "console", "console", "file", "both"),
+		LogConsoleFormat:   l.oneOf("LOG_CONSOLE_FORMAT", "pretty", "pretty", "json", "logfmt"),
+		LogFileFormat:      l.oneOf("LOG_FILE_FORMAT", "json", "json", "pretty", "logfmt"),
+		LogSampleDebug:     l.optionalRate("LOG_SAMPLE_DEBUG"),
+		LogSampleTrace:     l.optionalRate("LOG_SAMPLE_TRACE"),
+		LogRedact:          l.list("LOG_REDACT", defaultLogRedact),
+		LogErrorFile:       l.str("LOG_ERROR_FILE", ""),
+		LogDir:             l.str("LOG_DIR", ""),
+		LogFile:            l.str("LOG_FILE", "server.log"),
+		LogMaxSize:         l.integer("LOG_MAX_SIZE", 100),
+		LogRotateInterval:  l.duration("LOG_ROTATE_INTERVAL", 24, time.Hour),
+		LogMaxAge:          l.integer("LOG_MAX_AGE", 30),
+		LogMaxBackups:      l.integer("LOG_MAX_BACKUPS", 10),
+		LogCompress:        l.boolean("LOG_COMPRESS", true),
+		CrashDir:           l.str("CRASH_DIR", ""),
+	}
+	config.TrustedProxies, config.TrustUnixProxies =
+		l.proxies("TRUSTED_PROXIES", []string{"127.0.0.1/32", "::1/128"})
+	// Cookies need the Secure flag when the server serves TLS itself
+	config.SSL = l.boolean("SSL_MODE", config.TLSCertFile != "")
+	config.settings = l.settings
+	l.checkUnknown()
+
+	if config.SecretKey == "" && !flags.SkipSecrets {
+		l.problem("SECRET_KEY: must be set")
+	}
+	if config.DBDriver == "postgres" && config.DatabaseURL == "" && !flags.SkipSecrets {
+		l.problem("DATABASE_URL: must be set when DB_DRIVER is postgres")
+	}
+	journalModes := map[string]bool{
+		"DELETE": true, "TRUNCATE": true, "PERSIST": true,
+		"MEMORY": true, "WAL": true, "OFF": true,
+	}
+	if !journalModes[config.DBJournalMode] {
+		l.problem("DB_JOURNAL_MODE: must be one of DELETE, TRUNCATE, PERSIST, MEMORY, WAL or OFF")
+	}
+	syncModes := map[string]bool{"OFF": true, "NORMAL": true, "FULL": true, "EXTRA": true}
+	if !syncModes[config.DBSynchronous] {
+		l.problem("DB_SYNCHRONOUS: must be one of OFF, NORMAL, FULL or EXTRA")
+	}
+	if config.DBMaxReadConns < 1 || config.DBMaxWriteConns < 1 {
+		l.problem("DB_MAX_READ_CONNS and DB_MAX_WRITE_CONNS must be at least 1")
+	}
+	if config.AuditRetentionDays < 1 {
+		l.problem("AUDIT_RETENTION_DAYS: must be at least 1")
+	}
+	if config.QueueWorkers < 0 || config.QueueMaxAttempts < 1 {
+		l.problem("QUEUE_WORKERS must be at least 0 and QUEUE_MAX_ATTEMPTS at least 1")
+	}
+	if config.TraceSampleRatio < 0 || config.TraceSampleRatio > 1 {
+		l.problem("TRACE_SAMPLE_RATIO: must be between 0 and 1")
+	}
+	if (config.TLSCertFile == "") != (config.TLSKeyFile == "") {
+		l.problem("TLS_CERT_FILE and TLS_KEY_FILE: must be set together")
+	}
+	if config.TLSCertFile == "" && (config.TLSRedirectAddr != "" || config.TLSClientCA != "") {
+		l.problem("TLS_REDIRECT_ADDR and TLS_CLIENT_CA: need TLS_CERT_FILE and TLS_KEY_FILE to be set")
+	}
+	if config.TLSClientCA != "" && config.AdminListen == "" {
+		l.problem("TLS_CLIENT_CA: needs ADMIN_LISTEN to be set")
+	}
+	if config.TLSReloadInterval < 1 {
+		l.problem("TLS_RELOAD_INTERVAL: must be at least 1")
+	}
+	if config.HSTSMaxAge < 0 {
+		l.problem("HSTS_MAX_AGE: must be at least 0")
+	}
+	if config.AccessTokenExpiry < 1 {
+		l.problem("ACCESS_TOKEN_EXPIRY: must be at least 1")
+	}
+	if config.RefreshTokenExpiry < config.AccessTokenExpiry {
+		l.problem("REFRESH_TOKEN_EXPIRY: must not be shorter than ACCESS_TOKEN_EXPIRY")
+	}
+	if config.LogFile == "" || strings.ContainsRune(config.LogFile, '/') {
+		l.problem("LOG_FILE: must be a file name, set the directory with LOG_DIR")
+	}
+	if strings.ContainsRune(config.LogErrorFile, '/') {
+		l.problem("LOG_ERROR_FILE: must be a file name, set the directory with LOG_DIR")
+	}
+	if config.LogMaxSize < 0 || config.LogMaxAge < 0 || config.LogMaxBackups < 0 {
+		l.problem("LOG_MAX_SIZE, LOG_MAX_AGE and LOG_MAX_BACKUPS must be at least 0")
+	}
+	if config.TokenFreshTime < 0 {
+		l.problem("TOKEN_FRESH_TIME: must be at least 0")
 	}
 
+	if err := l.err(); err != nil {
+		return nil, err
+	}
 	return config, nil
 }
+
+// Prefix of listen addresses that are Unix socket paths
+const UnixPrefix = "unix:"
+
+// Get the addresses to serve the site on. Uses HOST and PORT if LISTEN
+// isn't set
+func (c *Config) ListenAddrs() []string {
+	if len(c.Listen) > 0 {
+		return c.Listen
+	}
+	return []string{net.JoinHostPort(c.Host, c.Port)}
+}
+
+// Get the port HTTPS is served on, from the first TCP address in
+// ListenAddrs. Used as the target of the HTTPS redirects
+func (c *Config) HTTPSPort() string {
+	for _, addr := range c.ListenAddrs() {
+		if strings.HasPrefix(addr, UnixPrefix) {
+			continue
+		}
+		if _, port, err := net.SplitHostPort(addr); err == nil {
+			return port
+		}
+	}
+	return c.Port
+}
+
+// Get the name of the log file with {port} replaced, so instances sharing
+// LOG_DIR can write to their own files
+func (c *Config) LogFileName() string {
+	return strings.ReplaceAll(c.LogFile, "{port}", c.Port)
+}
+
+// Get the name of the error log file with {port} replaced. Empty if disabled
+func (c *Config) LogErrorFileName() string {
+	return strings.ReplaceAll(c.LogErrorFile, "{port}", c.Port)
+}
+
+// Get the settings in the order they were loaded, with where each value
+// came from
+func (c *Config) Settings() []Setting {
+	return c.settings
+}
@@ -0,0 +1,232 @@
+package config
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Write the contents to a file in a temporary directory
+func writeFile(t *testing.T, name string, contents string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(contents), 0600))
+	return path
+}
+
+func TestGetConfig(t *testing.T) {
+	t.Run("Layers override in order", func(t *testing.T) {
+		path := writeFile(t, "config.toml", `
+secret_key = "from-file"
+port = "4000"
+host = "0.0.0.0"
+trusted_host = "file.example.com"
+compress_types = ["text/html", "text/css"]
+write_timeout = "1m"
+`)
+		t.Setenv("PORT", "5000")
+		t.Setenv("TRUSTED_HOST", "env.example.com")
+		cfg, err := GetConfig(Flags{File: path, Port: "6000"})
+		require.NoError(t, err)
+		assert.Equal(t, "from-file", cfg.SecretKey)
+		assert.Equal(t, "0.0.0.0", cfg.Host)
+		assert.Equal(t, "env.example.com", cfg.TrustedHost)
+		assert.Equal(t, "6000", cfg.Port)
+		assert.Equal(t, []string{"text/html", "text/css"}, cfg.CompressTypes)
+		assert.EqualValues(t, 60, cfg.WriteTimeout)
+
+		sources := map[string]string{}
+		for _, setting := range cfg.Settings() {
+			sources[setting.Key] = setting.Source
+		}
+		assert.Equal(t, SourceFile, sources["HOST"])
+		assert.Equal(t, SourceEnv, sources["TRUSTED_HOST"])
+		assert.Equal(t, SourceFlag, sources["PORT"])
+		assert.Equal(t, SourceDefault, sources["LOG_DIR"])
+	})
+	t.Run("YAML files are read", func(t *testing.T) {
+		path := writeFile(t, "config.yaml", "secret_key: abc\nqueue_workers: 0\n")
+		cfg, err := GetConfig(Flags{File: path})
+		require.NoError(t, err)
+		assert.Equal(t, 0, cfg.QueueWorkers)
+	})
+	t.Run("Secrets are read from files", func(t *testing.T) {
+		t.Setenv("SECRET_KEY_FILE", writeFile(t, "secret", "file-secret\n"))
+		cfg, err := GetConfig(Flags{})
+		require.NoError(t, err)
+		assert.Equal(t, "file-secret", cfg.SecretKey)
+		for _, setting := range cfg.Settings() {
+			if setting.Key == "SECRET_KEY" {
+				assert.True(t, setting.Secret)
+			}
+		}
+
+		t.Setenv("SECRET_KEY", "env-secret")
+		_, err = GetConfig(Flags{})
+		require.ErrorContains(t, err, "can't both be set")
+	})
+	t.Run("Every invalid setting is reported", func(t *testing.T) {
+		path := writeFile(t, "config.toml", "unknown_setting = 1\n")
+		t.Setenv("SECRET_KEY", "")
+		t.Setenv("WRITE_TIMEOUT", "soon")
+		t.Setenv("ACCESS_TOKEN_EXPIRY", "60")
+		t.Setenv("REFRESH_TOKEN_EXPIRY", "30")
+		t.Setenv("COMPRESS", "maybe")
+		t.Setenv("LOG_OUTPUT", "syslog")
+		_, err := GetConfig(Flags{File: path})
+		require.Error(t, err)
+		for _, problem := range []string{
+			"UNKNOWN_SETTING",
+			"SECRET_KEY",
+			"WRITE_TIMEOUT",
+			"REFRESH_TOKEN_EXPIRY",
+			"COMPRESS",
+			"LOG_OUTPUT",
+		} {
+			assert.Contains(t, err.Error(), problem)
+		}
+	})
+	t.Run("Durations must be whole units", func(t *testing.T) {
+		t.Setenv("SECRET_KEY", ".")
+		t.Setenv("DB_BUSY_TIMEOUT", "2s")
+		cfg, err := GetConfig(Flags{})
+		require.NoError(t, err)
+		assert.EqualValues(t, 2000, cfg.DBBusyTimeout)
+
+		t.Setenv("IDLE_TIMEOUT", "1500ms")
+		_, err = GetConfig(Flags{})
+		require.ErrorContains(t, err, "IDLE_TIMEOUT")
+	})
+	t.Run("TLS settings", func(t *testing.T) {
+		t.Setenv("SECRET_KEY", ".")
+		cfg, err := GetConfig(Flags{})
+		require.NoError(t, err)
+		assert.False(t, cfg.SSL)
+
+		t.Setenv("TLS_CERT_FILE", "cert.pem")
+		_, err = GetConfig(Flags{})
+		require.ErrorContains(t, err, "TLS_KEY_FILE")
+
+		t.Setenv("TLS_KEY_FILE", "key.pem")
+		cfg, err = GetConfig(Flags{})
+		require.NoError(t, err)
+		assert.True(t, cfg.SSL, "SSL_MODE defaults to true when serving TLS")
+
+		assert.Equal(t, "3010", cfg.HTTPSPort())
+		t.Setenv("LISTEN", "unix:/run/projectreshoot.sock, :443")
+		cfg, err = GetConfig(Flags{})
+		require.NoError(t, err)
+		assert.Equal(t, "443", cfg.HTTPSPort(), "HTTPS redirects go to the port TLS is served on")
+
+		t.Setenv("TLS_CLIENT_CA", "ca.pem")
+		_, err = GetConfig(Flags{})
+		require.ErrorContains(t, err, "ADMIN_LISTEN")
+	})
+	t.Run("Log file name", func(t *testing.T) {
+		t.Setenv("SECRET_KEY", ".")
+		t.Setenv("PORT", "3011")
+		t.Setenv("LOG_FILE", "server-{port}.log")
+		cfg, err := GetConfig(Flags{})
+		require.NoError(t, err)
+		assert.Equal(t, "server-3011.log", cfg.LogFileName())
+
+		t.Setenv("LOG_FILE", "logs/server.log")
+		_, err = GetConfig(Flags{})
+		require.ErrorContains(t, err, "LOG_FILE")
+	})
+	t.Run("Log output settings", func(t *testing.T) {
+		t.Setenv("SECRET_KEY", ".")
+		t.Setenv("PORT", "3011")
+		cfg, err := GetConfig(Flags{})
+		require.NoError(t, err)
+		assert.Equal(t, "pretty", cfg.LogConsoleFormat)
+		assert.Equal(t, "json", cfg.LogFileFormat)
+		assert.Zero(t, cfg.LogSampleDebug)
+		assert.Contains(t, cfg.LogRedact, "password")
+		assert.Empty(t, cfg.LogErrorFileName())
+
+		t.Setenv("LOG_CONSOLE_FORMAT", "logfmt")
+		t.Setenv("LOG_SAMPLE_DEBUG", "100/1s")
+		t.Setenv("LOG_ERROR_FILE", "errors-{port}.log")
+		cfg, err = GetConfig(Flags{})
+		require.NoError(t, err)
+		assert.Equal(t, "logfmt", cfg.LogConsoleFormat)
+		assert.Equal(t, Rate{Requests: 100, Window: time.Second}, cfg.LogSampleDebug)
+		assert.Equal(t, "errors-3011.log", cfg.LogErrorFileName())
+
+		t.Setenv("LOG_FILE_FORMAT", "xml")
+		_, err = GetConfig(Flags{})
+		require.ErrorContains(t, err, "LOG_FILE_FORMAT")
+	})
+	t.Run("Listen addresses", func(t *testing.T) {
+		t.Setenv("SECRET_KEY", ".")
+		t.Setenv("HOST", "0.0.0.0")
+		t.Setenv("PORT", "4000")
+		t.Setenv("METRICS_ADDR", "127.0.0.1:9090")
+		cfg, err := GetConfig(Flags{})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"0.0.0.0:4000"}, cfg.ListenAddrs())
+		assert.Equal(t, "127.0.0.1:9090", cfg.AdminListen, "METRICS_ADDR is used if ADMIN_LISTEN isn't set")
+		assert.EqualValues(t, 0660, cfg.UnixSocketMode)
+		assert.False(t, cfg.TrustUnixProxies)
+
+		t.Setenv("LISTEN", "127.0.0.1:3010, unix:/run/projectreshoot.sock")
+		t.Setenv("TRUSTED_PROXIES", "unix, 10.0.0.0/8")
+		t.Setenv("ADMIN_LISTEN", "unix:/run/projectreshoot-admin.sock")
+		t.Setenv("UNIX_SOCKET_MODE", "0600")
+		cfg, err = GetConfig(Flags{})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"127.0.0.1:3010", "unix:/run/projectreshoot.sock"}, cfg.ListenAddrs())
+		assert.Equal(t, "unix:/run/projectreshoot-admin.sock", cfg.AdminListen)
+		assert.EqualValues(t, 0600, cfg.UnixSocketMode)
+		assert.True(t, cfg.TrustUnixProxies)
+		assert.Equal(t, []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, cfg.TrustedProxies)
+
+		t.Setenv("LISTEN", "3010")
+		t.Setenv("UNIX_SOCKET_MODE", "rw")
+		_, err = GetConfig(Flags{})
+		require.ErrorContains(t, err, "LISTEN")
+		require.ErrorContains(t, err, "UNIX_SOCKET_MODE")
+	})
+}
+
+func TestReload(t *testing.T) {
+	t.Setenv("SECRET_KEY", ".")
+	t.Setenv("LOG_LEVEL", "info")
+	current, err := GetConfig(Flags{})
+	require.NoError(t, err)
+
+	t.Run("Reloadable settings are applied", func(t *testing.T) {
+		t.Setenv("LOG_LEVEL", "debug")
+		t.Setenv("TRUSTED_HOST", "example.com")
+		t.Setenv("PORT", "4000")
+		t.Setenv("DB_DRIVER", "postgres")
+		t.Setenv("DATABASE_URL", "postgres://localhost/db")
+		result, err := current.Reload(Flags{})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"LOG_LEVEL", "TRUSTED_HOST"}, result.Changed)
+		assert.Equal(t, []string{"DATABASE_URL", "DB_DRIVER", "PORT"}, result.Rejected)
+		assert.Equal(t, "example.com", result.Config.TrustedHost)
+		assert.Equal(t, "3010", result.Config.Port)
+		assert.Equal(t, "sqlite", result.Config.DBDriver)
+		// The current config is left alone
+		assert.Equal(t, "127.0.0.1", current.TrustedHost)
+		for _, setting := range result.Config.Settings() {
+			if setting.Key == "TRUSTED_HOST" {
+				assert.Equal(t, SourceEnv, setting.Source)
+			}
+			if setting.Key == "PORT" {
+				assert.Equal(t, SourceDefault, setting.Source)
+			}
+		}
+	})
+	t.Run("Invalid configs aren't applied", func(t *testing.T) {
+		t.Setenv("ACCESS_TOKEN_EXPIRY", "soon")
+		_, err := current.Reload(Flags{})
+		require.Error(t, err)
+	})
+}
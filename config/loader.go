@@ -0,0 +1,474 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"projectreshoot/logging"
+
+	"github.com/BurntSushi/toml"
+	"github.com/joho/godotenv"
+	"github.com/rs/zerolog"
+	"gopkg.in/yaml.v3"
+)
+
+// Where the value of a setting came from, lowest precedence first
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceDotenv  = ".env"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+// A setting as it was loaded into the config
+type Setting struct {
+	Key    string // Name of the environment variable
+	Value  string // Value before parsing
+	Source string // SourceDefault, SourceFile, SourceDotenv, SourceEnv or SourceFlag
+	Secret bool   // True if the value should be hidden when printed
+}
+
+// Loads settings from the layers, collecting an error for every invalid
+// value so they can all be reported at once
+type loader struct {
+	file     map[string]string // Settings from the config file
+	dotenv   map[string]string // Settings from the .env file
+	flags    map[string]string // Settings from the commandline flags
+	used     map[string]bool   // Keys that have been looked up
+	settings []Setting         // Settings in the order they were loaded
+	errs     []error           // Problems found while loading
+}
+
+// Create a loader using the config file at path, the .env file if it
+// exists and the commandline flags. If path is empty the config file is set
+// by CONFIG_FILE in the environment or .env, if set
+func newLoader(path string, flags map[string]string) (*loader, error) {
+	// Read rather than loaded into the environment so changes are picked up
+	// when the config is reloaded
+	dotenv, err := godotenv.Read(".env")
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	l := &loader{
+		file:   map[string]string{},
+		dotenv: dotenv,
+		flags:  flags,
+		used:   map[string]bool{},
+	}
+	if path == "" {
+		path, _, _ = l.lookup("CONFIG_FILE")
+	}
+	if path == "" {
+		return l, nil
+	}
+	file, err := readConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	l.file = file
+	return l, nil
+}
+
+// Read a TOML or YAML config file, chosen by its extension. Keys are the
+// environment variable names in any case, values are converted to strings
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	raw := map[string]any{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	default:
+		return nil, errors.New("config file must be .toml, .yaml or .yml")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	file := map[string]string{}
+	for key, value := range raw {
+		str, err := fileValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", path, key, err)
+		}
+		file[strings.ToUpper(key)] = str
+	}
+	return file, nil
+}
+
+// Convert a value from a config file into the string form used by the
+// environment variables. Lists are joined with commas
+func fileValue(value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool, int, int64, uint64, float64:
+		return fmt.Sprint(v), nil
+	case []any:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			str, err := fileValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, str)
+		}
+		return strings.Join(items, ","), nil
+	default:
+		return "", fmt.Errorf("unsupported value of type %T", value)
+	}
+}
+
+// Get the raw value of the key from the highest precedence layer it is set
+// in. Returns false if the key isn't set
+func (l *loader) lookup(key string) (string, string, bool) {
+	l.used[key] = true
+	if val, ok := l.flags[key]; ok && val != "" {
+		return val, SourceFlag, true
+	}
+	if val, ok := os.LookupEnv(key); ok {
+		return val, SourceEnv, true
+	}
+	if val, ok := l.dotenv[key]; ok {
+		return val, SourceDotenv, true
+	}
+	if val, ok := l.file[key]; ok {
+		return val, SourceFile, true
+	}
+	return "", SourceDefault, false
+}
+
+// Get the raw value of the key, recording the setting. Returns false if the
+// default was used
+func (l *loader) value(key string, defaultValue string, secret bool) (string, bool) {
+	val, source, ok := l.lookup(key)
+	if !ok {
+		val = defaultValue
+	}
+	l.settings = append(l.settings, Setting{
+		Key:    key,
+		Value:  val,
+		Source: source,
+		Secret: secret,
+	})
+	return val, ok
+}
+
+// Record that the value of key is invalid
+func (l *loader) fail(key string, val string, reason string) {
+	l.errs = append(l.errs, fmt.Errorf("%s: invalid value %q: %s", key, val, reason))
+}
+
+// Record a problem with the config that isn't about a single value
+func (l *loader) problem(msg string) {
+	l.errs = append(l.errs, errors.New(msg))
+}
+
+// Get a string setting
+func (l *loader) str(key string, defaultValue string) string {
+	val, _ := l.value(key, defaultValue, false)
+	return val
+}
+
+// Get a string setting that must be one of the options
+func (l *loader) oneOf(key string, defaultValue string, options ...string) string {
+	val, _ := l.value(key, defaultValue, false)
+	for _, option := range options {
+		if val == option {
+			return val
+		}
+	}
+	l.fail(key, val, "must be one of "+strings.Join(options, ", "))
+	return defaultValue
+}
+
+// Get a secret setting. The value can be read from the file named by
+// <key>_FILE instead, with trailing whitespace removed
+func (l *loader) secret(key string) string {
+	path, hasFile := l.value(key+"_FILE", "", false)
+	val, set := l.value(key, "", true)
+	if !hasFile || path == "" {
+		return val
+	}
+	if set {
+		l.problem(key + " and " + key + "_FILE can't both be set")
+		return val
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		l.fail(key+"_FILE", path, err.Error())
+		return ""
+	}
+	val = strings.TrimRight(string(data), "\r\n\t ")
+	// Show the secret as coming from wherever <key>_FILE was set
+	l.settings[len(l.settings)-1].Value = val
+	l.settings[len(l.settings)-1].Source = l.settings[len(l.settings)-2].Source
+	return val
+}
+
+// Get an int setting
+func (l *loader) integer(key string, defaultValue int) int {
+	val, ok := l.value(key, strconv.Itoa(defaultValue), false)
+	if !ok {
+		return defaultValue
+	}
+	intVal, err := strconv.Atoi(strings.TrimSpace(val))
+	if err != nil {
+		l.fail(key, val, "must be a whole number")
+		return defaultValue
+	}
+	return intVal
+}
+
+// Get an int64 setting
+func (l *loader) integer64(key string, defaultValue int64) int64 {
+	val, ok := l.value(key, strconv.FormatInt(defaultValue, 10), false)
+	if !ok {
+		return defaultValue
+	}
+	intVal, err := strconv.ParseInt(strings.TrimSpace(val), 10, 64)
+	if err != nil {
+		l.fail(key, val, "must be a whole number")
+		return defaultValue
+	}
+	return intVal
+}
+
+// Get a float64 setting
+func (l *loader) float(key string, defaultValue float64) float64 {
+	val, ok := l.value(key, strconv.FormatFloat(defaultValue, 'g', -1, 64), false)
+	if !ok {
+		return defaultValue
+	}
+	floatVal, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+	if err != nil {
+		l.fail(key, val, "must be a number")
+		return defaultValue
+	}
+	return floatVal
+}
+
+// Values accepted for boolean settings
+var (
+	truthy = map[string]bool{
+		"true": true, "t": true, "yes": true, "y": true, "on": true, "1": true,
+		"enable": true, "enabled": true, "active": true, "affirmative": true,
+	}
+	falsy = map[string]bool{
+		"false": true, "f": true, "no": true, "n": true, "off": true, "0": true,
+		"disable": true, "disabled": true, "inactive": true, "negative": true,
+	}
+)
+
+// Get a boolean setting
+func (l *loader) boolean(key string, defaultValue bool) bool {
+	val, ok := l.value(key, strconv.FormatBool(defaultValue), false)
+	if !ok {
+		return defaultValue
+	}
+	normalized := strings.TrimSpace(strings.ToLower(val))
+	if truthy[normalized] {
+		return true
+	}
+	if falsy[normalized] {
+		return false
+	}
+	l.fail(key, val, "must be true or false")
+	return defaultValue
+}
+
+// Get a duration setting as a number of units, i.e. seconds. Accepts a
+// plain number of units or a Go duration string like "1m30s" that is a
+// whole number of units
+func (l *loader) duration(key string, defaultValue time.Duration, unit time.Duration) time.Duration {
+	val, ok := l.value(key, strconv.FormatInt(int64(defaultValue), 10), false)
+	if !ok {
+		return defaultValue
+	}
+	val = strings.TrimSpace(val)
+	if intVal, err := strconv.ParseInt(val, 10, 64); err == nil {
+		if intVal < 0 {
+			l.fail(key, val, "must not be negative")
+			return defaultValue
+		}
+		return time.Duration(intVal)
+	}
+	dur, err := time.ParseDuration(val)
+	if err != nil {
+		l.fail(key, val, "must be a number of "+unitName(unit)+" or a duration like 1m30s")
+		return defaultValue
+	}
+	if dur < 0 || dur%unit != 0 {
+		l.fail(key, val, "must be a positive whole number of "+unitName(unit))
+		return defaultValue
+	}
+	return dur / unit
+}
+
+// Name of the unit for error messages
+func unitName(unit time.Duration) string {
+	switch unit {
+	case time.Millisecond:
+		return "milliseconds"
+	case time.Minute:
+		return "minutes"
+	case time.Hour:
+		return "hours"
+	default:
+		return "seconds"
+	}
+}
+
+// Get a comma separated list setting. Whitespace around items is removed
+// and empty items are skipped
+func (l *loader) list(key string, defaultValue []string) []string {
+	val, ok := l.value(key, strings.Join(defaultValue, ","), false)
+	if !ok {
+		return defaultValue
+	}
+	list := []string{}
+	for _, item := range strings.Split(val, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// Get a listen address setting, either "host:port" or "unix:<path>"
+func (l *loader) address(key string, defaultValue string) string {
+	val := l.str(key, defaultValue)
+	if val == "" {
+		return val
+	}
+	if err := checkAddress(val); err != nil {
+		l.fail(key, val, err.Error())
+		return defaultValue
+	}
+	return val
+}
+
+// Get a list of listen addresses setting
+func (l *loader) addresses(key string, defaultValue []string) []string {
+	list := l.list(key, defaultValue)
+	for _, addr := range list {
+		if err := checkAddress(addr); err != nil {
+			l.fail(key, addr, err.Error())
+			return defaultValue
+		}
+	}
+	return list
+}
+
+// Check an address is "host:port" or "unix:<path>"
+func checkAddress(addr string) error {
+	if path, ok := strings.CutPrefix(addr, UnixPrefix); ok {
+		if path == "" {
+			return errors.New("unix socket path must not be empty")
+		}
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return errors.New(`must be "host:port" or "unix:<path>"`)
+	}
+	return nil
+}
+
+// Get a file permissions setting in octal, i.e. 0660
+func (l *loader) fileMode(key string, defaultValue fs.FileMode) fs.FileMode {
+	val, ok := l.value(key, fmt.Sprintf("%#o", defaultValue), false)
+	if !ok {
+		return defaultValue
+	}
+	mode, err := strconv.ParseUint(strings.TrimSpace(val), 8, 32)
+	if err != nil || mode > 0777 {
+		l.fail(key, val, "must be octal permissions like 0660")
+		return defaultValue
+	}
+	return fs.FileMode(mode)
+}
+
+// Get the trusted proxies setting, a list of CIDR prefixes or addresses.
+// Returns true as well if the list has "unix" to trust Unix socket peers
+func (l *loader) proxies(key string, defaultValue []string) ([]netip.Prefix, bool) {
+	list := l.list(key, defaultValue)
+	unix := slices.Contains(list, "unix")
+	prefixes, err := parsePrefixes(slices.DeleteFunc(slices.Clone(list), func(item string) bool {
+		return item == "unix"
+	}))
+	if err != nil {
+		l.fail(key, strings.Join(list, ","), err.Error())
+		prefixes, _ = parsePrefixes(defaultValue)
+		unix = false
+	}
+	return prefixes, unix
+}
+
+// Get a rate setting in the form "<requests>/<window>"
+func (l *loader) rate(key string, defaultValue string) Rate {
+	val, _ := l.value(key, defaultValue, false)
+	rate, err := parseRate(val)
+	if err != nil {
+		l.fail(key, val, err.Error())
+		rate, _ = parseRate(defaultValue)
+	}
+	return rate
+}
+
+// Get a rate setting that can be left empty. Returns the zero Rate if empty
+func (l *loader) optionalRate(key string) Rate {
+	val, _ := l.value(key, "", false)
+	if strings.TrimSpace(val) == "" {
+		return Rate{}
+	}
+	rate, err := parseRate(val)
+	if err != nil {
+		l.fail(key, val, err.Error())
+	}
+	return rate
+}
+
+// Get a log level setting
+func (l *loader) logLevel(key string, defaultValue string) zerolog.Level {
+	val, _ := l.value(key, defaultValue, false)
+	level, err := logging.ParseLogLevel(val)
+	if err != nil {
+		l.fail(key, val, err.Error())
+		level, _ = logging.ParseLogLevel(defaultValue)
+	}
+	return level
+}
+
+// Record an error for every key in the config file that isn't a setting
+func (l *loader) checkUnknown() {
+	unknown := []string{}
+	for key := range l.file {
+		if !l.used[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		l.problem(key + ": unknown setting in config file")
+	}
+}
+
+// Get all the problems found while loading, or nil if there were none
+func (l *loader) err() error {
+	return errors.Join(l.errs...)
+}
@@ -0,0 +1,79 @@
+package config
+
+import "sort"
+
+// Settings that can be changed by reloading the config, and how to copy
+// each from the reloaded config. Everything else is used when the server
+// starts, like the listen address or database, and needs a restart
+var reloadable = map[string]func(dst *Config, src *Config){
+	"LOG_LEVEL":    func(dst, src *Config) { dst.LogLevel = src.LogLevel },
+	"TRUSTED_HOST": func(dst, src *Config) { dst.TrustedHost = src.TrustedHost },
+	"TRUSTED_PROXIES": func(dst, src *Config) {
+		dst.TrustedProxies, dst.TrustUnixProxies = src.TrustedProxies, src.TrustUnixProxies
+	},
+	"SSL_MODE":             func(dst, src *Config) { dst.SSL = src.SSL },
+	"COMPRESS":             func(dst, src *Config) { dst.Compress = src.Compress },
+	"GZIP":                 func(dst, src *Config) { dst.Compress = src.Compress },
+	"COMPRESS_MIN_SIZE":    func(dst, src *Config) { dst.CompressMinSize = src.CompressMinSize },
+	"COMPRESS_TYPES":       func(dst, src *Config) { dst.CompressTypes = src.CompressTypes },
+	"FRONTEND_DEBUG":       func(dst, src *Config) { dst.FrontendDebug = src.FrontendDebug },
+	"CSP":                  func(dst, src *Config) { dst.CSP = src.CSP },
+	"CSP_REPORT_ONLY":      func(dst, src *Config) { dst.CSPReportOnly = src.CSPReportOnly },
+	"HSTS_MAX_AGE":         func(dst, src *Config) { dst.HSTSMaxAge = src.HSTSMaxAge },
+	"RATE_LIMIT_CLIENT":    func(dst, src *Config) { dst.RateLimitClient = src.RateLimitClient },
+	"RATE_LIMIT_AUTH":      func(dst, src *Config) { dst.RateLimitAuth = src.RateLimitAuth },
+	"RATE_LIMIT_ACCOUNT":   func(dst, src *Config) { dst.RateLimitAccount = src.RateLimitAccount },
+	"ACCESS_TOKEN_EXPIRY":  func(dst, src *Config) { dst.AccessTokenExpiry = src.AccessTokenExpiry },
+	"REFRESH_TOKEN_EXPIRY": func(dst, src *Config) { dst.RefreshTokenExpiry = src.RefreshTokenExpiry },
+	"TOKEN_FRESH_TIME":     func(dst, src *Config) { dst.TokenFreshTime = src.TokenFreshTime },
+}
+
+// Outcome of reloading the config
+type Reload struct {
+	Config   *Config  // Config to use from now on
+	Changed  []string // Settings that changed and were applied
+	Rejected []string // Settings that changed but need a restart to apply
+}
+
+// Load the config again with the same flags and get a new config with the
+// changes to the reloadable settings applied. Settings that need a restart
+// keep their current values and are listed in Rejected. The current config
+// is left untouched so it can keep being used until the new one is swapped in
+func (c *Config) Reload(flags Flags) (*Reload, error) {
+	loaded, err := GetConfig(flags)
+	if err != nil {
+		return nil, err
+	}
+	current := map[string]Setting{}
+	for _, setting := range c.settings {
+		current[setting.Key] = setting
+	}
+
+	next := *c
+	next.settings = make([]Setting, len(c.settings))
+	copy(next.settings, c.settings)
+	index := map[string]int{}
+	for i, setting := range next.settings {
+		index[setting.Key] = i
+	}
+	result := &Reload{Config: &next}
+	for _, setting := range loaded.settings {
+		old, ok := current[setting.Key]
+		if ok && old.Value == setting.Value {
+			continue
+		}
+		apply, canReload := reloadable[setting.Key]
+		if !canReload {
+			result.Rejected = append(result.Rejected, setting.Key)
+			continue
+		}
+		apply(&next, loaded)
+		if i, ok := index[setting.Key]; ok {
+			next.settings[i] = setting
+		}
+		result.Changed = append(result.Changed, setting.Key)
+	}
+	sort.Strings(result.Changed)
+	sort.Strings(result.Rejected)
+	return result, nil
+}
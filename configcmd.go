@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"projectreshoot/config"
+
+	"github.com/pkg/errors"
+)
+
+// Shown in place of secrets by config print --redacted
+const redactedValue = "[redacted]"
+
+// Options for the config print command
+type configPrintOptions struct {
+	Redacted bool // Hide the values of secrets
+}
+
+// Check or print the config without starting the server. Loads the config
+// itself so every problem can be listed
+func configCommand() *command {
+	var printOpts configPrintOptions
+	return &command{
+		name:    "config",
+		summary: "Check or print the config",
+		commands: []*command{
+			{
+				name:    "check",
+				summary: "Validate the config, listing every problem",
+				run: func(ctx context.Context, env *cmdEnv, args []string) error {
+					return configCheck(env)
+				},
+			},
+			{
+				name:    "print",
+				summary: "Print every setting and where it came from",
+				flags: func(fs *flag.FlagSet) {
+					fs.BoolVar(&printOpts.Redacted, "redacted", false, "Hide the values of secrets")
+				},
+				run: func(ctx context.Context, env *cmdEnv, args []string) error {
+					return configPrint(env, printOpts)
+				},
+			},
+		},
+	}
+}
+
+// Validate the config, listing every problem
+func configCheck(env *cmdEnv) error {
+	_, err := config.GetConfig(env.flags)
+	if err != nil {
+		fmt.Fprintln(env.stdout, "Config is invalid:")
+		for _, problem := range strings.Split(err.Error(), "\n") {
+			fmt.Fprintf(env.stdout, "  %s\n", problem)
+		}
+		return errors.New("config check failed")
+	}
+	fmt.Fprintln(env.stdout, "Config is valid")
+	return nil
+}
+
+// Print every setting and where it came from
+func configPrint(env *cmdEnv, opts configPrintOptions) error {
+	cfg, err := config.GetConfig(env.flags)
+	if err != nil {
+		return errors.Wrap(err, "config.GetConfig")
+	}
+	tw := tabwriter.NewWriter(env.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tSOURCE\tVALUE")
+	for _, setting := range cfg.Settings() {
+		value := setting.Value
+		if opts.Redacted && setting.Secret && value != "" {
+			value = redactedValue
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%q\n", setting.Key, setting.Source, value)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,18 @@
+package contexts
+
+import (
+	"context"
+	"net/netip"
+)
+
+// Set the resolved IP address of the client
+func SetClientIP(ctx context.Context, ip netip.Addr) context.Context {
+	return context.WithValue(ctx, contextKeyClientIP, ip)
+}
+
+// Get the resolved IP address of the client. Returns the zero Addr if not
+// set
+func GetClientIP(ctx context.Context) netip.Addr {
+	ip, _ := ctx.Value(contextKeyClientIP).(netip.Addr)
+	return ip
+}
@@ -9,4 +9,8 @@ func (c contextKey) String() string {
 var (
 	contextKeyAuthorizedUser = contextKey("auth-user")
 	contextKeyRequestTime    = contextKey("req-time")
+	contextKeyNonce          = contextKey("csp-nonce")
+	contextKeyRequestID      = contextKey("request-id")
+	contextKeyLogger         = contextKey("logger")
+	contextKeyClientIP       = contextKey("client-ip")
 )
@@ -0,0 +1,21 @@
+package contexts
+
+import (
+	"context"
+
+	"github.com/a-h/templ"
+)
+
+// Set the Content-Security-Policy nonce for the request. The nonce is also
+// set for templ so script components rendered with the context use it
+func SetNonce(ctx context.Context, nonce string) context.Context {
+	ctx = context.WithValue(ctx, contextKeyNonce, nonce)
+	return templ.WithNonce(ctx, nonce)
+}
+
+// Get the Content-Security-Policy nonce for the request. Returns an empty
+// string if not set
+func GetNonce(ctx context.Context) string {
+	nonce, _ := ctx.Value(contextKeyNonce).(string)
+	return nonce
+}
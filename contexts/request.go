@@ -0,0 +1,33 @@
+package contexts
+
+import (
+	"context"
+
+	"github.com/rs/zerolog"
+)
+
+// Set the ID of the request
+func SetRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKeyRequestID, id)
+}
+
+// Get the ID of the request. Returns an empty string if not set
+func GetRequestID(ctx context.Context) string {
+	id, _ := ctx.Value(contextKeyRequestID).(string)
+	return id
+}
+
+// Set the logger for the request
+func SetLogger(ctx context.Context, logger *zerolog.Logger) context.Context {
+	return context.WithValue(ctx, contextKeyLogger, logger)
+}
+
+// Get the logger for the request, with the request ID and details attached.
+// Returns fallback if not set
+func GetLogger(ctx context.Context, fallback *zerolog.Logger) *zerolog.Logger {
+	logger, ok := ctx.Value(contextKeyLogger).(*zerolog.Logger)
+	if !ok {
+		return fallback
+	}
+	return logger
+}
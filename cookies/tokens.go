@@ -7,6 +7,7 @@ import (
 	"projectreshoot/config"
 	"projectreshoot/db"
 	"projectreshoot/jwt"
+	"projectreshoot/tracing"
 
 	"github.com/pkg/errors"
 )
@@ -62,6 +63,8 @@ func SetTokenCookies(
 	fresh bool,
 	rememberMe bool,
 ) error {
+	_, span := tracing.Start(r.Context(), "jwt.GenerateTokens")
+	defer span.End()
 	at, atexp, err := jwt.GenerateAccessToken(config, user, fresh, rememberMe)
 	if err != nil {
 		return errors.Wrap(err, "jwt.GenerateAccessToken")
@@ -1,58 +1,150 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"net/url"
 	"strconv"
+	"time"
 
+	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
 
 	_ "modernc.org/sqlite"
 )
 
-// Returns a database connection handle for the DB
+// Connection pool and pragma settings for the database
+type Options struct {
+	JournalMode   string        // SQLite journal_mode pragma, i.e. WAL or DELETE
+	BusyTimeout   time.Duration // SQLite busy_timeout pragma
+	Synchronous   string        // SQLite synchronous pragma, i.e. NORMAL or FULL
+	ForeignKeys   bool          // SQLite foreign_keys pragma
+	MaxReadConns  int           // Max open connections in the read pool
+	MaxWriteConns int           // Max open connections in the write pool
+}
+
+// Build the SQLite connection string for the database file. The pragmas are
+// applied by the driver on every new connection in the pool
+func sqliteDSN(dbName string, opts Options, readOnly bool) string {
+	params := url.Values{}
+	params.Add("_pragma", fmt.Sprintf("busy_timeout(%d)", opts.BusyTimeout.Milliseconds()))
+	params.Add("_pragma", fmt.Sprintf("foreign_keys(%t)", opts.ForeignKeys))
+	params.Add("_pragma", fmt.Sprintf("synchronous(%s)", opts.Synchronous))
+	if readOnly {
+		params.Add("mode", "ro")
+		params.Add("_pragma", "query_only(true)")
+	} else {
+		params.Add("_pragma", fmt.Sprintf("journal_mode(%s)", opts.JournalMode))
+		// Take the write lock at the start of the transaction to avoid
+		// SQLITE_BUSY errors when a deferred transaction upgrades to a writer
+		params.Add("_txlock", "immediate")
+	}
+	return fmt.Sprintf("file:%s.db?%s", dbName, params.Encode())
+}
+
+// Returns a database connection handle for the DB. Opens separate pools for
+// read only and read/write transactions
 func ConnectToDatabase(
 	dbName string,
+	opts Options,
 	logger *zerolog.Logger,
 ) (*SafeConn, error) {
-	file := fmt.Sprintf("file:%s.db", dbName)
-	db, err := sql.Open("sqlite", file)
+	version, err := strconv.Atoi(dbName)
+	if err != nil {
+		return nil, errors.Wrap(err, "strconv.Atoi")
+	}
+	// Write pool is opened first so the journal mode is set before any
+	// readers connect
+	db, err := sql.Open("sqlite", sqliteDSN(dbName, opts, false))
 	if err != nil {
 		return nil, errors.Wrap(err, "sql.Open")
 	}
+	db.SetMaxOpenConns(opts.MaxWriteConns)
+	db.SetMaxIdleConns(opts.MaxWriteConns)
+	err = checkDBVersion(db, version)
+	if err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "checkDBVersion")
+	}
+	readDB, err := sql.Open("sqlite", sqliteDSN(dbName, opts, true))
+	if err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "sql.Open")
+	}
+	readDB.SetMaxOpenConns(opts.MaxReadConns)
+	readDB.SetMaxIdleConns(opts.MaxReadConns)
+
+	conn := MakeSafeWithPools(db, readDB, SQLite, logger)
+	err = conn.Prepare(context.Background(), SQLite.Statements()...)
+	if err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "conn.Prepare")
+	}
+	return conn, nil
+}
+
+// Returns a database connection handle for a PostgreSQL database. dbName is
+// only used as the expected database version
+func ConnectToPostgres(
+	databaseURL string,
+	dbName string,
+	opts Options,
+	logger *zerolog.Logger,
+) (*SafeConn, error) {
 	version, err := strconv.Atoi(dbName)
 	if err != nil {
 		return nil, errors.Wrap(err, "strconv.Atoi")
 	}
+	db, err := sql.Open("pgx", databaseURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "sql.Open")
+	}
+	db.SetMaxOpenConns(opts.MaxReadConns + opts.MaxWriteConns)
+	db.SetMaxIdleConns(opts.MaxReadConns + opts.MaxWriteConns)
 	err = checkDBVersion(db, version)
 	if err != nil {
+		db.Close()
 		return nil, errors.Wrap(err, "checkDBVersion")
 	}
-	conn := MakeSafe(db, logger)
+	conn := MakeSafeWithBackend(db, Postgres, logger)
+	err = conn.Prepare(context.Background(), Postgres.Statements()...)
+	if err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "conn.Prepare")
+	}
 	return conn, nil
 }
 
 // Check the database version
 func checkDBVersion(db *sql.DB, expectVer int) error {
-	query := `SELECT version_id FROM goose_db_version WHERE is_applied = 1
+	version, err := getDBVersion(context.Background(), db)
+	if err != nil {
+		return errors.Wrap(err, "getDBVersion")
+	}
+	if version != expectVer {
+		return errors.New("Version mismatch")
+	}
+	return nil
+}
+
+// Get the latest applied migration version of the database
+func getDBVersion(ctx context.Context, db *sql.DB) (int, error) {
+	query := `SELECT version_id FROM goose_db_version WHERE is_applied = TRUE
     ORDER BY version_id DESC LIMIT 1`
-	rows, err := db.Query(query)
+	rows, err := db.QueryContext(ctx, query)
 	if err != nil {
-		return errors.Wrap(err, "checkDBVersion")
+		return 0, errors.Wrap(err, "db.QueryContext")
 	}
 	defer rows.Close()
-	if rows.Next() {
-		var version int
-		err = rows.Scan(&version)
-		if err != nil {
-			return errors.Wrap(err, "rows.Scan")
-		}
-		if version != expectVer {
-			return errors.New("Version mismatch")
-		}
-	} else {
-		return errors.New("No version found")
+	if !rows.Next() {
+		return 0, errors.New("No version found")
 	}
-	return nil
+	var version int
+	err = rows.Scan(&version)
+	if err != nil {
+		return 0, errors.Wrap(err, "rows.Scan")
+	}
+	return version, nil
 }
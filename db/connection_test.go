@@ -0,0 +1,157 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"projectreshoot/tests"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testOptions() Options {
+	return Options{
+		JournalMode:   "WAL",
+		BusyTimeout:   5 * time.Second,
+		Synchronous:   "NORMAL",
+		ForeignKeys:   true,
+		MaxReadConns:  4,
+		MaxWriteConns: 1,
+	}
+}
+
+func TestConnectToDatabase(t *testing.T) {
+	cfg, err := tests.TestConfig()
+	require.NoError(t, err)
+	logger := tests.NilLogger()
+	ver, err := strconv.ParseInt(cfg.DBName, 10, 0)
+	require.NoError(t, err)
+	dir := t.TempDir()
+	require.NoError(t, tests.SetupTestDBFile(filepath.Join(dir, cfg.DBName), ver))
+	t.Chdir(dir)
+
+	conn, err := ConnectToDatabase(cfg.DBName, testOptions(), logger)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	t.Run("Pragmas are applied to the write pool", func(t *testing.T) {
+		tx, err := conn.Begin(t.Context())
+		require.NoError(t, err)
+		defer tx.Rollback()
+		var journalMode string
+		rows, err := tx.Query(t.Context(), "PRAGMA journal_mode")
+		require.NoError(t, err)
+		require.True(t, rows.Next())
+		require.NoError(t, rows.Scan(&journalMode))
+		rows.Close()
+		assert.Equal(t, "wal", journalMode)
+		var foreignKeys, busyTimeout int
+		rows, err = tx.Query(t.Context(), "PRAGMA foreign_keys")
+		require.NoError(t, err)
+		require.True(t, rows.Next())
+		require.NoError(t, rows.Scan(&foreignKeys))
+		rows.Close()
+		assert.Equal(t, 1, foreignKeys)
+		rows, err = tx.Query(t.Context(), "PRAGMA busy_timeout")
+		require.NoError(t, err)
+		require.True(t, rows.Next())
+		require.NoError(t, rows.Scan(&busyTimeout))
+		rows.Close()
+		assert.Equal(t, 5000, busyTimeout)
+	})
+	t.Run("Read pool is read only", func(t *testing.T) {
+		tx, err := conn.BeginRead(t.Context())
+		require.NoError(t, err)
+		defer tx.Rollback()
+		user, err := tx.Users().GetUserFromID(t.Context(), 1)
+		require.NoError(t, err)
+		assert.Equal(t, "testuser", user.Username)
+		err = tx.Users().UpdateBio(t.Context(), 1, "new bio")
+		require.Error(t, err)
+	})
+	t.Run("Backend statements are cached", func(t *testing.T) {
+		for _, query := range SQLite.Statements() {
+			assert.NotNil(t, conn.stmts.get(query))
+			assert.NotNil(t, conn.readStmts.get(query))
+		}
+	})
+	t.Run("Write transactions are committed", func(t *testing.T) {
+		tx, err := conn.Begin(t.Context())
+		require.NoError(t, err)
+		require.NoError(t, tx.Users().UpdateBio(t.Context(), 1, "updated"))
+		require.NoError(t, tx.Commit())
+		rtx, err := conn.BeginRead(t.Context())
+		require.NoError(t, err)
+		defer rtx.Rollback()
+		user, err := rtx.Users().GetUserFromID(t.Context(), 1)
+		require.NoError(t, err)
+		assert.Equal(t, "updated", user.Bio)
+	})
+	t.Run("Backup writes a copy of the database", func(t *testing.T) {
+		path := filepath.Join(dir, "backup.db")
+		require.NoError(t, conn.Backup(t.Context(), path))
+		backup, err := sql.Open("sqlite", "file:"+path)
+		require.NoError(t, err)
+		defer backup.Close()
+		var username string
+		err = backup.QueryRow("SELECT username FROM users WHERE id = 1").Scan(&username)
+		require.NoError(t, err)
+		assert.Equal(t, "testuser", username)
+	})
+}
+
+// Runs the queries made by the Authentication middleware on a valid access
+// token in parallel. Compares a single pool with no pragmas set (how the
+// database was opened before Options) against the tuned pools
+func BenchmarkAuthQueries(b *testing.B) {
+	cfg, err := tests.TestConfig()
+	require.NoError(b, err)
+	logger := tests.NilLogger()
+	ver, err := strconv.ParseInt(cfg.DBName, 10, 0)
+	require.NoError(b, err)
+	dir := b.TempDir()
+	require.NoError(b, tests.SetupTestDBFile(filepath.Join(dir, cfg.DBName), ver))
+	b.Chdir(dir)
+	jti := uuid.New()
+
+	run := func(b *testing.B, conn *SafeConn) {
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				tx, err := conn.BeginRead(b.Context())
+				if err != nil {
+					b.Error(err)
+					return
+				}
+				_, err = tx.Tokens().CheckTokenNotRevoked(b.Context(), jti)
+				if err != nil {
+					b.Error(err)
+				}
+				_, err = tx.Users().GetUserFromID(b.Context(), 1)
+				if err != nil {
+					b.Error(err)
+				}
+				tx.Commit()
+			}
+		})
+	}
+
+	b.Run("Single pool without pragmas", func(b *testing.B) {
+		db, err := sql.Open("sqlite", fmt.Sprintf("file:%s.db", cfg.DBName))
+		require.NoError(b, err)
+		conn := MakeSafe(db, logger)
+		defer conn.Close()
+		run(b, conn)
+	})
+	b.Run("Tuned pools with statement cache", func(b *testing.B) {
+		conn, err := ConnectToDatabase(cfg.DBName, testOptions(), logger)
+		require.NoError(b, err)
+		defer conn.Close()
+		run(b, conn)
+	})
+}
@@ -0,0 +1,580 @@
+package db
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+const (
+	postgresCreateUser = `INSERT INTO users (username, password_hash) VALUES ($1, $2)
+        RETURNING ` + userColumns
+	postgresGetUserByID = `SELECT ` + userColumns + `
+        FROM users WHERE id = $1 LIMIT 1`
+	postgresGetUserByUsername = `SELECT ` + userColumns + `
+        FROM users WHERE LOWER(username) = LOWER($1) LIMIT 1`
+	postgresListUsers           = `SELECT ` + userColumns + ` FROM users ORDER BY id`
+	postgresUsernameTaken       = `SELECT 1 FROM users WHERE LOWER(username) = LOWER($1) LIMIT 1`
+	postgresUpdatePassword      = `UPDATE users SET password_hash = $1 WHERE id = $2`
+	postgresUpdateUsername      = `UPDATE users SET username = $1 WHERE id = $2`
+	postgresUpdateBio           = `UPDATE users SET bio = $1 WHERE id = $2`
+	postgresUpdateLockedAt      = `UPDATE users SET locked_at = $1 WHERE id = $2`
+	postgresUpdateTokensAfter   = `UPDATE users SET tokens_valid_after = $1 WHERE id = $2`
+	postgresDeleteUser          = `DELETE FROM users WHERE id = $1`
+	postgresRevokeToken         = `INSERT INTO jwtblacklist (jti, exp) VALUES ($1, $2)`
+	postgresTokenRevoked        = `SELECT 1 FROM jwtblacklist WHERE jti = $1 LIMIT 1`
+	postgresDeleteExpiredTokens = `DELETE FROM jwtblacklist WHERE exp < $1`
+	postgresRecordAudit         = `INSERT INTO audit_log (user_id, event, ip, detail)
+        VALUES ($1, $2, $3, $4)`
+	postgresDeleteAuditBefore = `DELETE FROM audit_log WHERE created_at < $1`
+	postgresAcquireLease      = `INSERT INTO job_leases (name, holder, run_at, expires_at)
+        VALUES ($1, $2, $3, $4)
+        ON CONFLICT (name) DO UPDATE SET holder = excluded.holder,
+        run_at = excluded.run_at, expires_at = excluded.expires_at
+        WHERE job_leases.run_at < excluded.run_at AND job_leases.expires_at < $5`
+	postgresReleaseLease = `UPDATE job_leases SET expires_at = 0, finished_at = $1,
+        last_status = $2, last_error = $3 WHERE name = $4 AND holder = $5`
+	postgresEnqueue = `INSERT INTO queue_jobs (kind, payload, run_after, max_attempts)
+        VALUES ($1, $2, $3, $4)
+        RETURNING id`
+	postgresClaimJob = `UPDATE queue_jobs SET status = 'running', attempts = attempts + 1,
+        locked_by = $1, locked_until = $2
+        WHERE id = (SELECT id FROM queue_jobs
+        WHERE (status = 'pending' AND run_after <= $3)
+        OR (status = 'running' AND locked_until < $3)
+        ORDER BY run_after, id LIMIT 1
+        FOR UPDATE SKIP LOCKED)
+        RETURNING ` + queueColumns
+	postgresComplete = `DELETE FROM queue_jobs
+        WHERE id = $1 AND locked_by = $2 AND status = 'running'`
+	postgresFailJob = `UPDATE queue_jobs SET status = $1, run_after = $2, last_error = $3,
+        locked_by = '', locked_until = 0
+        WHERE id = $4 AND locked_by = $5 AND status = 'running'`
+	postgresListJobs = `SELECT ` + queueColumns + ` FROM queue_jobs
+        WHERE status = $1 ORDER BY id LIMIT $2`
+	postgresRetryJob = `UPDATE queue_jobs SET status = 'pending', attempts = 0, run_after = $1
+        WHERE id = $2 AND status = 'dead'`
+	postgresRetryDead = `UPDATE queue_jobs SET status = 'pending', attempts = 0, run_after = $1
+        WHERE status = 'dead'`
+	postgresGetRateLimit = `SELECT value, previous, updated, expires_at
+        FROM rate_limits WHERE key = $1 FOR UPDATE`
+	postgresPutRateLimit = `INSERT INTO rate_limits (key, value, previous, updated, expires_at)
+        VALUES ($1, $2, $3, $4, $5)
+        ON CONFLICT (key) DO UPDATE SET value = excluded.value,
+        previous = excluded.previous, updated = excluded.updated,
+        expires_at = excluded.expires_at`
+	postgresDeleteExpiredRateLimits = `DELETE FROM rate_limits WHERE expires_at < $1`
+)
+
+// Backend for PostgreSQL databases
+type postgresBackend struct{}
+
+// PostgreSQL implementation of the stores
+var Postgres Backend = postgresBackend{}
+
+func (postgresBackend) Name() string {
+	return "postgres"
+}
+
+func (postgresBackend) Users(tx *SafeTX) UserStore {
+	return postgresUserStore{tx: tx}
+}
+
+func (postgresBackend) Tokens(tx *SafeTX) TokenStore {
+	return postgresTokenStore{tx: tx}
+}
+
+func (postgresBackend) Audit(tx *SafeTX) AuditStore {
+	return postgresAuditStore{tx: tx}
+}
+
+func (postgresBackend) Jobs(tx *SafeTX) JobStore {
+	return postgresJobStore{tx: tx}
+}
+
+func (postgresBackend) Queue(tx *SafeTX) QueueStore {
+	return postgresQueueStore{tx: tx}
+}
+
+func (postgresBackend) RateLimits(tx *SafeTX) RateLimitStore {
+	return postgresRateLimitStore{tx: tx}
+}
+
+func (postgresBackend) Statements() []string {
+	return []string{
+		postgresCreateUser,
+		postgresGetUserByID,
+		postgresGetUserByUsername,
+		postgresUsernameTaken,
+		postgresUpdatePassword,
+		postgresUpdateUsername,
+		postgresUpdateBio,
+		postgresListUsers,
+		postgresUpdateLockedAt,
+		postgresUpdateTokensAfter,
+		postgresDeleteUser,
+		postgresRevokeToken,
+		postgresTokenRevoked,
+		postgresDeleteExpiredTokens,
+		postgresRecordAudit,
+		postgresDeleteAuditBefore,
+		postgresAcquireLease,
+		postgresReleaseLease,
+		postgresEnqueue,
+		postgresClaimJob,
+		postgresComplete,
+		postgresFailJob,
+		postgresListJobs,
+		postgresRetryJob,
+		postgresRetryDead,
+		postgresGetRateLimit,
+		postgresPutRateLimit,
+		postgresDeleteExpiredRateLimits,
+	}
+}
+
+type postgresUserStore struct {
+	tx *SafeTX
+}
+
+// Creates a new user in the database and returns a pointer
+func (s postgresUserStore) CreateUser(
+	ctx context.Context,
+	username string,
+	passwordHash string,
+) (*User, error) {
+	rows, err := s.tx.Query(ctx, postgresCreateUser, username, passwordHash)
+	if err != nil {
+		return nil, errors.Wrap(err, "tx.Query")
+	}
+	defer rows.Close()
+	var user User
+	err = scanUserRow(&user, rows)
+	if err != nil {
+		return nil, errors.Wrap(err, "scanUserRow")
+	}
+	return &user, nil
+}
+
+// Runs the given user query and scans the first row into a User
+func (s postgresUserStore) getUser(
+	ctx context.Context,
+	query string,
+	value interface{},
+) (*User, error) {
+	rows, err := s.tx.Query(ctx, query, value)
+	if err != nil {
+		return nil, errors.Wrap(err, "tx.Query")
+	}
+	defer rows.Close()
+	var user User
+	err = scanUserRow(&user, rows)
+	if err != nil {
+		return nil, errors.Wrap(err, "scanUserRow")
+	}
+	return &user, nil
+}
+
+// Queries the database for a user matching the given username.
+// Query is case insensitive
+func (s postgresUserStore) GetUserFromUsername(
+	ctx context.Context,
+	username string,
+) (*User, error) {
+	user, err := s.getUser(ctx, postgresGetUserByUsername, username)
+	if err != nil {
+		return nil, errors.Wrap(err, "getUser")
+	}
+	return user, nil
+}
+
+// Queries the database for a user matching the given ID.
+func (s postgresUserStore) GetUserFromID(ctx context.Context, id int) (*User, error) {
+	user, err := s.getUser(ctx, postgresGetUserByID, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "getUser")
+	}
+	return user, nil
+}
+
+// Checks if the given username is unique. Returns true if not taken
+func (s postgresUserStore) CheckUsernameUnique(
+	ctx context.Context,
+	username string,
+) (bool, error) {
+	rows, err := s.tx.Query(ctx, postgresUsernameTaken, username)
+	if err != nil {
+		return false, errors.Wrap(err, "tx.Query")
+	}
+	defer rows.Close()
+	taken := rows.Next()
+	return !taken, nil
+}
+
+// Set the password hash of the user
+func (s postgresUserStore) UpdatePasswordHash(
+	ctx context.Context,
+	id int,
+	passwordHash string,
+) error {
+	_, err := s.tx.Exec(ctx, postgresUpdatePassword, passwordHash, id)
+	if err != nil {
+		return errors.Wrap(err, "tx.Exec")
+	}
+	return nil
+}
+
+// Set the username of the user
+func (s postgresUserStore) UpdateUsername(
+	ctx context.Context,
+	id int,
+	username string,
+) error {
+	_, err := s.tx.Exec(ctx, postgresUpdateUsername, username, id)
+	if err != nil {
+		return errors.Wrap(err, "tx.Exec")
+	}
+	return nil
+}
+
+// Set the bio of the user
+func (s postgresUserStore) UpdateBio(ctx context.Context, id int, bio string) error {
+	_, err := s.tx.Exec(ctx, postgresUpdateBio, bio, id)
+	if err != nil {
+		return errors.Wrap(err, "tx.Exec")
+	}
+	return nil
+}
+
+// Get every user, ordered by ID
+func (s postgresUserStore) ListUsers(ctx context.Context) ([]*User, error) {
+	rows, err := s.tx.Query(ctx, postgresListUsers)
+	if err != nil {
+		return nil, errors.Wrap(err, "tx.Query")
+	}
+	defer rows.Close()
+	users, err := scanUsers(rows)
+	if err != nil {
+		return nil, errors.Wrap(err, "scanUsers")
+	}
+	return users, nil
+}
+
+// Set the time the user was locked, or 0 to unlock them
+func (s postgresUserStore) UpdateLockedAt(ctx context.Context, id int, lockedAt int64) error {
+	_, err := s.tx.Exec(ctx, postgresUpdateLockedAt, lockedAt, id)
+	if err != nil {
+		return errors.Wrap(err, "tx.Exec")
+	}
+	return nil
+}
+
+// Set the time tokens issued to the user must be issued after
+func (s postgresUserStore) UpdateTokensValidAfter(
+	ctx context.Context,
+	id int,
+	validAfter int64,
+) error {
+	_, err := s.tx.Exec(ctx, postgresUpdateTokensAfter, validAfter, id)
+	if err != nil {
+		return errors.Wrap(err, "tx.Exec")
+	}
+	return nil
+}
+
+// Delete the user
+func (s postgresUserStore) DeleteUser(ctx context.Context, id int) error {
+	_, err := s.tx.Exec(ctx, postgresDeleteUser, id)
+	if err != nil {
+		return errors.Wrap(err, "tx.Exec")
+	}
+	return nil
+}
+
+type postgresTokenStore struct {
+	tx *SafeTX
+}
+
+// Revoke a token by adding it to the database
+func (s postgresTokenStore) RevokeToken(
+	ctx context.Context,
+	jti uuid.UUID,
+	exp int64,
+) error {
+	_, err := s.tx.Exec(ctx, postgresRevokeToken, jti, exp)
+	if err != nil {
+		return errors.Wrap(err, "tx.Exec")
+	}
+	return nil
+}
+
+// Check if a token has been revoked. Returns true if not revoked.
+func (s postgresTokenStore) CheckTokenNotRevoked(
+	ctx context.Context,
+	jti uuid.UUID,
+) (bool, error) {
+	rows, err := s.tx.Query(ctx, postgresTokenRevoked, jti)
+	if err != nil {
+		return false, errors.Wrap(err, "tx.Query")
+	}
+	defer rows.Close()
+	revoked := rows.Next()
+	return !revoked, nil
+}
+
+// Remove revoked tokens that expired before now
+func (s postgresTokenStore) DeleteExpiredTokens(ctx context.Context, now int64) (int64, error) {
+	res, err := s.tx.Exec(ctx, postgresDeleteExpiredTokens, now)
+	if err != nil {
+		return 0, errors.Wrap(err, "tx.Exec")
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "res.RowsAffected")
+	}
+	return count, nil
+}
+
+type postgresAuditStore struct {
+	tx *SafeTX
+}
+
+// Add an event to the audit log
+func (s postgresAuditStore) Record(
+	ctx context.Context,
+	userID int,
+	event string,
+	ip string,
+	detail string,
+) error {
+	_, err := s.tx.Exec(ctx, postgresRecordAudit, userID, event, ip, detail)
+	if err != nil {
+		return errors.Wrap(err, "tx.Exec")
+	}
+	return nil
+}
+
+// Remove events created before the given time
+func (s postgresAuditStore) DeleteBefore(ctx context.Context, before int64) (int64, error) {
+	res, err := s.tx.Exec(ctx, postgresDeleteAuditBefore, before)
+	if err != nil {
+		return 0, errors.Wrap(err, "tx.Exec")
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "res.RowsAffected")
+	}
+	return count, nil
+}
+
+type postgresJobStore struct {
+	tx *SafeTX
+}
+
+// Take the lease on the job for the run scheduled at runAt. The lease is only
+// taken if the previous lease has expired and was for an earlier run
+func (s postgresJobStore) AcquireLease(
+	ctx context.Context,
+	name string,
+	holder string,
+	runAt int64,
+	expiresAt int64,
+	now int64,
+) (bool, error) {
+	res, err := s.tx.Exec(ctx, postgresAcquireLease, name, holder, runAt, expiresAt, now)
+	if err != nil {
+		return false, errors.Wrap(err, "tx.Exec")
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.Wrap(err, "res.RowsAffected")
+	}
+	return count == 1, nil
+}
+
+// Release the lease and record the result of the run
+func (s postgresJobStore) ReleaseLease(
+	ctx context.Context,
+	name string,
+	holder string,
+	finishedAt int64,
+	status string,
+	errMsg string,
+) error {
+	_, err := s.tx.Exec(ctx, postgresReleaseLease, finishedAt, status, errMsg, name, holder)
+	if err != nil {
+		return errors.Wrap(err, "tx.Exec")
+	}
+	return nil
+}
+
+type postgresQueueStore struct {
+	tx *SafeTX
+}
+
+// Add a job to the queue to run after runAfter
+func (s postgresQueueStore) Enqueue(
+	ctx context.Context,
+	kind string,
+	payload []byte,
+	runAfter int64,
+	maxAttempts int,
+) (int64, error) {
+	rows, err := s.tx.Query(ctx, postgresEnqueue, kind, payload, runAfter, maxAttempts)
+	if err != nil {
+		return 0, errors.Wrap(err, "tx.Query")
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return 0, errors.New("No ID returned")
+	}
+	var id int64
+	err = rows.Scan(&id)
+	if err != nil {
+		return 0, errors.Wrap(err, "rows.Scan")
+	}
+	return id, nil
+}
+
+// Claim the next job that is ready to run, or whose claim has expired
+func (s postgresQueueStore) Claim(
+	ctx context.Context,
+	worker string,
+	now int64,
+	lockedUntil int64,
+) (*QueuedJob, error) {
+	rows, err := s.tx.Query(ctx, postgresClaimJob, worker, lockedUntil, now)
+	if err != nil {
+		return nil, errors.Wrap(err, "tx.Query")
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, nil
+	}
+	var job QueuedJob
+	err = scanQueuedJob(&job, rows)
+	if err != nil {
+		return nil, errors.Wrap(err, "scanQueuedJob")
+	}
+	return &job, nil
+}
+
+// Remove a job claimed by the worker after it succeeded
+func (s postgresQueueStore) Complete(ctx context.Context, id int64, worker string) error {
+	_, err := s.tx.Exec(ctx, postgresComplete, id, worker)
+	if err != nil {
+		return errors.Wrap(err, "tx.Exec")
+	}
+	return nil
+}
+
+// Release a job claimed by the worker after it failed
+func (s postgresQueueStore) Fail(
+	ctx context.Context,
+	id int64,
+	worker string,
+	errMsg string,
+	retryAt int64,
+	dead bool,
+) error {
+	status := QueuePending
+	if dead {
+		status = QueueDead
+	}
+	_, err := s.tx.Exec(ctx, postgresFailJob, status, retryAt, errMsg, id, worker)
+	if err != nil {
+		return errors.Wrap(err, "tx.Exec")
+	}
+	return nil
+}
+
+// Get up to limit jobs with the given status, oldest first
+func (s postgresQueueStore) List(
+	ctx context.Context,
+	status string,
+	limit int,
+) ([]QueuedJob, error) {
+	rows, err := s.tx.Query(ctx, postgresListJobs, status, limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "tx.Query")
+	}
+	defer rows.Close()
+	jobs, err := scanQueuedJobs(rows)
+	if err != nil {
+		return nil, errors.Wrap(err, "scanQueuedJobs")
+	}
+	return jobs, nil
+}
+
+// Reset a dead job so it runs again after now
+func (s postgresQueueStore) Retry(ctx context.Context, id int64, now int64) (bool, error) {
+	res, err := s.tx.Exec(ctx, postgresRetryJob, now, id)
+	if err != nil {
+		return false, errors.Wrap(err, "tx.Exec")
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.Wrap(err, "res.RowsAffected")
+	}
+	return count == 1, nil
+}
+
+// Reset all dead jobs so they run again after now
+func (s postgresQueueStore) RetryDead(ctx context.Context, now int64) (int64, error) {
+	res, err := s.tx.Exec(ctx, postgresRetryDead, now)
+	if err != nil {
+		return 0, errors.Wrap(err, "tx.Exec")
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "res.RowsAffected")
+	}
+	return count, nil
+}
+
+type postgresRateLimitStore struct {
+	tx *SafeTX
+}
+
+// Get the state of the key and lock the row until the transaction ends.
+// Returns nil if the key has no state
+func (s postgresRateLimitStore) Get(ctx context.Context, key string) (*RateLimitState, error) {
+	rows, err := s.tx.Query(ctx, postgresGetRateLimit, key)
+	if err != nil {
+		return nil, errors.Wrap(err, "tx.Query")
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, nil
+	}
+	var state RateLimitState
+	err = rows.Scan(&state.Value, &state.Previous, &state.Updated, &state.ExpiresAt)
+	if err != nil {
+		return nil, errors.Wrap(err, "rows.Scan")
+	}
+	return &state, nil
+}
+
+// Insert or replace the state of the key
+func (s postgresRateLimitStore) Put(ctx context.Context, key string, state RateLimitState) error {
+	_, err := s.tx.Exec(ctx, postgresPutRateLimit,
+		key, state.Value, state.Previous, state.Updated, state.ExpiresAt)
+	if err != nil {
+		return errors.Wrap(err, "tx.Exec")
+	}
+	return nil
+}
+
+// Remove state that expired before now
+func (s postgresRateLimitStore) DeleteExpired(ctx context.Context, now int64) (int64, error) {
+	res, err := s.tx.Exec(ctx, postgresDeleteExpiredRateLimits, now)
+	if err != nil {
+		return 0, errors.Wrap(err, "tx.Exec")
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "res.RowsAffected")
+	}
+	return count, nil
+}
@@ -0,0 +1,70 @@
+package db
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+// Status of a job in the queue
+const (
+	QueuePending = "pending" // Waiting to run, or waiting to be retried
+	QueueRunning = "running" // Claimed by a worker
+	QueueDead    = "dead"    // Failed too many times, won't be retried
+)
+
+type QueuedJob struct {
+	ID          int64  // Integer ID (index primary key)
+	Kind        string // Type of job, used to find the handler
+	Payload     []byte // Data for the handler, usually JSON
+	Status      string // QueuePending, QueueRunning or QueueDead
+	Attempts    int    // Number of times the job has been claimed
+	MaxAttempts int    // Attempts before the job is dead. 0 uses the worker default
+	RunAfter    int64  // Epoch timestamp the job can run after
+	LockedBy    string // Worker that claimed the job
+	LockedUntil int64  // Epoch timestamp the claim expires
+	LastError   string // Error from the last failed attempt
+	Created_at  int64  // Epoch timestamp when the job was enqueued
+}
+
+// Columns selected by the queue queries, in the order scanQueuedJob expects
+const queueColumns = `id, kind, payload, status, attempts, max_attempts,
+        run_after, locked_by, locked_until, last_error, created_at`
+
+// Scan the current row into a QueuedJob
+func scanQueuedJob(job *QueuedJob, rows *sql.Rows) error {
+	err := rows.Scan(
+		&job.ID,
+		&job.Kind,
+		&job.Payload,
+		&job.Status,
+		&job.Attempts,
+		&job.MaxAttempts,
+		&job.RunAfter,
+		&job.LockedBy,
+		&job.LockedUntil,
+		&job.LastError,
+		&job.Created_at,
+	)
+	if err != nil {
+		return errors.Wrap(err, "rows.Scan")
+	}
+	return nil
+}
+
+// Scan all the rows into QueuedJobs
+func scanQueuedJobs(rows *sql.Rows) ([]QueuedJob, error) {
+	var jobs []QueuedJob
+	for rows.Next() {
+		var job QueuedJob
+		err := scanQueuedJob(&job, rows)
+		if err != nil {
+			return nil, errors.Wrap(err, "scanQueuedJob")
+		}
+		jobs = append(jobs, job)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "rows.Err")
+	}
+	return jobs, nil
+}
@@ -0,0 +1,9 @@
+package db
+
+// Saved state of a rate limit key
+type RateLimitState struct {
+	Value     float64 // Tokens left, or requests in the current window
+	Previous  float64 // Requests in the previous window. Unused by token buckets
+	Updated   int64   // Unix nanoseconds of the last refill or start of the window
+	ExpiresAt int64   // Epoch timestamp the state can be removed after
+}
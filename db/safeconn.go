@@ -3,23 +3,90 @@ package db
 import (
 	"context"
 	"database/sql"
+	"sync/atomic"
 	"time"
 
+	"projectreshoot/metrics"
+	"projectreshoot/tracing"
+
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type SafeConn struct {
 	db                  *sql.DB
+	readDB              *sql.DB
+	stmts               *stmtCache
+	readStmts           *stmtCache
 	readLockCount       uint32
 	globalLockStatus    uint32
 	globalLockRequested uint32
+	lockWaitCount       int32
+	backend             Backend
 	logger              *zerolog.Logger
 }
 
-// Make the provided db handle safe and attach a logger to it
+// Make the provided SQLite db handle safe and attach a logger to it
 func MakeSafe(db *sql.DB, logger *zerolog.Logger) *SafeConn {
-	return &SafeConn{db: db, logger: logger}
+	return MakeSafeWithBackend(db, SQLite, logger)
+}
+
+// Make the provided db handle safe, using the given backend for the stores
+// and attach a logger to it
+func MakeSafeWithBackend(db *sql.DB, backend Backend, logger *zerolog.Logger) *SafeConn {
+	stmts := newStmtCache(db)
+	return &SafeConn{
+		db:        db,
+		readDB:    db,
+		stmts:     stmts,
+		readStmts: stmts,
+		backend:   backend,
+		logger:    logger,
+	}
+}
+
+// Make the provided db handles safe, using db for read/write transactions
+// and readDB for read only transactions
+func MakeSafeWithPools(
+	db *sql.DB,
+	readDB *sql.DB,
+	backend Backend,
+	logger *zerolog.Logger,
+) *SafeConn {
+	return &SafeConn{
+		db:        db,
+		readDB:    readDB,
+		stmts:     newStmtCache(db),
+		readStmts: newStmtCache(readDB),
+		backend:   backend,
+		logger:    logger,
+	}
+}
+
+// Prepare the given queries on both pools and add them to the statement
+// cache. Queries run through a SafeTX that aren't prepared are still run,
+// but are parsed by the database on every call
+func (conn *SafeConn) Prepare(ctx context.Context, queries ...string) error {
+	for _, query := range queries {
+		err := conn.stmts.prepare(ctx, query)
+		if err != nil {
+			return errors.Wrap(err, "conn.stmts.prepare")
+		}
+		if conn.readStmts == conn.stmts {
+			continue
+		}
+		err = conn.readStmts.prepare(ctx, query)
+		if err != nil {
+			return errors.Wrap(err, "conn.readStmts.prepare")
+		}
+	}
+	return nil
+}
+
+// Get the backend used by the connection
+func (conn *SafeConn) Backend() Backend {
+	return conn.backend
 }
 
 // Attempts to acquire a global lock on the database connection
@@ -62,9 +129,53 @@ func (conn *SafeConn) releaseReadLock() {
 // Starts a new transaction based on the current context. Will cancel if
 // the context is closed/cancelled/done
 func (conn *SafeConn) Begin(ctx context.Context) (*SafeTX, error) {
+	return conn.begin(ctx, conn.db, conn.stmts, nil)
+}
+
+// Starts a new read only transaction using the read pool. Will cancel if
+// the context is closed/cancelled/done
+func (conn *SafeConn) BeginRead(ctx context.Context) (*SafeTX, error) {
+	return conn.begin(ctx, conn.readDB, conn.readStmts, &sql.TxOptions{ReadOnly: true})
+}
+
+// Acquire a read lock and start a transaction on the given pool
+func (conn *SafeConn) begin(
+	ctx context.Context,
+	db *sql.DB,
+	stmts *stmtCache,
+	opts *sql.TxOptions,
+) (*SafeTX, error) {
+	mode := "write"
+	if opts != nil && opts.ReadOnly {
+		mode = "read"
+	}
+	ctx, span := tracing.Start(ctx, "db.Begin", attribute.String("db.tx_mode", mode))
+	defer span.End()
+	waitStart := time.Now()
+	err := conn.waitReadLock(ctx)
+	wait := time.Since(waitStart)
+	span.SetAttributes(attribute.Int64("db.lock_wait_us", wait.Microseconds()))
+	if err != nil {
+		tracing.Fail(span, err)
+		return nil, err
+	}
+	metrics.DBLockWait.Observe(wait.Seconds())
+	tx, err := db.BeginTx(ctx, opts)
+	if err != nil {
+		tracing.Fail(span, err)
+		conn.releaseReadLock()
+		return nil, err
+	}
+	return &SafeTX{tx: tx, sc: conn, stmts: stmts, start: time.Now(), mode: mode}, nil
+}
+
+// Wait until a read lock is acquired or the context is done
+func (conn *SafeConn) waitReadLock(ctx context.Context) error {
 	lockAcquired := make(chan struct{})
 	lockCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
+	atomic.AddInt32(&conn.lockWaitCount, 1)
+	defer atomic.AddInt32(&conn.lockWaitCount, -1)
 
 	go func() {
 		select {
@@ -79,16 +190,30 @@ func (conn *SafeConn) Begin(ctx context.Context) (*SafeTX, error) {
 
 	select {
 	case <-lockAcquired:
-		tx, err := conn.db.BeginTx(ctx, nil)
-		if err != nil {
-			conn.releaseReadLock()
-			return nil, err
-		}
-		return &SafeTX{tx: tx, sc: conn}, nil
+		return nil
 	case <-ctx.Done():
 		cancel()
-		return nil, errors.New("Transaction time out due to database lock")
+		return errors.New("Transaction time out due to database lock")
+	}
+}
+
+// Write a consistent copy of the SQLite database to the given path. Holds a
+// read lock while the copy is made so it won't run while paused
+func (conn *SafeConn) Backup(ctx context.Context, path string) error {
+	if conn.backend.Name() != "sqlite" {
+		return errors.New("Backups are only supported for SQLite databases")
+	}
+	err := conn.waitReadLock(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.releaseReadLock()
+	// The read pool is query_only, which stops VACUUM INTO writing the copy
+	_, err = conn.db.ExecContext(ctx, "VACUUM INTO ?", path)
+	if err != nil {
+		return errors.Wrap(err, "db.ExecContext")
 	}
+	return nil
 }
 
 // Acquire a global lock, preventing all transactions
@@ -100,6 +225,7 @@ func (conn *SafeConn) Pause(timeoutAfter time.Duration) {
 	attempt := 0
 	for {
 		if conn.acquireGlobalLock() {
+			conn.checkpoint()
 			conn.logger.Info().Msg("Global database lock acquired")
 			return
 		}
@@ -113,17 +239,78 @@ func (conn *SafeConn) Pause(timeoutAfter time.Duration) {
 	}
 }
 
+// Flush the SQLite write-ahead log into the database file so the file can be
+// safely copied while the connection is paused
+func (conn *SafeConn) checkpoint() {
+	if conn.backend.Name() != "sqlite" {
+		return
+	}
+	_, err := conn.db.Exec("PRAGMA wal_checkpoint(TRUNCATE)")
+	if err != nil {
+		conn.logger.Warn().Err(err).Msg("Failed to checkpoint the write-ahead log")
+	}
+}
+
 // Release the global lock
 func (conn *SafeConn) Resume() {
 	conn.releaseGlobalLock()
 	conn.logger.Info().Msg("Global database lock released")
 }
 
+// Snapshot of the state of the connection locks
+type LockStats struct {
+	ReadLocks      uint32 // Number of open transactions
+	Waiting        int32  // Number of transactions waiting for a read lock
+	Paused         bool   // True if the global lock is held
+	PauseRequested bool   // True if the global lock is being acquired
+}
+
+// Get a snapshot of the state of the connection locks
+func (conn *SafeConn) LockStats() LockStats {
+	return LockStats{
+		ReadLocks:      atomic.LoadUint32(&conn.readLockCount),
+		Waiting:        atomic.LoadInt32(&conn.lockWaitCount),
+		Paused:         atomic.LoadUint32(&conn.globalLockStatus) == 1,
+		PauseRequested: atomic.LoadUint32(&conn.globalLockRequested) == 1,
+	}
+}
+
+// Ping the database pools, returning how long the pings took. Does not
+// require a read lock so can be used while paused
+func (conn *SafeConn) Ping(ctx context.Context) (time.Duration, error) {
+	start := time.Now()
+	err := conn.db.PingContext(ctx)
+	if err != nil {
+		return time.Since(start), errors.Wrap(err, "db.PingContext")
+	}
+	if conn.readDB != conn.db {
+		err = conn.readDB.PingContext(ctx)
+		if err != nil {
+			return time.Since(start), errors.Wrap(err, "readDB.PingContext")
+		}
+	}
+	return time.Since(start), nil
+}
+
+// Get the latest applied migration version of the database
+func (conn *SafeConn) Version(ctx context.Context) (int, error) {
+	version, err := getDBVersion(ctx, conn.readDB)
+	if err != nil {
+		return 0, errors.Wrap(err, "getDBVersion")
+	}
+	return version, nil
+}
+
 // Close the database connection
 func (conn *SafeConn) Close() error {
 	conn.logger.Debug().Msg("Acquiring global lock for connection close")
 	conn.acquireGlobalLock()
 	defer conn.releaseGlobalLock()
 	conn.logger.Debug().Msg("Closing database connection")
+	conn.stmts.close()
+	if conn.readDB != conn.db {
+		conn.readStmts.close()
+		conn.readDB.Close()
+	}
 	return conn.db.Close()
 }
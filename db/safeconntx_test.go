@@ -117,6 +117,36 @@ func TestSafeTX(t *testing.T) {
 		tx2.Commit()
 		tx3.Commit()
 	})
+	t.Run("Failed savepoints are rolled back alone", func(t *testing.T) {
+		tx, err := sconn.Begin(t.Context())
+		require.NoError(t, err)
+		_, err = tx.Exec(t.Context(), "CREATE TEMP TABLE savepoints (n INTEGER)")
+		require.NoError(t, err)
+		insert := func(n int) error {
+			_, err := tx.Exec(t.Context(), "INSERT INTO savepoints (n) VALUES (?)", n)
+			return err
+		}
+		require.NoError(t, insert(1))
+		err = tx.Savepoint(t.Context(), "kept", func() error { return insert(2) })
+		require.NoError(t, err)
+		err = tx.Savepoint(t.Context(), "failed", func() error {
+			require.NoError(t, insert(3))
+			_, err := tx.Exec(t.Context(), "INSERT INTO missing (n) VALUES (4)")
+			return err
+		})
+		require.Error(t, err)
+		rows, err := tx.Query(t.Context(), "SELECT n FROM savepoints ORDER BY n")
+		require.NoError(t, err)
+		got := []int{}
+		for rows.Next() {
+			var n int
+			require.NoError(t, rows.Scan(&n))
+			got = append(got, n)
+		}
+		rows.Close()
+		assert.Equal(t, []int{1, 2}, got)
+		require.NoError(t, tx.Rollback())
+	})
 	t.Run("Lock acquiring times out after timeout", func(t *testing.T) {
 		ctx, cancel := context.WithTimeout(t.Context(), 250*time.Millisecond)
 		defer cancel()
@@ -3,14 +3,52 @@ package db
 import (
 	"context"
 	"database/sql"
+	"time"
+
+	"projectreshoot/metrics"
+	"projectreshoot/tracing"
 
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // Extends sql.Tx for use with SafeConn
 type SafeTX struct {
-	tx *sql.Tx
-	sc *SafeConn
+	tx    *sql.Tx
+	sc    *SafeConn
+	stmts *stmtCache
+	start time.Time // Time the transaction was started
+	mode  string    // "read" or "write"
+}
+
+// Get the UserStore for the transaction
+func (stx *SafeTX) Users() UserStore {
+	return stx.sc.backend.Users(stx)
+}
+
+// Get the TokenStore for the transaction
+func (stx *SafeTX) Tokens() TokenStore {
+	return stx.sc.backend.Tokens(stx)
+}
+
+// Get the AuditStore for the transaction
+func (stx *SafeTX) Audit() AuditStore {
+	return stx.sc.backend.Audit(stx)
+}
+
+// Get the JobStore for the transaction
+func (stx *SafeTX) Jobs() JobStore {
+	return stx.sc.backend.Jobs(stx)
+}
+
+// Get the QueueStore for the transaction
+func (stx *SafeTX) Queue() QueueStore {
+	return stx.sc.backend.Queue(stx)
+}
+
+// Get the RateLimitStore for the transaction
+func (stx *SafeTX) RateLimits() RateLimitStore {
+	return stx.sc.backend.RateLimits(stx)
 }
 
 // Query the database inside the transaction
@@ -22,6 +60,21 @@ func (stx *SafeTX) Query(
 	if stx.tx == nil {
 		return nil, errors.New("Cannot query without a transaction")
 	}
+	ctx, span := tracing.Start(ctx, "db.Query", attribute.String("db.statement", query))
+	defer span.End()
+	rows, err := stx.query(ctx, query, args...)
+	tracing.Fail(span, err)
+	return rows, err
+}
+
+func (stx *SafeTX) query(
+	ctx context.Context,
+	query string,
+	args ...interface{},
+) (*sql.Rows, error) {
+	if stmt := stx.stmts.get(query); stmt != nil {
+		return stx.tx.StmtContext(ctx, stmt).QueryContext(ctx, args...)
+	}
 	return stx.tx.QueryContext(ctx, query, args...)
 }
 
@@ -34,9 +87,43 @@ func (stx *SafeTX) Exec(
 	if stx.tx == nil {
 		return nil, errors.New("Cannot exec without a transaction")
 	}
+	ctx, span := tracing.Start(ctx, "db.Exec", attribute.String("db.statement", query))
+	defer span.End()
+	result, err := stx.exec(ctx, query, args...)
+	tracing.Fail(span, err)
+	return result, err
+}
+
+func (stx *SafeTX) exec(
+	ctx context.Context,
+	query string,
+	args ...interface{},
+) (sql.Result, error) {
+	if stmt := stx.stmts.get(query); stmt != nil {
+		return stx.tx.StmtContext(ctx, stmt).ExecContext(ctx, args...)
+	}
 	return stx.tx.ExecContext(ctx, query, args...)
 }
 
+// Run fn inside a savepoint. If fn fails the statements it ran are rolled
+// back and the rest of the transaction can carry on, where PostgreSQL would
+// otherwise abort the whole transaction
+func (stx *SafeTX) Savepoint(ctx context.Context, name string, fn func() error) error {
+	_, err := stx.Exec(ctx, "SAVEPOINT "+name)
+	if err != nil {
+		return errors.Wrap(err, "stx.Exec")
+	}
+	if err := fn(); err != nil {
+		if _, rbErr := stx.Exec(ctx, "ROLLBACK TO SAVEPOINT "+name); rbErr != nil {
+			return errors.Wrap(rbErr, "stx.Exec")
+		}
+		stx.Exec(ctx, "RELEASE SAVEPOINT "+name)
+		return err
+	}
+	_, err = stx.Exec(ctx, "RELEASE SAVEPOINT "+name)
+	return errors.Wrap(err, "stx.Exec")
+}
+
 // Commit the current transaction and release the read lock
 func (stx *SafeTX) Commit() error {
 	if stx.tx == nil {
@@ -44,6 +131,7 @@ func (stx *SafeTX) Commit() error {
 	}
 	err := stx.tx.Commit()
 	stx.tx = nil
+	stx.observe("commit")
 
 	stx.sc.releaseReadLock()
 	return err
@@ -56,6 +144,13 @@ func (stx *SafeTX) Rollback() error {
 	}
 	err := stx.tx.Rollback()
 	stx.tx = nil
+	stx.observe("rollback")
 	stx.sc.releaseReadLock()
 	return err
 }
+
+// Record how long the transaction was open
+func (stx *SafeTX) observe(result string) {
+	metrics.DBTxDuration.WithLabelValues(stx.mode, result).
+		Observe(time.Since(stx.start).Seconds())
+}
@@ -0,0 +1,569 @@
+package db
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+const (
+	sqliteCreateUser  = `INSERT INTO users (username, password_hash) VALUES (?, ?)`
+	sqliteGetUserByID = `SELECT ` + userColumns + `
+        FROM users WHERE id = ? LIMIT 1`
+	sqliteGetUserByUsername = `SELECT ` + userColumns + `
+        FROM users WHERE username = ? COLLATE NOCASE LIMIT 1`
+	sqliteListUsers           = `SELECT ` + userColumns + ` FROM users ORDER BY id`
+	sqliteUsernameTaken       = `SELECT 1 FROM users WHERE username = ? COLLATE NOCASE LIMIT 1`
+	sqliteUpdatePassword      = `UPDATE users SET password_hash = ? WHERE id = ?`
+	sqliteUpdateUsername      = `UPDATE users SET username = ? WHERE id = ?`
+	sqliteUpdateBio           = `UPDATE users SET bio = ? WHERE id = ?`
+	sqliteUpdateLockedAt      = `UPDATE users SET locked_at = ? WHERE id = ?`
+	sqliteUpdateTokensAfter   = `UPDATE users SET tokens_valid_after = ? WHERE id = ?`
+	sqliteDeleteUser          = `DELETE FROM users WHERE id = ?`
+	sqliteRevokeToken         = `INSERT INTO jwtblacklist (jti, exp) VALUES (?, ?)`
+	sqliteTokenRevoked        = `SELECT 1 FROM jwtblacklist WHERE jti = ? LIMIT 1`
+	sqliteDeleteExpiredTokens = `DELETE FROM jwtblacklist WHERE exp < ?`
+	sqliteRecordAudit         = `INSERT INTO audit_log (user_id, event, ip, detail)
+        VALUES (?, ?, ?, ?)`
+	sqliteDeleteAuditBefore = `DELETE FROM audit_log WHERE created_at < ?`
+	sqliteAcquireLease      = `INSERT INTO job_leases (name, holder, run_at, expires_at)
+        VALUES (?, ?, ?, ?)
+        ON CONFLICT (name) DO UPDATE SET holder = excluded.holder,
+        run_at = excluded.run_at, expires_at = excluded.expires_at
+        WHERE job_leases.run_at < excluded.run_at AND job_leases.expires_at < ?`
+	sqliteReleaseLease = `UPDATE job_leases SET expires_at = 0, finished_at = ?,
+        last_status = ?, last_error = ? WHERE name = ? AND holder = ?`
+	sqliteEnqueue = `INSERT INTO queue_jobs (kind, payload, run_after, max_attempts)
+        VALUES (?, ?, ?, ?)`
+	sqliteClaimJob = `UPDATE queue_jobs SET status = 'running', attempts = attempts + 1,
+        locked_by = ?, locked_until = ?
+        WHERE id = (SELECT id FROM queue_jobs
+        WHERE (status = 'pending' AND run_after <= ?)
+        OR (status = 'running' AND locked_until < ?)
+        ORDER BY run_after, id LIMIT 1)
+        RETURNING ` + queueColumns
+	sqliteComplete = `DELETE FROM queue_jobs
+        WHERE id = ? AND locked_by = ? AND status = 'running'`
+	sqliteFailJob = `UPDATE queue_jobs SET status = ?, run_after = ?, last_error = ?,
+        locked_by = '', locked_until = 0
+        WHERE id = ? AND locked_by = ? AND status = 'running'`
+	sqliteListJobs = `SELECT ` + queueColumns + ` FROM queue_jobs
+        WHERE status = ? ORDER BY id LIMIT ?`
+	sqliteRetryJob = `UPDATE queue_jobs SET status = 'pending', attempts = 0, run_after = ?
+        WHERE id = ? AND status = 'dead'`
+	sqliteRetryDead = `UPDATE queue_jobs SET status = 'pending', attempts = 0, run_after = ?
+        WHERE status = 'dead'`
+	sqliteGetRateLimit = `SELECT value, previous, updated, expires_at
+        FROM rate_limits WHERE key = ?`
+	sqlitePutRateLimit = `INSERT INTO rate_limits (key, value, previous, updated, expires_at)
+        VALUES (?, ?, ?, ?, ?)
+        ON CONFLICT (key) DO UPDATE SET value = excluded.value,
+        previous = excluded.previous, updated = excluded.updated,
+        expires_at = excluded.expires_at`
+	sqliteDeleteExpiredRateLimits = `DELETE FROM rate_limits WHERE expires_at < ?`
+)
+
+// Backend for SQLite databases
+type sqliteBackend struct{}
+
+// SQLite implementation of the stores
+var SQLite Backend = sqliteBackend{}
+
+func (sqliteBackend) Name() string {
+	return "sqlite"
+}
+
+func (sqliteBackend) Users(tx *SafeTX) UserStore {
+	return sqliteUserStore{tx: tx}
+}
+
+func (sqliteBackend) Tokens(tx *SafeTX) TokenStore {
+	return sqliteTokenStore{tx: tx}
+}
+
+func (sqliteBackend) Audit(tx *SafeTX) AuditStore {
+	return sqliteAuditStore{tx: tx}
+}
+
+func (sqliteBackend) Jobs(tx *SafeTX) JobStore {
+	return sqliteJobStore{tx: tx}
+}
+
+func (sqliteBackend) Queue(tx *SafeTX) QueueStore {
+	return sqliteQueueStore{tx: tx}
+}
+
+func (sqliteBackend) RateLimits(tx *SafeTX) RateLimitStore {
+	return sqliteRateLimitStore{tx: tx}
+}
+
+func (sqliteBackend) Statements() []string {
+	return []string{
+		sqliteCreateUser,
+		sqliteGetUserByID,
+		sqliteGetUserByUsername,
+		sqliteUsernameTaken,
+		sqliteUpdatePassword,
+		sqliteUpdateUsername,
+		sqliteUpdateBio,
+		sqliteListUsers,
+		sqliteUpdateLockedAt,
+		sqliteUpdateTokensAfter,
+		sqliteDeleteUser,
+		sqliteRevokeToken,
+		sqliteTokenRevoked,
+		sqliteDeleteExpiredTokens,
+		sqliteRecordAudit,
+		sqliteDeleteAuditBefore,
+		sqliteAcquireLease,
+		sqliteReleaseLease,
+		sqliteEnqueue,
+		sqliteClaimJob,
+		sqliteComplete,
+		sqliteFailJob,
+		sqliteListJobs,
+		sqliteRetryJob,
+		sqliteRetryDead,
+		sqliteGetRateLimit,
+		sqlitePutRateLimit,
+		sqliteDeleteExpiredRateLimits,
+	}
+}
+
+type sqliteUserStore struct {
+	tx *SafeTX
+}
+
+// Creates a new user in the database and returns a pointer
+func (s sqliteUserStore) CreateUser(
+	ctx context.Context,
+	username string,
+	passwordHash string,
+) (*User, error) {
+	_, err := s.tx.Exec(ctx, sqliteCreateUser, username, passwordHash)
+	if err != nil {
+		return nil, errors.Wrap(err, "tx.Exec")
+	}
+	user, err := s.GetUserFromUsername(ctx, username)
+	if err != nil {
+		return nil, errors.Wrap(err, "GetUserFromUsername")
+	}
+	return user, nil
+}
+
+// Runs the given user query and scans the first row into a User
+func (s sqliteUserStore) getUser(
+	ctx context.Context,
+	query string,
+	value interface{},
+) (*User, error) {
+	rows, err := s.tx.Query(ctx, query, value)
+	if err != nil {
+		return nil, errors.Wrap(err, "tx.Query")
+	}
+	defer rows.Close()
+	var user User
+	err = scanUserRow(&user, rows)
+	if err != nil {
+		return nil, errors.Wrap(err, "scanUserRow")
+	}
+	return &user, nil
+}
+
+// Queries the database for a user matching the given username.
+// Query is case insensitive
+func (s sqliteUserStore) GetUserFromUsername(
+	ctx context.Context,
+	username string,
+) (*User, error) {
+	user, err := s.getUser(ctx, sqliteGetUserByUsername, username)
+	if err != nil {
+		return nil, errors.Wrap(err, "getUser")
+	}
+	return user, nil
+}
+
+// Queries the database for a user matching the given ID.
+func (s sqliteUserStore) GetUserFromID(ctx context.Context, id int) (*User, error) {
+	user, err := s.getUser(ctx, sqliteGetUserByID, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "getUser")
+	}
+	return user, nil
+}
+
+// Checks if the given username is unique. Returns true if not taken
+func (s sqliteUserStore) CheckUsernameUnique(
+	ctx context.Context,
+	username string,
+) (bool, error) {
+	rows, err := s.tx.Query(ctx, sqliteUsernameTaken, username)
+	if err != nil {
+		return false, errors.Wrap(err, "tx.Query")
+	}
+	defer rows.Close()
+	taken := rows.Next()
+	return !taken, nil
+}
+
+// Set the password hash of the user
+func (s sqliteUserStore) UpdatePasswordHash(
+	ctx context.Context,
+	id int,
+	passwordHash string,
+) error {
+	_, err := s.tx.Exec(ctx, sqliteUpdatePassword, passwordHash, id)
+	if err != nil {
+		return errors.Wrap(err, "tx.Exec")
+	}
+	return nil
+}
+
+// Set the username of the user
+func (s sqliteUserStore) UpdateUsername(
+	ctx context.Context,
+	id int,
+	username string,
+) error {
+	_, err := s.tx.Exec(ctx, sqliteUpdateUsername, username, id)
+	if err != nil {
+		return errors.Wrap(err, "tx.Exec")
+	}
+	return nil
+}
+
+// Set the bio of the user
+func (s sqliteUserStore) UpdateBio(ctx context.Context, id int, bio string) error {
+	_, err := s.tx.Exec(ctx, sqliteUpdateBio, bio, id)
+	if err != nil {
+		return errors.Wrap(err, "tx.Exec")
+	}
+	return nil
+}
+
+// Get every user, ordered by ID
+func (s sqliteUserStore) ListUsers(ctx context.Context) ([]*User, error) {
+	rows, err := s.tx.Query(ctx, sqliteListUsers)
+	if err != nil {
+		return nil, errors.Wrap(err, "tx.Query")
+	}
+	defer rows.Close()
+	users, err := scanUsers(rows)
+	if err != nil {
+		return nil, errors.Wrap(err, "scanUsers")
+	}
+	return users, nil
+}
+
+// Set the time the user was locked, or 0 to unlock them
+func (s sqliteUserStore) UpdateLockedAt(ctx context.Context, id int, lockedAt int64) error {
+	_, err := s.tx.Exec(ctx, sqliteUpdateLockedAt, lockedAt, id)
+	if err != nil {
+		return errors.Wrap(err, "tx.Exec")
+	}
+	return nil
+}
+
+// Set the time tokens issued to the user must be issued after
+func (s sqliteUserStore) UpdateTokensValidAfter(
+	ctx context.Context,
+	id int,
+	validAfter int64,
+) error {
+	_, err := s.tx.Exec(ctx, sqliteUpdateTokensAfter, validAfter, id)
+	if err != nil {
+		return errors.Wrap(err, "tx.Exec")
+	}
+	return nil
+}
+
+// Delete the user
+func (s sqliteUserStore) DeleteUser(ctx context.Context, id int) error {
+	_, err := s.tx.Exec(ctx, sqliteDeleteUser, id)
+	if err != nil {
+		return errors.Wrap(err, "tx.Exec")
+	}
+	return nil
+}
+
+type sqliteTokenStore struct {
+	tx *SafeTX
+}
+
+// Revoke a token by adding it to the database
+func (s sqliteTokenStore) RevokeToken(
+	ctx context.Context,
+	jti uuid.UUID,
+	exp int64,
+) error {
+	_, err := s.tx.Exec(ctx, sqliteRevokeToken, jti, exp)
+	if err != nil {
+		return errors.Wrap(err, "tx.Exec")
+	}
+	return nil
+}
+
+// Check if a token has been revoked. Returns true if not revoked.
+func (s sqliteTokenStore) CheckTokenNotRevoked(
+	ctx context.Context,
+	jti uuid.UUID,
+) (bool, error) {
+	rows, err := s.tx.Query(ctx, sqliteTokenRevoked, jti)
+	if err != nil {
+		return false, errors.Wrap(err, "tx.Query")
+	}
+	defer rows.Close()
+	revoked := rows.Next()
+	return !revoked, nil
+}
+
+// Remove revoked tokens that expired before now
+func (s sqliteTokenStore) DeleteExpiredTokens(ctx context.Context, now int64) (int64, error) {
+	res, err := s.tx.Exec(ctx, sqliteDeleteExpiredTokens, now)
+	if err != nil {
+		return 0, errors.Wrap(err, "tx.Exec")
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "res.RowsAffected")
+	}
+	return count, nil
+}
+
+type sqliteAuditStore struct {
+	tx *SafeTX
+}
+
+// Add an event to the audit log
+func (s sqliteAuditStore) Record(
+	ctx context.Context,
+	userID int,
+	event string,
+	ip string,
+	detail string,
+) error {
+	_, err := s.tx.Exec(ctx, sqliteRecordAudit, userID, event, ip, detail)
+	if err != nil {
+		return errors.Wrap(err, "tx.Exec")
+	}
+	return nil
+}
+
+// Remove events created before the given time
+func (s sqliteAuditStore) DeleteBefore(ctx context.Context, before int64) (int64, error) {
+	res, err := s.tx.Exec(ctx, sqliteDeleteAuditBefore, before)
+	if err != nil {
+		return 0, errors.Wrap(err, "tx.Exec")
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "res.RowsAffected")
+	}
+	return count, nil
+}
+
+type sqliteJobStore struct {
+	tx *SafeTX
+}
+
+// Take the lease on the job for the run scheduled at runAt. The lease is only
+// taken if the previous lease has expired and was for an earlier run
+func (s sqliteJobStore) AcquireLease(
+	ctx context.Context,
+	name string,
+	holder string,
+	runAt int64,
+	expiresAt int64,
+	now int64,
+) (bool, error) {
+	res, err := s.tx.Exec(ctx, sqliteAcquireLease, name, holder, runAt, expiresAt, now)
+	if err != nil {
+		return false, errors.Wrap(err, "tx.Exec")
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.Wrap(err, "res.RowsAffected")
+	}
+	return count == 1, nil
+}
+
+// Release the lease and record the result of the run
+func (s sqliteJobStore) ReleaseLease(
+	ctx context.Context,
+	name string,
+	holder string,
+	finishedAt int64,
+	status string,
+	errMsg string,
+) error {
+	_, err := s.tx.Exec(ctx, sqliteReleaseLease, finishedAt, status, errMsg, name, holder)
+	if err != nil {
+		return errors.Wrap(err, "tx.Exec")
+	}
+	return nil
+}
+
+type sqliteQueueStore struct {
+	tx *SafeTX
+}
+
+// Add a job to the queue to run after runAfter
+func (s sqliteQueueStore) Enqueue(
+	ctx context.Context,
+	kind string,
+	payload []byte,
+	runAfter int64,
+	maxAttempts int,
+) (int64, error) {
+	res, err := s.tx.Exec(ctx, sqliteEnqueue, kind, payload, runAfter, maxAttempts)
+	if err != nil {
+		return 0, errors.Wrap(err, "tx.Exec")
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, errors.Wrap(err, "res.LastInsertId")
+	}
+	return id, nil
+}
+
+// Claim the next job that is ready to run, or whose claim has expired
+func (s sqliteQueueStore) Claim(
+	ctx context.Context,
+	worker string,
+	now int64,
+	lockedUntil int64,
+) (*QueuedJob, error) {
+	rows, err := s.tx.Query(ctx, sqliteClaimJob, worker, lockedUntil, now, now)
+	if err != nil {
+		return nil, errors.Wrap(err, "tx.Query")
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, nil
+	}
+	var job QueuedJob
+	err = scanQueuedJob(&job, rows)
+	if err != nil {
+		return nil, errors.Wrap(err, "scanQueuedJob")
+	}
+	return &job, nil
+}
+
+// Remove a job claimed by the worker after it succeeded
+func (s sqliteQueueStore) Complete(ctx context.Context, id int64, worker string) error {
+	_, err := s.tx.Exec(ctx, sqliteComplete, id, worker)
+	if err != nil {
+		return errors.Wrap(err, "tx.Exec")
+	}
+	return nil
+}
+
+// Release a job claimed by the worker after it failed
+func (s sqliteQueueStore) Fail(
+	ctx context.Context,
+	id int64,
+	worker string,
+	errMsg string,
+	retryAt int64,
+	dead bool,
+) error {
+	status := QueuePending
+	if dead {
+		status = QueueDead
+	}
+	_, err := s.tx.Exec(ctx, sqliteFailJob, status, retryAt, errMsg, id, worker)
+	if err != nil {
+		return errors.Wrap(err, "tx.Exec")
+	}
+	return nil
+}
+
+// Get up to limit jobs with the given status, oldest first
+func (s sqliteQueueStore) List(
+	ctx context.Context,
+	status string,
+	limit int,
+) ([]QueuedJob, error) {
+	rows, err := s.tx.Query(ctx, sqliteListJobs, status, limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "tx.Query")
+	}
+	defer rows.Close()
+	jobs, err := scanQueuedJobs(rows)
+	if err != nil {
+		return nil, errors.Wrap(err, "scanQueuedJobs")
+	}
+	return jobs, nil
+}
+
+// Reset a dead job so it runs again after now
+func (s sqliteQueueStore) Retry(ctx context.Context, id int64, now int64) (bool, error) {
+	res, err := s.tx.Exec(ctx, sqliteRetryJob, now, id)
+	if err != nil {
+		return false, errors.Wrap(err, "tx.Exec")
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.Wrap(err, "res.RowsAffected")
+	}
+	return count == 1, nil
+}
+
+// Reset all dead jobs so they run again after now
+func (s sqliteQueueStore) RetryDead(ctx context.Context, now int64) (int64, error) {
+	res, err := s.tx.Exec(ctx, sqliteRetryDead, now)
+	if err != nil {
+		return 0, errors.Wrap(err, "tx.Exec")
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "res.RowsAffected")
+	}
+	return count, nil
+}
+
+type sqliteRateLimitStore struct {
+	tx *SafeTX
+}
+
+// Get the state of the key. Returns nil if the key has no state
+func (s sqliteRateLimitStore) Get(ctx context.Context, key string) (*RateLimitState, error) {
+	rows, err := s.tx.Query(ctx, sqliteGetRateLimit, key)
+	if err != nil {
+		return nil, errors.Wrap(err, "tx.Query")
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, nil
+	}
+	var state RateLimitState
+	err = rows.Scan(&state.Value, &state.Previous, &state.Updated, &state.ExpiresAt)
+	if err != nil {
+		return nil, errors.Wrap(err, "rows.Scan")
+	}
+	return &state, nil
+}
+
+// Insert or replace the state of the key
+func (s sqliteRateLimitStore) Put(ctx context.Context, key string, state RateLimitState) error {
+	_, err := s.tx.Exec(ctx, sqlitePutRateLimit,
+		key, state.Value, state.Previous, state.Updated, state.ExpiresAt)
+	if err != nil {
+		return errors.Wrap(err, "tx.Exec")
+	}
+	return nil
+}
+
+// Remove state that expired before now
+func (s sqliteRateLimitStore) DeleteExpired(ctx context.Context, now int64) (int64, error) {
+	res, err := s.tx.Exec(ctx, sqliteDeleteExpiredRateLimits, now)
+	if err != nil {
+		return 0, errors.Wrap(err, "tx.Exec")
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "res.RowsAffected")
+	}
+	return count, nil
+}
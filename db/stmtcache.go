@@ -0,0 +1,61 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Cache of prepared statements for a database handle, keyed by the query.
+// Statements are prepared up front with SafeConn.Prepare, as preparing on the
+// handle while a transaction holds the only connection in the pool would
+// block until the transaction finished
+type stmtCache struct {
+	db    *sql.DB
+	mu    sync.RWMutex
+	stmts map[string]*sql.Stmt
+}
+
+// Create a new empty statement cache for the database handle
+func newStmtCache(db *sql.DB) *stmtCache {
+	return &stmtCache{db: db, stmts: make(map[string]*sql.Stmt)}
+}
+
+// Prepare the query and add it to the cache. Does nothing if the query is
+// already cached
+func (c *stmtCache) prepare(ctx context.Context, query string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.stmts[query]; ok {
+		return nil
+	}
+	stmt, err := c.db.PrepareContext(ctx, query)
+	if err != nil {
+		return errors.Wrap(err, "db.PrepareContext")
+	}
+	c.stmts[query] = stmt
+	return nil
+}
+
+// Get the prepared statement for the query. Returns nil if not cached
+func (c *stmtCache) get(query string) *sql.Stmt {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.stmts[query]
+}
+
+// Close all the prepared statements and empty the cache
+func (c *stmtCache) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var firstErr error
+	for query, stmt := range c.stmts {
+		if err := stmt.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(c.stmts, query)
+	}
+	return firstErr
+}
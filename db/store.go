@@ -0,0 +1,149 @@
+package db
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+// Repository for user accounts. Stores are bound to the transaction they
+// were retrieved from with SafeTX.Users()
+type UserStore interface {
+	// Insert a new user with the given username and password hash
+	CreateUser(ctx context.Context, username string, passwordHash string) (*User, error)
+	// Get the user matching the given ID
+	GetUserFromID(ctx context.Context, id int) (*User, error)
+	// Get the user matching the given username. Match is case insensitive
+	GetUserFromUsername(ctx context.Context, username string) (*User, error)
+	// Returns true if the username is not taken. Match is case insensitive
+	CheckUsernameUnique(ctx context.Context, username string) (bool, error)
+	// Set the password hash of the user with the given ID
+	UpdatePasswordHash(ctx context.Context, id int, passwordHash string) error
+	// Set the username of the user with the given ID
+	UpdateUsername(ctx context.Context, id int, username string) error
+	// Set the bio of the user with the given ID
+	UpdateBio(ctx context.Context, id int, bio string) error
+	// Get every user, ordered by ID
+	ListUsers(ctx context.Context) ([]*User, error)
+	// Set the time the user with the given ID was locked. 0 unlocks them
+	UpdateLockedAt(ctx context.Context, id int, lockedAt int64) error
+	// Set the time tokens for the user with the given ID must be issued after
+	UpdateTokensValidAfter(ctx context.Context, id int, validAfter int64) error
+	// Delete the user with the given ID
+	DeleteUser(ctx context.Context, id int) error
+}
+
+// Repository for revoked tokens. Stores are bound to the transaction they
+// were retrieved from with SafeTX.Tokens()
+type TokenStore interface {
+	// Add the token to the blacklist until it expires
+	RevokeToken(ctx context.Context, jti uuid.UUID, exp int64) error
+	// Returns true if the token has not been revoked
+	CheckTokenNotRevoked(ctx context.Context, jti uuid.UUID) (bool, error)
+	// Remove revoked tokens that expired before now. Returns the number removed
+	DeleteExpiredTokens(ctx context.Context, now int64) (int64, error)
+}
+
+// Repository for the audit log. Stores are bound to the transaction they
+// were retrieved from with SafeTX.Audit()
+type AuditStore interface {
+	// Add an event to the audit log
+	Record(ctx context.Context, userID int, event string, ip string, detail string) error
+	// Remove events created before the given time. Returns the number removed
+	DeleteBefore(ctx context.Context, before int64) (int64, error)
+}
+
+// Repository for the leases that stop scheduled jobs running on more than
+// one instance. Stores are bound to the transaction they were retrieved from
+// with SafeTX.Jobs()
+type JobStore interface {
+	// Take the lease on the job for the run scheduled at runAt. Returns false
+	// if another holder has the lease or the run has already been taken
+	AcquireLease(
+		ctx context.Context,
+		name string,
+		holder string,
+		runAt int64,
+		expiresAt int64,
+		now int64,
+	) (bool, error)
+	// Release the lease and record the result of the run
+	ReleaseLease(
+		ctx context.Context,
+		name string,
+		holder string,
+		finishedAt int64,
+		status string,
+		errMsg string,
+	) error
+}
+
+// Repository for the durable job queue. Stores are bound to the transaction
+// they were retrieved from with SafeTX.Queue(), so jobs enqueued are only
+// visible to workers once the transaction commits
+type QueueStore interface {
+	// Add a job to the queue to run after runAfter. Returns the job ID
+	Enqueue(
+		ctx context.Context,
+		kind string,
+		payload []byte,
+		runAfter int64,
+		maxAttempts int,
+	) (int64, error)
+	// Claim the next job that is ready to run, or whose claim has expired.
+	// Returns nil if there are no jobs ready
+	Claim(ctx context.Context, worker string, now int64, lockedUntil int64) (*QueuedJob, error)
+	// Remove a job claimed by the worker after it succeeded
+	Complete(ctx context.Context, id int64, worker string) error
+	// Release a job claimed by the worker after it failed. The job is retried
+	// after retryAt, or marked dead if dead is true
+	Fail(
+		ctx context.Context,
+		id int64,
+		worker string,
+		errMsg string,
+		retryAt int64,
+		dead bool,
+	) error
+	// Get up to limit jobs with the given status, oldest first
+	List(ctx context.Context, status string, limit int) ([]QueuedJob, error)
+	// Reset a dead job so it runs again after now. Returns false if the job
+	// doesn't exist or isn't dead
+	Retry(ctx context.Context, id int64, now int64) (bool, error)
+	// Reset all dead jobs so they run again after now. Returns the number reset
+	RetryDead(ctx context.Context, now int64) (int64, error)
+}
+
+// Repository for the state of the rate limits shared between instances.
+// Stores are bound to the transaction they were retrieved from with
+// SafeTX.RateLimits()
+type RateLimitStore interface {
+	// Get the state of the key, locking it until the transaction ends where
+	// the database supports it. Returns nil if the key has no state
+	Get(ctx context.Context, key string) (*RateLimitState, error)
+	// Insert or replace the state of the key
+	Put(ctx context.Context, key string, state RateLimitState) error
+	// Remove state that expired before now. Returns the number removed
+	DeleteExpired(ctx context.Context, now int64) (int64, error)
+}
+
+// Provides the dialect specific implementations of the stores for a
+// database driver
+type Backend interface {
+	// Name of the database driver, i.e. "sqlite" or "postgres"
+	Name() string
+	// Get a UserStore bound to the transaction
+	Users(tx *SafeTX) UserStore
+	// Get a TokenStore bound to the transaction
+	Tokens(tx *SafeTX) TokenStore
+	// Get an AuditStore bound to the transaction
+	Audit(tx *SafeTX) AuditStore
+	// Get a JobStore bound to the transaction
+	Jobs(tx *SafeTX) JobStore
+	// Get a QueueStore bound to the transaction
+	Queue(tx *SafeTX) QueueStore
+	// Get a RateLimitStore bound to the transaction
+	RateLimits(tx *SafeTX) RateLimitStore
+	// SQL statements used by the stores, prepared when connecting
+	Statements() []string
+}
@@ -0,0 +1,287 @@
+package db
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+
+	"projectreshoot/tests"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStoresSQLite(t *testing.T) {
+	cfg, err := tests.TestConfig()
+	require.NoError(t, err)
+	logger := tests.NilLogger()
+	ver, err := strconv.ParseInt(cfg.DBName, 10, 0)
+	require.NoError(t, err)
+	conn, err := tests.SetupTestDB(ver)
+	require.NoError(t, err)
+	sconn := MakeSafe(conn, logger)
+	defer sconn.Close()
+
+	testStores(t, sconn)
+}
+
+func TestStoresPostgres(t *testing.T) {
+	cfg, err := tests.TestConfig()
+	require.NoError(t, err)
+	logger := tests.NilLogger()
+	ver, err := strconv.ParseInt(cfg.DBName, 10, 0)
+	require.NoError(t, err)
+	conn, err := tests.SetupTestPostgres(ver)
+	if errors.Is(err, tests.ErrPostgresUnavailable) {
+		t.Skip("No PostgreSQL instance available, set TEST_DATABASE_URL to run")
+	}
+	require.NoError(t, err)
+	sconn := MakeSafeWithBackend(conn, Postgres, logger)
+	defer sconn.Close()
+
+	testStores(t, sconn)
+}
+
+// Contract tests that every Backend must pass. Each test runs in its own
+// transaction that is rolled back so the test data is left untouched
+func testStores(t *testing.T, sconn *SafeConn) {
+	t.Run("Get user from ID", func(t *testing.T) {
+		tx, err := sconn.Begin(t.Context())
+		require.NoError(t, err)
+		defer tx.Rollback()
+		user, err := tx.Users().GetUserFromID(t.Context(), 1)
+		require.NoError(t, err)
+		assert.Equal(t, "testuser", user.Username)
+		assert.Equal(t, "bio", user.Bio)
+		_, err = tx.Users().GetUserFromID(t.Context(), 999)
+		require.Error(t, err)
+	})
+	t.Run("Get user from username is case insensitive", func(t *testing.T) {
+		tx, err := sconn.Begin(t.Context())
+		require.NoError(t, err)
+		defer tx.Rollback()
+		user, err := tx.Users().GetUserFromUsername(t.Context(), "TestUser")
+		require.NoError(t, err)
+		assert.Equal(t, 1, user.ID)
+		_, err = tx.Users().GetUserFromUsername(t.Context(), "nobody")
+		require.Error(t, err)
+	})
+	t.Run("Check username unique", func(t *testing.T) {
+		tx, err := sconn.Begin(t.Context())
+		require.NoError(t, err)
+		defer tx.Rollback()
+		unique, err := tx.Users().CheckUsernameUnique(t.Context(), "TESTUSER")
+		require.NoError(t, err)
+		assert.False(t, unique)
+		unique, err = tx.Users().CheckUsernameUnique(t.Context(), "newuser")
+		require.NoError(t, err)
+		assert.True(t, unique)
+	})
+	t.Run("Create and update user", func(t *testing.T) {
+		tx, err := sconn.Begin(t.Context())
+		require.NoError(t, err)
+		defer tx.Rollback()
+		users := tx.Users()
+		user, err := users.CreateUser(t.Context(), "newuser", "hash")
+		require.NoError(t, err)
+		assert.NotEqual(t, 1, user.ID)
+		assert.Equal(t, "hash", user.Password_hash)
+		assert.NotZero(t, user.Created_at)
+
+		require.NoError(t, users.UpdateUsername(t.Context(), user.ID, "renamed"))
+		require.NoError(t, users.UpdateBio(t.Context(), user.ID, "new bio"))
+		require.NoError(t, users.UpdatePasswordHash(t.Context(), user.ID, "newhash"))
+		updated, err := users.GetUserFromID(t.Context(), user.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "renamed", updated.Username)
+		assert.Equal(t, "new bio", updated.Bio)
+		assert.Equal(t, "newhash", updated.Password_hash)
+	})
+	t.Run("List, lock and delete users", func(t *testing.T) {
+		tx, err := sconn.Begin(t.Context())
+		require.NoError(t, err)
+		defer tx.Rollback()
+		users := tx.Users()
+		user, err := users.CreateUser(t.Context(), "newuser", "hash")
+		require.NoError(t, err)
+		assert.Zero(t, user.Locked_at)
+		assert.Zero(t, user.Tokens_valid_after)
+		list, err := users.ListUsers(t.Context())
+		require.NoError(t, err)
+		require.Len(t, list, 2)
+		assert.Equal(t, "testuser", list[0].Username)
+		assert.Equal(t, "newuser", list[1].Username)
+
+		require.NoError(t, users.UpdateLockedAt(t.Context(), user.ID, 1700000000))
+		require.NoError(t, users.UpdateTokensValidAfter(t.Context(), user.ID, 1700000001))
+		updated, err := users.GetUserFromID(t.Context(), user.ID)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1700000000), updated.Locked_at)
+		assert.Equal(t, int64(1700000001), updated.Tokens_valid_after)
+
+		require.NoError(t, users.DeleteUser(t.Context(), user.ID))
+		_, err = users.GetUserFromID(t.Context(), user.ID)
+		require.Error(t, err)
+	})
+	t.Run("Tokens issued after a revoke are allowed", func(t *testing.T) {
+		tx, err := sconn.Begin(t.Context())
+		require.NoError(t, err)
+		defer tx.Rollback()
+		user, err := tx.Users().CreateUser(t.Context(), "newuser", "hash")
+		require.NoError(t, err)
+		require.NoError(t, user.RevokeTokens(t.Context(), tx))
+		assert.NoError(t, user.CheckTokenAllowed(user.Tokens_valid_after), "issued in the same second")
+		assert.Error(t, user.CheckTokenAllowed(user.Tokens_valid_after-1))
+	})
+	t.Run("Revoked tokens are not valid", func(t *testing.T) {
+		tx, err := sconn.Begin(t.Context())
+		require.NoError(t, err)
+		defer tx.Rollback()
+		tokens := tx.Tokens()
+		revoked := uuid.MustParse("0a6b338e-930a-43fe-8f70-1a6daed256fa")
+		valid, err := tokens.CheckTokenNotRevoked(t.Context(), revoked)
+		require.NoError(t, err)
+		assert.False(t, valid)
+
+		jti := uuid.New()
+		valid, err = tokens.CheckTokenNotRevoked(t.Context(), jti)
+		require.NoError(t, err)
+		assert.True(t, valid)
+		require.NoError(t, tokens.RevokeToken(t.Context(), jti, 33299675344))
+		valid, err = tokens.CheckTokenNotRevoked(t.Context(), jti)
+		require.NoError(t, err)
+		assert.False(t, valid)
+	})
+	t.Run("Expired tokens are deleted", func(t *testing.T) {
+		tx, err := sconn.Begin(t.Context())
+		require.NoError(t, err)
+		defer tx.Rollback()
+		tokens := tx.Tokens()
+		expired := uuid.New()
+		require.NoError(t, tokens.RevokeToken(t.Context(), expired, 33299675300))
+		count, err := tokens.DeleteExpiredTokens(t.Context(), 33299675340)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), count)
+		valid, err := tokens.CheckTokenNotRevoked(t.Context(), expired)
+		require.NoError(t, err)
+		assert.True(t, valid)
+		revoked := uuid.MustParse("0a6b338e-930a-43fe-8f70-1a6daed256fa")
+		valid, err = tokens.CheckTokenNotRevoked(t.Context(), revoked)
+		require.NoError(t, err)
+		assert.False(t, valid)
+	})
+	t.Run("Audit events are deleted after retention", func(t *testing.T) {
+		tx, err := sconn.Begin(t.Context())
+		require.NoError(t, err)
+		defer tx.Rollback()
+		audit := tx.Audit()
+		require.NoError(t, audit.Record(t.Context(), 1, "login", "127.0.0.1", ""))
+		count, err := audit.DeleteBefore(t.Context(), 1000)
+		require.NoError(t, err)
+		assert.Equal(t, int64(0), count)
+		count, err = audit.DeleteBefore(t.Context(), 33299675344)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), count)
+	})
+	t.Run("Job lease is only acquired once per run", func(t *testing.T) {
+		tx, err := sconn.Begin(t.Context())
+		require.NoError(t, err)
+		defer tx.Rollback()
+		leases := tx.Jobs()
+		ok, err := leases.AcquireLease(t.Context(), "job", "a", 100, 200, 100)
+		require.NoError(t, err)
+		assert.True(t, ok)
+		// Held and not expired
+		ok, err = leases.AcquireLease(t.Context(), "job", "b", 160, 260, 150)
+		require.NoError(t, err)
+		assert.False(t, ok)
+		require.NoError(t, leases.ReleaseLease(t.Context(), "job", "a", 150, "ok", ""))
+		// Released, but the run was already taken
+		ok, err = leases.AcquireLease(t.Context(), "job", "b", 100, 260, 160)
+		require.NoError(t, err)
+		assert.False(t, ok)
+		ok, err = leases.AcquireLease(t.Context(), "job", "b", 160, 260, 160)
+		require.NoError(t, err)
+		assert.True(t, ok)
+	})
+	t.Run("Queued jobs are claimed, failed and retried", func(t *testing.T) {
+		tx, err := sconn.Begin(t.Context())
+		require.NoError(t, err)
+		defer tx.Rollback()
+		queue := tx.Queue()
+		id, err := queue.Enqueue(t.Context(), "email", []byte(`{"to":1}`), 100, 2)
+		require.NoError(t, err)
+		_, err = queue.Enqueue(t.Context(), "email", []byte(`{}`), 500, 0)
+		require.NoError(t, err)
+
+		job, err := queue.Claim(t.Context(), "worker", 100, 160)
+		require.NoError(t, err)
+		require.NotNil(t, job)
+		assert.Equal(t, id, job.ID)
+		assert.Equal(t, `{"to":1}`, string(job.Payload))
+		assert.Equal(t, 1, job.Attempts)
+		assert.Equal(t, QueueRunning, job.Status)
+		// Second job isn't ready and the first is claimed
+		job, err = queue.Claim(t.Context(), "other", 150, 210)
+		require.NoError(t, err)
+		assert.Nil(t, job)
+		// Claim expired so another worker can take it
+		job, err = queue.Claim(t.Context(), "other", 170, 230)
+		require.NoError(t, err)
+		require.NotNil(t, job)
+		assert.Equal(t, 2, job.Attempts)
+		// Expired claim can't record a result
+		require.NoError(t, queue.Fail(t.Context(), id, "worker", "late", 180, false))
+		running, err := queue.List(t.Context(), QueueRunning, 10)
+		require.NoError(t, err)
+		require.Len(t, running, 1)
+		assert.Equal(t, "other", running[0].LockedBy)
+
+		require.NoError(t, queue.Fail(t.Context(), id, "other", "broken", 180, true))
+		dead, err := queue.List(t.Context(), QueueDead, 10)
+		require.NoError(t, err)
+		require.Len(t, dead, 1)
+		assert.Equal(t, "broken", dead[0].LastError)
+
+		retried, err := queue.Retry(t.Context(), id, 200)
+		require.NoError(t, err)
+		assert.True(t, retried)
+		retried, err = queue.Retry(t.Context(), id, 200)
+		require.NoError(t, err)
+		assert.False(t, retried)
+		job, err = queue.Claim(t.Context(), "worker", 200, 260)
+		require.NoError(t, err)
+		require.NotNil(t, job)
+		assert.Equal(t, 1, job.Attempts)
+		require.NoError(t, queue.Complete(t.Context(), id, "worker"))
+		pending, err := queue.List(t.Context(), QueuePending, 10)
+		require.NoError(t, err)
+		require.Len(t, pending, 1)
+		assert.NotEqual(t, id, pending[0].ID)
+	})
+	t.Run("Rate limit state", func(t *testing.T) {
+		tx, err := sconn.Begin(t.Context())
+		require.NoError(t, err)
+		defer tx.Rollback()
+		limits := tx.RateLimits()
+		state, err := limits.Get(t.Context(), "test:key")
+		require.NoError(t, err)
+		assert.Nil(t, state)
+		put := RateLimitState{Value: 2.5, Previous: 1, Updated: 100, ExpiresAt: 200}
+		require.NoError(t, limits.Put(t.Context(), "test:key", put))
+		put.Value = 1.5
+		require.NoError(t, limits.Put(t.Context(), "test:key", put))
+		state, err = limits.Get(t.Context(), "test:key")
+		require.NoError(t, err)
+		require.NotNil(t, state)
+		assert.Equal(t, put, *state)
+		require.NoError(t, limits.Put(t.Context(), "test:other", RateLimitState{ExpiresAt: 400}))
+		count, err := limits.DeleteExpired(t.Context(), 300)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), count)
+		state, err = limits.Get(t.Context(), "test:key")
+		require.NoError(t, err)
+		assert.Nil(t, state)
+	})
+}
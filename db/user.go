@@ -2,6 +2,9 @@ package db
 
 import (
 	"context"
+	"time"
+
+	"projectreshoot/tracing"
 
 	"github.com/pkg/errors"
 	"golang.org/x/crypto/bcrypt"
@@ -13,25 +16,31 @@ type User struct {
 	Password_hash string // Bcrypt password hash
 	Created_at    int64  // Epoch timestamp when the user was added to the database
 	Bio           string // Short byline set by the user
+	// Epoch timestamp when the user was locked out. 0 if not locked
+	Locked_at int64
+	// Epoch timestamp tokens must be issued after to be accepted. Set to
+	// revoke every token issued to the user
+	Tokens_valid_after int64
 }
 
 // Uses bcrypt to set the users Password_hash from the given password
 func (user *User) SetPassword(ctx context.Context, tx *SafeTX, password string) error {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	hashedPassword, err := hashPassword(ctx, password)
 	if err != nil {
-		return errors.Wrap(err, "bcrypt.GenerateFromPassword")
+		return errors.Wrap(err, "hashPassword")
 	}
-	user.Password_hash = string(hashedPassword)
-	query := `UPDATE users SET password_hash = ? WHERE id = ?`
-	_, err = tx.Exec(ctx, query, user.Password_hash, user.ID)
+	user.Password_hash = hashedPassword
+	err = tx.Users().UpdatePasswordHash(ctx, user.ID, user.Password_hash)
 	if err != nil {
-		return errors.Wrap(err, "tx.Exec")
+		return errors.Wrap(err, "tx.Users().UpdatePasswordHash")
 	}
 	return nil
 }
 
 // Uses bcrypt to check if the given password matches the users Password_hash
-func (user *User) CheckPassword(password string) error {
+func (user *User) CheckPassword(ctx context.Context, password string) error {
+	_, span := tracing.Start(ctx, "bcrypt.CompareHashAndPassword")
+	defer span.End()
 	err := bcrypt.CompareHashAndPassword([]byte(user.Password_hash), []byte(password))
 	if err != nil {
 		return errors.Wrap(err, "bcrypt.CompareHashAndPassword")
@@ -41,20 +50,73 @@ func (user *User) CheckPassword(password string) error {
 
 // Change the user's username
 func (user *User) ChangeUsername(ctx context.Context, tx *SafeTX, newUsername string) error {
-	query := `UPDATE users SET username = ? WHERE id = ?`
-	_, err := tx.Exec(ctx, query, newUsername, user.ID)
+	err := tx.Users().UpdateUsername(ctx, user.ID, newUsername)
 	if err != nil {
-		return errors.Wrap(err, "tx.Exec")
+		return errors.Wrap(err, "tx.Users().UpdateUsername")
 	}
 	return nil
 }
 
 // Change the user's bio
 func (user *User) ChangeBio(ctx context.Context, tx *SafeTX, newBio string) error {
-	query := `UPDATE users SET bio = ? WHERE id = ?`
-	_, err := tx.Exec(ctx, query, newBio, user.ID)
+	err := tx.Users().UpdateBio(ctx, user.ID, newBio)
 	if err != nil {
-		return errors.Wrap(err, "tx.Exec")
+		return errors.Wrap(err, "tx.Users().UpdateBio")
 	}
 	return nil
 }
+
+// Returns true if the user has been locked out
+func (user *User) Locked() bool {
+	return user.Locked_at != 0
+}
+
+// Lock the user out, or let them back in if locked is false
+func (user *User) SetLocked(ctx context.Context, tx *SafeTX, locked bool) error {
+	lockedAt := int64(0)
+	if locked {
+		lockedAt = time.Now().Unix()
+	}
+	err := tx.Users().UpdateLockedAt(ctx, user.ID, lockedAt)
+	if err != nil {
+		return errors.Wrap(err, "tx.Users().UpdateLockedAt")
+	}
+	user.Locked_at = lockedAt
+	return nil
+}
+
+// Revoke every token issued to the user so far, logging them out everywhere
+func (user *User) RevokeTokens(ctx context.Context, tx *SafeTX) error {
+	validAfter := time.Now().Unix()
+	err := tx.Users().UpdateTokensValidAfter(ctx, user.ID, validAfter)
+	if err != nil {
+		return errors.Wrap(err, "tx.Users().UpdateTokensValidAfter")
+	}
+	user.Tokens_valid_after = validAfter
+	return nil
+}
+
+// Check the user can be authenticated with a token issued at the given
+// epoch timestamp. Tokens issued in the same second they were revoked are
+// allowed so a token issued straight after revoking is valid
+func (user *User) CheckTokenAllowed(issuedAt int64) error {
+	if user.Locked() {
+		return errors.New("User is locked")
+	}
+	if issuedAt < user.Tokens_valid_after {
+		return errors.New("Token was issued before the user's tokens were revoked")
+	}
+	return nil
+}
+
+// Hash the password with bcrypt
+func hashPassword(ctx context.Context, password string) (string, error) {
+	_, span := tracing.Start(ctx, "bcrypt.GenerateFromPassword")
+	defer span.End()
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		tracing.Fail(span, err)
+		return "", err
+	}
+	return string(hashedPassword), nil
+}
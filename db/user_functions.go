@@ -3,7 +3,6 @@ package db
 import (
 	"context"
 	"database/sql"
-	"fmt"
 
 	"github.com/pkg/errors"
 )
@@ -15,46 +14,20 @@ func CreateNewUser(
 	username string,
 	password string,
 ) (*User, error) {
-	query := `INSERT INTO users (username) VALUES (?)`
-	_, err := tx.Exec(ctx, query, username)
+	hashedPassword, err := hashPassword(ctx, password)
 	if err != nil {
-		return nil, errors.Wrap(err, "tx.Exec")
+		return nil, errors.Wrap(err, "hashPassword")
 	}
-	user, err := GetUserFromUsername(ctx, tx, username)
+	user, err := tx.Users().CreateUser(ctx, username, hashedPassword)
 	if err != nil {
-		return nil, errors.Wrap(err, "GetUserFromUsername")
-	}
-	err = user.SetPassword(ctx, tx, password)
-	if err != nil {
-		return nil, errors.Wrap(err, "user.SetPassword")
+		return nil, errors.Wrap(err, "tx.Users().CreateUser")
 	}
 	return user, nil
 }
 
-// Fetches data from the users table using "WHERE column = 'value'"
-func fetchUserData(
-	ctx context.Context,
-	tx *SafeTX,
-	column string,
-	value interface{},
-) (*sql.Rows, error) {
-	query := fmt.Sprintf(
-		`SELECT 
-            id,
-            username, 
-            password_hash, 
-            created_at,
-            bio
-        FROM users 
-	    WHERE %s = ? COLLATE NOCASE LIMIT 1`,
-		column,
-	)
-	rows, err := tx.Query(ctx, query, value)
-	if err != nil {
-		return nil, errors.Wrap(err, "tx.Query")
-	}
-	return rows, nil
-}
+// Columns selected by the user queries, in the order scanUser expects
+const userColumns = `id, username, password_hash, created_at, bio,
+        locked_at, tokens_valid_after`
 
 // Calls rows.Next() and scans the row into the provided user pointer.
 // Will error if no row available
@@ -62,12 +35,19 @@ func scanUserRow(user *User, rows *sql.Rows) error {
 	if !rows.Next() {
 		return errors.New("User not found")
 	}
+	return scanUser(user, rows)
+}
+
+// Scan the current row into the provided user pointer
+func scanUser(user *User, rows *sql.Rows) error {
 	err := rows.Scan(
 		&user.ID,
 		&user.Username,
 		&user.Password_hash,
 		&user.Created_at,
 		&user.Bio,
+		&user.Locked_at,
+		&user.Tokens_valid_after,
 	)
 	if err != nil {
 		return errors.Wrap(err, "rows.Scan")
@@ -75,45 +55,19 @@ func scanUserRow(user *User, rows *sql.Rows) error {
 	return nil
 }
 
-// Queries the database for a user matching the given username.
-// Query is case insensitive
-func GetUserFromUsername(ctx context.Context, tx *SafeTX, username string) (*User, error) {
-	rows, err := fetchUserData(ctx, tx, "username", username)
-	if err != nil {
-		return nil, errors.Wrap(err, "fetchUserData")
-	}
-	defer rows.Close()
-	var user User
-	err = scanUserRow(&user, rows)
-	if err != nil {
-		return nil, errors.Wrap(err, "scanUserRow")
-	}
-	return &user, nil
-}
-
-// Queries the database for a user matching the given ID.
-func GetUserFromID(ctx context.Context, tx *SafeTX, id int) (*User, error) {
-	rows, err := fetchUserData(ctx, tx, "id", id)
-	if err != nil {
-		return nil, errors.Wrap(err, "fetchUserData")
+// Scan every row into a list of users
+func scanUsers(rows *sql.Rows) ([]*User, error) {
+	users := []*User{}
+	for rows.Next() {
+		var user User
+		err := scanUser(&user, rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, &user)
 	}
-	defer rows.Close()
-	var user User
-	err = scanUserRow(&user, rows)
-	if err != nil {
-		return nil, errors.Wrap(err, "scanUserRow")
-	}
-	return &user, nil
-}
-
-// Checks if the given username is unique. Returns true if not taken
-func CheckUsernameUnique(ctx context.Context, tx *SafeTX, username string) (bool, error) {
-	query := `SELECT 1 FROM users WHERE username = ? COLLATE NOCASE LIMIT 1`
-	rows, err := tx.Query(ctx, query, username)
-	if err != nil {
-		return false, errors.Wrap(err, "tx.Query")
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "rows.Err")
 	}
-	defer rows.Close()
-	taken := rows.Next()
-	return !taken, nil
+	return users, nil
 }
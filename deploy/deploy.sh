@@ -33,7 +33,7 @@ if [ ! -f "${RELEASES_DIR}/${BINARY_NAME}" ]; then
   echo "Binary ${BINARY_NAME} not found in ${RELEASES_DIR}"
   exit 1
 fi
-DB_VER=$(${RELEASES_DIR}/${BINARY_NAME} --dbver | grep -oP '(?<=Database version: ).*')
+DB_VER=$(${RELEASES_DIR}/${BINARY_NAME} version | grep -oP '(?<=Database version: ).*')
 ${MIGRATION_BIN}/migrate.sh $ENVR $DB_VER $COMMIT_HASH
 if [[ $? -ne 0 ]]; then
     echo "Migration failed"
@@ -74,10 +74,31 @@ rollback_deployment() {
 echo "Promoting ${BINARY_NAME} to ${DEPLOY_BIN}..."
 ln -sf "${RELEASES_DIR}/${BINARY_NAME}" "${DEPLOY_BIN}"
 
-WAIT_TIME=5
+READY_TIMEOUT=30
+# Wait for the instance to report it's ready to serve requests
+wait_ready() {
+  local port=$1
+  for ((i = 0; i < READY_TIMEOUT; i++)); do
+    if curl -fsS -o /dev/null "http://127.0.0.1:${port}/readyz"; then
+      return 0
+    fi
+    sleep 1
+  done
+  return 1
+}
+
 restart_service() {
   local port=$1
   local SERVICE="${SERVICE_NAME}@${port}.service"
+  local SOCKET="${SERVICE_NAME}@${port}.socket"
+
+  # The socket holds the port while the service restarts. The service has to
+  # be stopped the first time so the socket can bind it
+  if ! systemctl is-active --quiet "$SOCKET"; then
+    echo "Starting ${SOCKET}..."
+    sudo systemctl stop "$SERVICE"
+    sudo systemctl enable --now "$SOCKET"
+  fi
   echo "Restarting ${SERVICE}..."
 
   # Restart the service
@@ -89,12 +110,9 @@ restart_service() {
     exit 1
   fi
 
-  # Wait a few seconds to allow the service to fully start
-  echo "Waiting for ${SERVICE} to fully start..."
-  sleep $WAIT_TIME
-
-  # Check the status of the service
-  if ! systemctl is-active --quiet "${SERVICE}"; then
+  # Wait for the service to be ready before restarting the next one
+  echo "Waiting for ${SERVICE} to be ready..."
+  if ! wait_ready "$port" || ! systemctl is-active --quiet "${SERVICE}"; then
     echo "Error: ${SERVICE} failed to start correctly. Rolling back deployment."
 
     # Call the rollback function
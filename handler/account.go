@@ -48,22 +48,23 @@ func ChangeUsername(
 ) http.Handler {
 	return http.HandlerFunc(
 		func(w http.ResponseWriter, r *http.Request) {
+			log := contexts.GetLogger(r.Context(), logger)
 			ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
 			defer cancel()
 
 			// Start the transaction
 			tx, err := conn.Begin(ctx)
 			if err != nil {
-				logger.Warn().Err(err).Msg("Error updating username")
+				log.Warn().Err(err).Msg("Error updating username")
 				w.WriteHeader(http.StatusServiceUnavailable)
 				return
 			}
 			r.ParseForm()
 			newUsername := r.FormValue("username")
-			unique, err := db.CheckUsernameUnique(ctx, tx, newUsername)
+			unique, err := tx.Users().CheckUsernameUnique(ctx, newUsername)
 			if err != nil {
 				tx.Rollback()
-				logger.Error().Err(err).Msg("Error updating username")
+				log.Error().Err(err).Msg("Error updating username")
 				w.WriteHeader(http.StatusInternalServerError)
 				return
 			}
@@ -74,14 +75,19 @@ func ChangeUsername(
 				return
 			}
 			user := contexts.GetUser(r.Context())
+			oldUsername := user.Username
 			err = user.ChangeUsername(ctx, tx, newUsername)
 			if err != nil {
 				tx.Rollback()
-				logger.Error().Err(err).Msg("Error updating username")
+				log.Error().Err(err).Msg("Error updating username")
 				w.WriteHeader(http.StatusInternalServerError)
 				return
 			}
-			tx.Commit()
+			RecordAudit(ctx, tx, r, log, user.ID, auditChangeUsername,
+				oldUsername+" -> "+newUsername)
+			if !commitTx(tx, w, log) {
+				return
+			}
 			w.Header().Set("HX-Refresh", "true")
 		},
 	)
@@ -94,13 +100,14 @@ func ChangeBio(
 ) http.Handler {
 	return http.HandlerFunc(
 		func(w http.ResponseWriter, r *http.Request) {
+			log := contexts.GetLogger(r.Context(), logger)
 			ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
 			defer cancel()
 
 			// Start the transaction
 			tx, err := conn.Begin(ctx)
 			if err != nil {
-				logger.Warn().Err(err).Msg("Error updating bio")
+				log.Warn().Err(err).Msg("Error updating bio")
 				w.WriteHeader(http.StatusServiceUnavailable)
 				return
 			}
@@ -117,7 +124,7 @@ func ChangeBio(
 			err = user.ChangeBio(ctx, tx, newBio)
 			if err != nil {
 				tx.Rollback()
-				logger.Error().Err(err).Msg("Error updating bio")
+				log.Error().Err(err).Msg("Error updating bio")
 				w.WriteHeader(http.StatusInternalServerError)
 				return
 			}
@@ -150,13 +157,14 @@ func ChangePassword(
 ) http.Handler {
 	return http.HandlerFunc(
 		func(w http.ResponseWriter, r *http.Request) {
+			log := contexts.GetLogger(r.Context(), logger)
 			ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
 			defer cancel()
 
 			// Start the transaction
 			tx, err := conn.Begin(ctx)
 			if err != nil {
-				logger.Warn().Err(err).Msg("Error updating password")
+				log.Warn().Err(err).Msg("Error updating password")
 				w.WriteHeader(http.StatusServiceUnavailable)
 				return
 			}
@@ -170,11 +178,14 @@ func ChangePassword(
 			err = user.SetPassword(ctx, tx, newPass)
 			if err != nil {
 				tx.Rollback()
-				logger.Error().Err(err).Msg("Error updating password")
+				log.Error().Err(err).Msg("Error updating password")
 				w.WriteHeader(http.StatusInternalServerError)
 				return
 			}
-			tx.Commit()
+			RecordAudit(ctx, tx, r, log, user.ID, auditChangePassword, "")
+			if !commitTx(tx, w, log) {
+				return
+			}
 			w.Header().Set("HX-Refresh", "true")
 		},
 	)
@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+
+	"projectreshoot/contexts"
+	"projectreshoot/db"
+
+	"github.com/rs/zerolog"
+)
+
+// Audit log events
+const (
+	auditLogin          = "login"
+	auditLogout         = "logout"
+	auditRegister       = "register"
+	auditChangeUsername = "change_username"
+	auditChangePassword = "change_password"
+)
+
+// Audit event for tokens refreshed by the authentication middleware, so each
+// session can be followed across the client IPs it was used from
+const AuditRefresh = "refresh"
+
+// Record an audit event in the transaction with the client IP resolved for
+// the request. The event is written in a savepoint so a failure doesn't
+// abort the transaction, and is logged rather than failing the request
+func RecordAudit(
+	ctx context.Context,
+	tx *db.SafeTX,
+	r *http.Request,
+	log *zerolog.Logger,
+	userID int,
+	event string,
+	detail string,
+) {
+	ip := ""
+	if addr := contexts.GetClientIP(r.Context()); addr.IsValid() {
+		ip = addr.String()
+	}
+	err := tx.Savepoint(ctx, "audit", func() error {
+		return tx.Audit().Record(ctx, userID, event, ip, detail)
+	})
+	if err != nil {
+		log.Warn().Err(err).Str("event", event).Msg("Failed to record audit event")
+	}
+}
+
+// Commit the transaction, responding with a 500 if it fails. Cookies set
+// for the change are dropped so the client isn't told about a change that
+// never happened. Returns false if the commit failed
+func commitTx(tx *db.SafeTX, w http.ResponseWriter, log *zerolog.Logger) bool {
+	if err := tx.Commit(); err != nil {
+		log.Error().Err(err).Msg("Failed to commit the transaction")
+		w.Header().Del("Set-Cookie")
+		w.WriteHeader(http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
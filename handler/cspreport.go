@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"projectreshoot/contexts"
+
+	"github.com/rs/zerolog"
+)
+
+// Max size of a violation report body
+const cspReportMaxBytes = 64 << 10
+
+// Violation report sent by report-uri (application/csp-report)
+type cspReportURI struct {
+	Report cspViolation `json:"csp-report"`
+}
+
+// Violation report sent by the Reporting API (application/reports+json)
+type cspReportTo struct {
+	Type string `json:"type"`
+	Body struct {
+		DocumentURL        string `json:"documentURL"`
+		BlockedURL         string `json:"blockedURL"`
+		EffectiveDirective string `json:"effectiveDirective"`
+		Disposition        string `json:"disposition"`
+		SourceFile         string `json:"sourceFile"`
+		LineNumber         int    `json:"lineNumber"`
+	} `json:"body"`
+}
+
+type cspViolation struct {
+	DocumentURI        string `json:"document-uri"`
+	BlockedURI         string `json:"blocked-uri"`
+	EffectiveDirective string `json:"effective-directive"`
+	ViolatedDirective  string `json:"violated-directive"`
+	Disposition        string `json:"disposition"`
+	SourceFile         string `json:"source-file"`
+	LineNumber         int    `json:"line-number"`
+}
+
+// Collects Content-Security-Policy violation reports from browsers and logs
+// them. Accepts both the report-uri and Reporting API formats
+func CSPReport(logger *zerolog.Logger) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			log := contexts.GetLogger(r.Context(), logger)
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, cspReportMaxBytes))
+			if err != nil {
+				w.WriteHeader(http.StatusRequestEntityTooLarge)
+				return
+			}
+			violations := parseCSPReport(body)
+			if violations == nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			for _, v := range violations {
+				directive := v.EffectiveDirective
+				if directive == "" {
+					directive = v.ViolatedDirective
+				}
+				log.Warn().
+					Str("document_uri", v.DocumentURI).
+					Str("blocked_uri", v.BlockedURI).
+					Str("directive", directive).
+					Str("disposition", v.Disposition).
+					Str("source_file", v.SourceFile).
+					Int("line_number", v.LineNumber).
+					Str("user_agent", r.UserAgent()).
+					Msg("CSP violation")
+			}
+			w.WriteHeader(http.StatusNoContent)
+		},
+	)
+}
+
+// Parse the violations in a report body. Returns nil if the body isn't a
+// violation report in either format
+func parseCSPReport(body []byte) []cspViolation {
+	var single cspReportURI
+	if err := json.Unmarshal(body, &single); err == nil && single.Report.DocumentURI != "" {
+		return []cspViolation{single.Report}
+	}
+	var reports []cspReportTo
+	if err := json.Unmarshal(body, &reports); err != nil {
+		return nil
+	}
+	violations := []cspViolation{}
+	for _, report := range reports {
+		if report.Type != "csp-violation" {
+			continue
+		}
+		violations = append(violations, cspViolation{
+			DocumentURI:        report.Body.DocumentURL,
+			BlockedURI:         report.Body.BlockedURL,
+			EffectiveDirective: report.Body.EffectiveDirective,
+			Disposition:        report.Body.Disposition,
+			SourceFile:         report.Body.SourceFile,
+			LineNumber:         report.Body.LineNumber,
+		})
+	}
+	return violations
+}
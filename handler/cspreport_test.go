@@ -0,0 +1,38 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"projectreshoot/tests"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCSPReport(t *testing.T) {
+	report := CSPReport(tests.NilLogger())
+	post := func(body string) int {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/csp-report", strings.NewReader(body))
+		report.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	t.Run("report-uri format", func(t *testing.T) {
+		body := `{"csp-report":{"document-uri":"https://example.com/",` +
+			`"blocked-uri":"inline","violated-directive":"script-src"}}`
+		assert.Equal(t, http.StatusNoContent, post(body))
+	})
+	t.Run("Reporting API format", func(t *testing.T) {
+		body := `[{"type":"csp-violation","body":{"documentURL":"https://example.com/",` +
+			`"blockedURL":"inline","effectiveDirective":"script-src-elem"}}]`
+		assert.Equal(t, http.StatusNoContent, post(body))
+		assert.Len(t, parseCSPReport([]byte(body)), 1)
+	})
+	t.Run("Invalid bodies are rejected", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, post("not json"))
+		assert.Equal(t, http.StatusRequestEntityTooLarge, post(strings.Repeat("a", cspReportMaxBytes+1)))
+	})
+}
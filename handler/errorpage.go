@@ -1,24 +1,113 @@
 package handler
 
 import (
+	"encoding/json"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
+
+	"projectreshoot/contexts"
 	"projectreshoot/view/page"
 )
 
+// Seconds clients are told to wait before retrying a 503 response
+const retryAfterSeconds = 30
+
+// Messages shown to the user for each error code
+var errorMessages = map[int]string{
+	400: "The request could not be understood by the server.",
+	401: "You need to login to view this page.",
+	403: "You do not have permission to view this page.",
+	404: "The page or resource you have requested does not exist.",
+	405: "The request method is not allowed for this resource.",
+	413: "The request is too large.",
+	429: "You have made too many requests. Please wait and try again.",
+	500: `An error occured on the server. Please try again, and if this
+        continues to happen contact an administrator.`,
+	503: "The server is currently down for maintenance and should be back soon. =)",
+}
+
+// Problem details response body (RFC 9457)
+type problemDetails struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// Send an error response with the status code. The format depends on the
+// request:
+//   - JSON problem details if the client asks for JSON
+//   - An HTML fragment retargeted to the page content for HTMX requests.
+//     500 and 503 responses have no body, the page shows an error popup
+//   - A full error page otherwise
+//
+// 503 responses include a Retry-After header
 func ErrorPage(
 	errorCode int,
 	w http.ResponseWriter,
 	r *http.Request,
 ) {
-	message := map[int]string{
-		401: "You need to login to view this page.",
-		403: "You do not have permission to view this page.",
-		404: "The page or resource you have requested does not exist.",
-		500: `An error occured on the server. Please try again, and if this
-        continues to happen contact an administrator.`,
-		503: "The server is currently down for maintenance and should be back soon. =)",
+	title := http.StatusText(errorCode)
+	message := errorMessages[errorCode]
+	if errorCode == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds))
+	}
+
+	switch {
+	case wantsJSON(r):
+		problem := problemDetails{
+			Type:      "about:blank",
+			Title:     title,
+			Status:    errorCode,
+			Detail:    strings.Join(strings.Fields(message), " "),
+			Instance:  r.URL.Path,
+			RequestID: contexts.GetRequestID(r.Context()),
+		}
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(errorCode)
+		json.NewEncoder(w).Encode(problem)
+	case r.Header.Get("HX-Request") == "true":
+		if errorCode >= 500 {
+			w.Header().Set("HX-Reswap", "none")
+			w.WriteHeader(errorCode)
+			return
+		}
+		w.Header().Set("HX-Retarget", "#page-content")
+		w.Header().Set("HX-Reswap", "innerHTML")
+		w.WriteHeader(errorCode)
+		page.ErrorContent(errorCode, title, message).Render(r.Context(), w)
+	default:
+		w.WriteHeader(errorCode)
+		page.Error(errorCode, title, message).Render(r.Context(), w)
+	}
+}
+
+// Check if the client prefers a JSON response over HTML, using the q-values
+// in the Accept header. Wildcards don't count as asking for JSON
+func wantsJSON(r *http.Request) bool {
+	jsonQ, htmlQ := 0.0, 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if val, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(val, 64)
+			if err != nil {
+				continue
+			}
+		}
+		switch mediaType {
+		case "application/json", "application/problem+json":
+			jsonQ = max(jsonQ, q)
+		case "text/html", "application/xhtml+xml":
+			htmlQ = max(htmlQ, q)
+		}
 	}
-	w.WriteHeader(http.StatusUnauthorized)
-	page.Error(errorCode, http.StatusText(errorCode), message[errorCode]).
-		Render(r.Context(), w)
+	return jsonQ > 0 && jsonQ > htmlQ
 }
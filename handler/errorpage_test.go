@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"projectreshoot/contexts"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrorPage(t *testing.T) {
+	serve := func(code int, header http.Header) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/missing", nil)
+		req = req.WithContext(contexts.SetRequestID(req.Context(), "req-1"))
+		for key, val := range header {
+			req.Header[key] = val
+		}
+		ErrorPage(code, rec, req)
+		return rec
+	}
+
+	t.Run("Status code is sent", func(t *testing.T) {
+		for _, code := range []int{401, 403, 404, 500, 503} {
+			rec := serve(code, nil)
+			assert.Equal(t, code, rec.Code)
+			assert.Contains(t, rec.Body.String(), http.StatusText(code))
+			assert.Contains(t, rec.Body.String(), "<html")
+		}
+	})
+	t.Run("JSON problem details", func(t *testing.T) {
+		rec := serve(http.StatusNotFound, http.Header{"Accept": {"application/json"}})
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		assert.Equal(t, "application/problem+json", rec.Header().Get("Content-Type"))
+		var problem problemDetails
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&problem))
+		assert.Equal(t, "about:blank", problem.Type)
+		assert.Equal(t, "Not Found", problem.Title)
+		assert.Equal(t, 404, problem.Status)
+		assert.Equal(t, "/missing", problem.Instance)
+		assert.Equal(t, "req-1", problem.RequestID)
+	})
+	t.Run("HTMX requests get a retargeted fragment", func(t *testing.T) {
+		rec := serve(http.StatusForbidden, http.Header{"Hx-Request": {"true"}})
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		assert.Equal(t, "#page-content", rec.Header().Get("HX-Retarget"))
+		assert.Contains(t, rec.Body.String(), "Forbidden")
+		assert.NotContains(t, rec.Body.String(), "<html")
+	})
+	t.Run("HTMX server errors aren't swapped", func(t *testing.T) {
+		rec := serve(http.StatusInternalServerError, http.Header{"Hx-Request": {"true"}})
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+		assert.Equal(t, "none", rec.Header().Get("HX-Reswap"))
+		assert.Empty(t, rec.Body.String())
+	})
+	t.Run("Retry-After is sent with 503", func(t *testing.T) {
+		assert.Equal(t, "30", serve(http.StatusServiceUnavailable, nil).Header().Get("Retry-After"))
+		assert.Empty(t, serve(http.StatusNotFound, nil).Header().Get("Retry-After"))
+	})
+}
+
+func TestWantsJSON(t *testing.T) {
+	tests := []struct {
+		accept   string
+		expected bool
+	}{
+		{"", false},
+		{"*/*", false},
+		{"application/json", true},
+		{"application/problem+json", true},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", false},
+		{"text/html;q=0.5, application/json", true},
+		{"application/json;q=0.5, text/html", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept", tt.accept)
+			assert.Equal(t, tt.expected, wantsJSON(req))
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"projectreshoot/config"
+	"projectreshoot/db"
+	"projectreshoot/jobs"
+)
+
+// Status of the server and database reported by the health endpoints
+type healthStatus struct {
+	Status         string        `json:"status"`
+	Maintenance    bool          `json:"maintenance"`
+	DBPaused       bool          `json:"db_paused"`
+	DBPingMS       float64       `json:"db_ping_ms"`
+	DBError        string        `json:"db_error,omitempty"`
+	DBVersion      int           `json:"db_version"`
+	DBVersionWant  int           `json:"db_version_expected"`
+	ReadLocks      uint32        `json:"read_locks"`
+	LockQueueDepth int32         `json:"lock_queue_depth"`
+	Jobs           []jobs.Status `json:"jobs,omitempty"`
+}
+
+// Check the database and maintenance state. Returns the status and true if
+// the server is ready to handle requests
+func checkHealth(
+	ctx context.Context,
+	config *config.Config,
+	conn *db.SafeConn,
+	maint *uint32,
+	sched *jobs.Scheduler,
+) (*healthStatus, bool) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	stats := conn.LockStats()
+	status := &healthStatus{
+		Maintenance:    atomic.LoadUint32(maint) == 1,
+		DBPaused:       stats.Paused || stats.PauseRequested,
+		ReadLocks:      stats.ReadLocks,
+		LockQueueDepth: stats.Waiting,
+		Jobs:           sched.Status(),
+	}
+	status.DBVersionWant, _ = strconv.Atoi(config.DBName)
+	ready := !status.Maintenance && !status.DBPaused
+
+	ping, err := conn.Ping(ctx)
+	status.DBPingMS = float64(ping.Microseconds()) / 1000
+	if err != nil {
+		status.DBError = err.Error()
+		ready = false
+	} else {
+		status.DBVersion, err = conn.Version(ctx)
+		if err != nil {
+			status.DBError = err.Error()
+			ready = false
+		} else if status.DBVersion != status.DBVersionWant {
+			ready = false
+		}
+	}
+
+	status.Status = "ok"
+	if !ready {
+		status.Status = "unavailable"
+	}
+	return status, ready
+}
+
+// Write the health status as JSON with the given status code
+func writeHealth(w http.ResponseWriter, status *healthStatus, code int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(status)
+}
+
+// Liveness check. Always returns 200 while the server is running, with the
+// health status and scheduled job status for information
+func Livez(
+	config *config.Config,
+	conn *db.SafeConn,
+	maint *uint32,
+	sched *jobs.Scheduler,
+) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			status, _ := checkHealth(r.Context(), config, conn, maint, sched)
+			status.Status = "ok"
+			writeHealth(w, status, http.StatusOK)
+		},
+	)
+}
+
+// Readiness check. Returns 503 while in maintenance mode, while the database
+// is paused, or if the database can't be reached or is the wrong version
+func Readyz(
+	config *config.Config,
+	conn *db.SafeConn,
+	maint *uint32,
+	sched *jobs.Scheduler,
+) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			status, ready := checkHealth(r.Context(), config, conn, maint, sched)
+			code := http.StatusOK
+			if !ready {
+				code = http.StatusServiceUnavailable
+			}
+			writeHealth(w, status, code)
+		},
+	)
+}
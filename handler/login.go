@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"projectreshoot/config"
+	"projectreshoot/contexts"
 	"projectreshoot/cookies"
 	"projectreshoot/db"
 	"projectreshoot/view/component/form"
@@ -15,6 +16,12 @@ import (
 	"github.com/rs/zerolog"
 )
 
+// Messages shown on the login form when the login is refused
+const (
+	loginIncorrect = "Username or password incorrect"
+	loginLocked    = "Account is locked"
+)
+
 // Validates the username matches a user in the database and the password
 // is correct. Returns the corresponding user
 func validateLogin(
@@ -24,14 +31,18 @@ func validateLogin(
 ) (*db.User, error) {
 	formUsername := r.FormValue("username")
 	formPassword := r.FormValue("password")
-	user, err := db.GetUserFromUsername(ctx, tx, formUsername)
+	user, err := tx.Users().GetUserFromUsername(ctx, formUsername)
 	if err != nil {
-		return nil, errors.Wrap(err, "db.GetUserFromUsername")
+		return nil, errors.Wrap(err, "tx.Users().GetUserFromUsername")
 	}
 
-	err = user.CheckPassword(formPassword)
+	err = user.CheckPassword(ctx, formPassword)
 	if err != nil {
-		return nil, errors.New("Username or password incorrect")
+		return nil, errors.New(loginIncorrect)
+	}
+	// Checked after the password so it doesn't reveal the account exists
+	if user.Locked() {
+		return nil, errors.New(loginLocked)
 	}
 	return user, nil
 }
@@ -56,13 +67,14 @@ func LoginRequest(
 ) http.Handler {
 	return http.HandlerFunc(
 		func(w http.ResponseWriter, r *http.Request) {
+			log := contexts.GetLogger(r.Context(), logger)
 			ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 			defer cancel()
 
 			// Start the transaction
 			tx, err := conn.Begin(ctx)
 			if err != nil {
-				logger.Warn().Err(err).Msg("Failed to set token cookies")
+				log.Warn().Err(err).Msg("Failed to set token cookies")
 				w.WriteHeader(http.StatusServiceUnavailable)
 				return
 			}
@@ -70,8 +82,8 @@ func LoginRequest(
 			user, err := validateLogin(ctx, tx, r)
 			if err != nil {
 				tx.Rollback()
-				if err.Error() != "Username or password incorrect" {
-					logger.Warn().Caller().Err(err).Msg("Login request failed")
+				if msg := err.Error(); msg != loginIncorrect && msg != loginLocked {
+					log.Warn().Caller().Err(err).Msg("Login request failed")
 					w.WriteHeader(http.StatusInternalServerError)
 				} else {
 					form.LoginForm(err.Error()).Render(r.Context(), w)
@@ -84,11 +96,14 @@ func LoginRequest(
 			if err != nil {
 				tx.Rollback()
 				w.WriteHeader(http.StatusInternalServerError)
-				logger.Warn().Caller().Err(err).Msg("Failed to set token cookies")
+				log.Warn().Caller().Err(err).Msg("Failed to set token cookies")
 				return
 			}
 
-			tx.Commit()
+			RecordAudit(ctx, tx, r, log, user.ID, auditLogin, "")
+			if !commitTx(tx, w, log) {
+				return
+			}
 			pageFrom := cookies.CheckPageFrom(w, r)
 			w.Header().Set("HX-Redirect", pageFrom)
 		},
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"projectreshoot/config"
+	"projectreshoot/contexts"
 	"projectreshoot/cookies"
 	"projectreshoot/db"
 	"projectreshoot/jwt"
@@ -87,24 +88,30 @@ func Logout(
 ) http.Handler {
 	return http.HandlerFunc(
 		func(w http.ResponseWriter, r *http.Request) {
+			log := contexts.GetLogger(r.Context(), logger)
 			ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
 			defer cancel()
 
 			// Start the transaction
 			tx, err := conn.Begin(ctx)
 			if err != nil {
-				logger.Warn().Err(err).Msg("Error occured on user logout")
+				log.Warn().Err(err).Msg("Error occured on user logout")
 				w.WriteHeader(http.StatusServiceUnavailable)
 				return
 			}
 			err = revokeTokens(config, ctx, tx, r)
 			if err != nil {
 				tx.Rollback()
-				logger.Error().Err(err).Msg("Error occured on user logout")
+				log.Error().Err(err).Msg("Error occured on user logout")
 				w.WriteHeader(http.StatusInternalServerError)
 				return
 			}
-			tx.Commit()
+			if user := contexts.GetUser(r.Context()); user != nil {
+				RecordAudit(ctx, tx, r, log, user.ID, auditLogout, "")
+			}
+			if !commitTx(tx, w, log) {
+				return
+			}
 			cookies.DeleteCookie(w, "access", "/")
 			cookies.DeleteCookie(w, "refresh", "/")
 			w.Header().Set("HX-Redirect", "/login")
@@ -0,0 +1,45 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+
+	"projectreshoot/contexts"
+
+	"github.com/rs/zerolog"
+)
+
+// Starts and ends maintenance mode. Implemented by the server so the admin
+// endpoints do the same as the maintenance signals
+type MaintenanceControl interface {
+	StartMaintenance() // Blocks until the database is paused or the lock times out
+	EndMaintenance()
+}
+
+// Start maintenance mode if start is true, otherwise end it. Responds with
+// whether the server is in maintenance mode afterwards
+func Maintenance(
+	logger *zerolog.Logger,
+	ctl MaintenanceControl,
+	maint *uint32,
+	start bool,
+) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			reqLogger := contexts.GetLogger(r.Context(), logger)
+			if start {
+				reqLogger.Info().Msg("Maintenance started from the admin endpoint")
+				ctl.StartMaintenance()
+			} else {
+				reqLogger.Info().Msg("Maintenance ended from the admin endpoint")
+				ctl.EndMaintenance()
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Cache-Control", "no-store")
+			json.NewEncoder(w).Encode(struct {
+				Maintenance bool `json:"maintenance"`
+			}{atomic.LoadUint32(maint) == 1})
+		},
+	)
+}
@@ -91,7 +91,7 @@ func validatePassword(
 	r.ParseForm()
 	password := r.FormValue("password")
 	user := contexts.GetUser(r.Context())
-	err := user.CheckPassword(password)
+	err := user.CheckPassword(r.Context(), password)
 	if err != nil {
 		return errors.Wrap(err, "user.CheckPassword")
 	}
@@ -106,13 +106,14 @@ func Reauthenticate(
 ) http.Handler {
 	return http.HandlerFunc(
 		func(w http.ResponseWriter, r *http.Request) {
+			log := contexts.GetLogger(r.Context(), logger)
 			ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
 			defer cancel()
 
 			// Start the transaction
 			tx, err := conn.Begin(ctx)
 			if err != nil {
-				logger.Warn().Err(err).Msg("Failed to refresh user tokens")
+				log.Warn().Err(err).Msg("Failed to refresh user tokens")
 				w.WriteHeader(http.StatusServiceUnavailable)
 				return
 			}
@@ -126,7 +127,7 @@ func Reauthenticate(
 			err = refreshTokens(config, ctx, tx, w, r)
 			if err != nil {
 				tx.Rollback()
-				logger.Error().Err(err).Msg("Failed to refresh user tokens")
+				log.Error().Err(err).Msg("Failed to refresh user tokens")
 				w.WriteHeader(http.StatusInternalServerError)
 				return
 			}
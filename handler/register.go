@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"projectreshoot/config"
+	"projectreshoot/contexts"
 	"projectreshoot/cookies"
 	"projectreshoot/db"
 	"projectreshoot/view/component/form"
@@ -23,9 +24,9 @@ func validateRegistration(
 	formUsername := r.FormValue("username")
 	formPassword := r.FormValue("password")
 	formConfirmPassword := r.FormValue("confirm-password")
-	unique, err := db.CheckUsernameUnique(ctx, tx, formUsername)
+	unique, err := tx.Users().CheckUsernameUnique(ctx, formUsername)
 	if err != nil {
-		return nil, errors.Wrap(err, "db.CheckUsernameUnique")
+		return nil, errors.Wrap(err, "tx.Users().CheckUsernameUnique")
 	}
 	if !unique {
 		return nil, errors.New("Username is taken")
@@ -51,13 +52,14 @@ func RegisterRequest(
 ) http.Handler {
 	return http.HandlerFunc(
 		func(w http.ResponseWriter, r *http.Request) {
+			log := contexts.GetLogger(r.Context(), logger)
 			ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
 			defer cancel()
 
 			// Start the transaction
 			tx, err := conn.Begin(ctx)
 			if err != nil {
-				logger.Warn().Err(err).Msg("Failed to set token cookies")
+				log.Warn().Err(err).Msg("Failed to set token cookies")
 				w.WriteHeader(http.StatusServiceUnavailable)
 				return
 			}
@@ -68,7 +70,7 @@ func RegisterRequest(
 				if err.Error() != "Username is taken" &&
 					err.Error() != "Passwords do not match" &&
 					err.Error() != "Password exceeds maximum length of 72 bytes" {
-					logger.Warn().Caller().Err(err).Msg("Registration request failed")
+					log.Warn().Caller().Err(err).Msg("Registration request failed")
 					w.WriteHeader(http.StatusInternalServerError)
 				} else {
 					form.RegisterForm(err.Error()).Render(r.Context(), w)
@@ -81,10 +83,13 @@ func RegisterRequest(
 			if err != nil {
 				tx.Rollback()
 				w.WriteHeader(http.StatusInternalServerError)
-				logger.Warn().Caller().Err(err).Msg("Failed to set token cookies")
+				log.Warn().Caller().Err(err).Msg("Failed to set token cookies")
+				return
+			}
+			RecordAudit(ctx, tx, r, log, user.ID, auditRegister, "")
+			if !commitTx(tx, w, log) {
 				return
 			}
-			tx.Commit()
 			pageFrom := cookies.CheckPageFrom(w, r)
 			w.Header().Set("HX-Redirect", pageFrom)
 		},
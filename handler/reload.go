@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"projectreshoot/config"
+	"projectreshoot/contexts"
+
+	"github.com/rs/zerolog"
+)
+
+// Reloads the config. Implemented by the server so the admin endpoint does
+// the same as SIGHUP
+type ConfigReloader interface {
+	Reload() (*config.Reload, error)
+}
+
+// Actions that can be run from the admin endpoints
+type AdminControl interface {
+	MaintenanceControl
+	ConfigReloader
+}
+
+// Reload the config. Responds with the settings that were applied and the
+// settings that need a restart, or a 422 if the new config is invalid
+func ReloadConfig(logger *zerolog.Logger, reloader ConfigReloader) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			reqLogger := contexts.GetLogger(r.Context(), logger)
+			reqLogger.Info().Msg("Config reload requested from the admin endpoint")
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Cache-Control", "no-store")
+			result, err := reloader.Reload()
+			if err != nil {
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				json.NewEncoder(w).Encode(struct {
+					Error string `json:"error"`
+				}{err.Error()})
+				return
+			}
+			changed, rejected := []string{}, []string{}
+			changed = append(changed, result.Changed...)
+			rejected = append(rejected, result.Rejected...)
+			json.NewEncoder(w).Encode(struct {
+				Changed  []string `json:"changed"`
+				Rejected []string `json:"rejected"`
+			}{changed, rejected})
+		},
+	)
+}
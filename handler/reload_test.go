@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"projectreshoot/config"
+	"projectreshoot/tests"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeReloader struct {
+	result *config.Reload
+	err    error
+}
+
+func (f fakeReloader) Reload() (*config.Reload, error) {
+	return f.result, f.err
+}
+
+func TestReloadConfig(t *testing.T) {
+	post := func(reloader ConfigReloader) (*httptest.ResponseRecorder, map[string]any) {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/reload", nil)
+		ReloadConfig(tests.NilLogger(), reloader).ServeHTTP(rec, req)
+		body := map[string]any{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		return rec, body
+	}
+
+	t.Run("Applied and rejected settings are listed", func(t *testing.T) {
+		rec, body := post(fakeReloader{result: &config.Reload{
+			Changed:  []string{"LOG_LEVEL"},
+			Rejected: []string{"PORT"},
+		}})
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, []any{"LOG_LEVEL"}, body["changed"])
+		assert.Equal(t, []any{"PORT"}, body["rejected"])
+	})
+	t.Run("No changes are empty lists", func(t *testing.T) {
+		rec, body := post(fakeReloader{result: &config.Reload{}})
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, []any{}, body["changed"])
+		assert.Equal(t, []any{}, body["rejected"])
+	})
+	t.Run("Invalid configs are reported", func(t *testing.T) {
+		rec, body := post(fakeReloader{err: errors.New("PORT: not a number")})
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+		assert.Contains(t, body["error"], "PORT")
+	})
+}
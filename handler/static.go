@@ -1,53 +1,54 @@
 package handler
 
 import (
+	"mime"
 	"net/http"
-	"os"
-)
-
-// Wrapper for default FileSystem
-type justFilesFilesystem struct {
-	fs http.FileSystem
-}
-
-// Wrapper for default File
-type neuteredReaddirFile struct {
-	http.File
-}
-
-// Modifies the behavior of FileSystem.Open to return the neutered version of File
-func (fs justFilesFilesystem) Open(name string) (http.File, error) {
-	f, err := fs.fs.Open(name)
-	if err != nil {
-		return nil, err
-	}
+	"path"
 
-	// Check if the requested path is a directory
-	// and explicitly return an error to trigger a 404
-	fileInfo, err := f.Stat()
-	if err != nil {
-		return nil, err
-	}
-	if fileInfo.IsDir() {
-		return nil, os.ErrNotExist
-	}
-
-	return neuteredReaddirFile{f}, nil
-}
-
-// Overrides the Readdir method of File to always return nil
-func (f neuteredReaddirFile) Readdir(count int) ([]os.FileInfo, error) {
-	return nil, nil
-}
+	"projectreshoot/assets"
+)
 
-// Handles requests for static files, without allowing access to the
-// directory viewer and returning 404 if an exact file is not found
-func StaticFS(staticFS *http.FileSystem) http.Handler {
+// Handles requests for static files in the manifest, returning 404 if an
+// exact file is not found. Content hashed names are cached forever, other
+// names must be revalidated with the ETag. Precompressed variants are sent
+// to clients that accept them
+func StaticFS(manifest *assets.Manifest) http.Handler {
 	return http.HandlerFunc(
 		func(w http.ResponseWriter, r *http.Request) {
-			nfs := justFilesFilesystem{*staticFS}
-			fs := http.FileServer(nfs)
-			fs.ServeHTTP(w, r)
+			asset, immutable, ok := manifest.Lookup(r.URL.Path)
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			header := w.Header()
+			if immutable {
+				header.Set("Cache-Control", "public, max-age=31536000, immutable")
+			} else {
+				header.Set("Cache-Control", "no-cache")
+			}
+			if ctype := mime.TypeByExtension(path.Ext(asset.Name)); ctype != "" {
+				header.Set("Content-Type", ctype)
+			}
+
+			etag := asset.ETag
+			encoding := ""
+			if asset.HasVariants() {
+				header.Add("Vary", "Accept-Encoding")
+				encoding = asset.Encoding(r.Header.Get("Accept-Encoding"))
+			}
+			if encoding != "" {
+				header.Set("Content-Encoding", encoding)
+				// Each representation needs its own ETag
+				etag = etag[:len(etag)-1] + "-" + encoding + `"`
+			}
+			header.Set("ETag", etag)
+
+			content, err := manifest.Open(asset, encoding)
+			if err != nil {
+				ErrorPage(http.StatusInternalServerError, w, r)
+				return
+			}
+			http.ServeContent(w, r, asset.Name, asset.ModTime, content)
 		},
 	)
 }
@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+
+	"projectreshoot/assets"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStaticFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"css/output.css":    {Data: []byte("body{}")},
+		"css/output.css.br": {Data: []byte("brotli")},
+		"favicon.ico":       {Data: []byte("icon")},
+	}
+	manifest, err := assets.NewManifest(fsys, false)
+	require.NoError(t, err)
+	server := httptest.NewServer(http.StripPrefix("/static/", StaticFS(manifest)))
+	defer server.Close()
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	hashed := manifest.URL("css/output.css")
+
+	get := func(t *testing.T, path string, header http.Header) (*http.Response, string) {
+		req, err := http.NewRequest(http.MethodGet, server.URL+path, nil)
+		require.NoError(t, err)
+		for key, val := range header {
+			req.Header[key] = val
+		}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, string(body)
+	}
+
+	t.Run("Hashed names are immutable", func(t *testing.T) {
+		resp, body := get(t, hashed, nil)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "public, max-age=31536000, immutable", resp.Header.Get("Cache-Control"))
+		assert.Equal(t, "text/css; charset=utf-8", resp.Header.Get("Content-Type"))
+		assert.Equal(t, "body{}", body)
+	})
+	t.Run("Plain names must be revalidated", func(t *testing.T) {
+		resp, _ := get(t, "/static/favicon.ico", nil)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "no-cache", resp.Header.Get("Cache-Control"))
+		etag := resp.Header.Get("ETag")
+		require.NotEmpty(t, etag)
+
+		resp, _ = get(t, "/static/favicon.ico", http.Header{"If-None-Match": {etag}})
+		assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+	})
+	t.Run("Precompressed variant is sent when accepted", func(t *testing.T) {
+		resp, body := get(t, hashed, http.Header{"Accept-Encoding": {"gzip, br"}})
+		assert.Equal(t, "br", resp.Header.Get("Content-Encoding"))
+		assert.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+		assert.Equal(t, "text/css; charset=utf-8", resp.Header.Get("Content-Type"))
+		assert.Contains(t, resp.Header.Get("ETag"), "-br")
+		assert.Equal(t, "brotli", body)
+	})
+	t.Run("Missing files and directories are not found", func(t *testing.T) {
+		resp, _ := get(t, "/static/missing.css", nil)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		resp, _ = get(t, "/static/css", nil)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
@@ -0,0 +1,141 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"projectreshoot/db"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// Removes revoked tokens from the blacklist once they have expired
+func TokenCleanup(schedule Schedule, logger *zerolog.Logger) Job {
+	return Job{
+		Name:     "token_cleanup",
+		Schedule: schedule,
+		Timeout:  time.Minute,
+		Run: func(ctx context.Context, conn *db.SafeConn) error {
+			tx, err := conn.Begin(ctx)
+			if err != nil {
+				return errors.Wrap(err, "conn.Begin")
+			}
+			count, err := tx.Tokens().DeleteExpiredTokens(ctx, time.Now().Unix())
+			if err != nil {
+				tx.Rollback()
+				return errors.Wrap(err, "tx.Tokens().DeleteExpiredTokens")
+			}
+			logger.Info().Int64("removed", count).Msg("Expired tokens removed")
+			return tx.Commit()
+		},
+	}
+}
+
+// Removes rate limit state that has expired
+func RateLimitPrune(schedule Schedule, logger *zerolog.Logger) Job {
+	return Job{
+		Name:     "rate_limit_prune",
+		Schedule: schedule,
+		Timeout:  time.Minute,
+		Run: func(ctx context.Context, conn *db.SafeConn) error {
+			tx, err := conn.Begin(ctx)
+			if err != nil {
+				return errors.Wrap(err, "conn.Begin")
+			}
+			count, err := tx.RateLimits().DeleteExpired(ctx, time.Now().Unix())
+			if err != nil {
+				tx.Rollback()
+				return errors.Wrap(err, "tx.RateLimits().DeleteExpired")
+			}
+			logger.Debug().Int64("removed", count).Msg("Expired rate limit state removed")
+			return tx.Commit()
+		},
+	}
+}
+
+// Removes audit log events older than the retention period
+func AuditRetention(
+	schedule Schedule,
+	retention time.Duration,
+	logger *zerolog.Logger,
+) Job {
+	return Job{
+		Name:     "audit_retention",
+		Schedule: schedule,
+		Timeout:  5 * time.Minute,
+		Run: func(ctx context.Context, conn *db.SafeConn) error {
+			tx, err := conn.Begin(ctx)
+			if err != nil {
+				return errors.Wrap(err, "conn.Begin")
+			}
+			before := time.Now().Add(-retention).Unix()
+			count, err := tx.Audit().DeleteBefore(ctx, before)
+			if err != nil {
+				tx.Rollback()
+				return errors.Wrap(err, "tx.Audit().DeleteBefore")
+			}
+			logger.Info().Int64("removed", count).Msg("Old audit log events removed")
+			return tx.Commit()
+		},
+	}
+}
+
+// Writes a copy of the SQLite database to dir as
+// "<dbName>-auto-<YYYY-MM-DD-HHMM>.db", keeping only the newest keep copies.
+// Backups made by deploy/db/backup.sh are left alone
+func Backup(
+	schedule Schedule,
+	dir string,
+	dbName string,
+	keep int,
+	logger *zerolog.Logger,
+) Job {
+	return Job{
+		Name:     "backup",
+		Schedule: schedule,
+		Timeout:  30 * time.Minute,
+		Run: func(ctx context.Context, conn *db.SafeConn) error {
+			err := os.MkdirAll(dir, 0755)
+			if err != nil {
+				return errors.Wrap(err, "os.MkdirAll")
+			}
+			name := fmt.Sprintf("%s-auto-%s.db", dbName, time.Now().Format("2006-01-02-1504"))
+			path := filepath.Join(dir, name)
+			err = conn.Backup(ctx, path)
+			if err != nil {
+				return errors.Wrap(err, "conn.Backup")
+			}
+			logger.Info().Str("path", path).Msg("Database backup created")
+			err = pruneBackups(dir, dbName, keep)
+			if err != nil {
+				return errors.Wrap(err, "pruneBackups")
+			}
+			return nil
+		},
+	}
+}
+
+// Remove all but the newest keep automatic backups
+func pruneBackups(dir string, dbName string, keep int) error {
+	backups, err := filepath.Glob(filepath.Join(dir, dbName+"-auto-*.db"))
+	if err != nil {
+		return errors.Wrap(err, "filepath.Glob")
+	}
+	if keep < 1 || len(backups) <= keep {
+		return nil
+	}
+	// Timestamps in the names sort in the order they were created
+	sort.Strings(backups)
+	for _, path := range backups[:len(backups)-keep] {
+		err = os.Remove(path)
+		if err != nil {
+			return errors.Wrap(err, "os.Remove")
+		}
+	}
+	return nil
+}
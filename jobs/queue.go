@@ -0,0 +1,258 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"projectreshoot/db"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// Handles a job claimed from the queue. Returning an error fails the attempt
+// and the job is retried with backoff until it runs out of attempts
+type Handler func(ctx context.Context, conn *db.SafeConn, job *db.QueuedJob) error
+
+// Options for the queue worker pool
+type WorkerOptions struct {
+	Workers           int           // Number of jobs to run at once
+	PollInterval      time.Duration // Time to wait when the queue is empty
+	VisibilityTimeout time.Duration // Time a job can run before another worker can claim it
+	MaxAttempts       int           // Attempts for jobs enqueued without a max
+	Backoff           time.Duration // Delay before the first retry, doubled for each retry
+	MaxBackoff        time.Duration // Max delay between retries
+}
+
+// Pool of workers that run jobs from the durable queue. Workers stop
+// claiming jobs while the server is in maintenance mode
+type Workers struct {
+	conn     *db.SafeConn
+	logger   *zerolog.Logger
+	maint    *uint32
+	name     string
+	opts     WorkerOptions
+	handlers map[string]Handler
+	wg       sync.WaitGroup
+}
+
+// Create a new worker pool. name identifies this instance in job claims and
+// must be unique between instances sharing the database
+func NewWorkers(
+	conn *db.SafeConn,
+	logger *zerolog.Logger,
+	maint *uint32,
+	name string,
+	opts WorkerOptions,
+) *Workers {
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = time.Hour
+	}
+	return &Workers{
+		conn:     conn,
+		logger:   logger,
+		maint:    maint,
+		name:     name,
+		opts:     opts,
+		handlers: map[string]Handler{},
+	}
+}
+
+// Set the handler for jobs of the given kind. Must be called before Start
+func (w *Workers) Handle(kind string, handler Handler) {
+	w.handlers[kind] = handler
+}
+
+// Check if any handlers have been registered
+func (w *Workers) HasHandlers() bool {
+	return len(w.handlers) > 0
+}
+
+// Start the workers. Workers stop claiming jobs when the context is
+// cancelled, use Wait to wait for running jobs to finish
+func (w *Workers) Start(ctx context.Context) {
+	if len(w.handlers) == 0 {
+		w.logger.Debug().Msg("No queue handlers registered, workers not started")
+		return
+	}
+	for i := range w.opts.Workers {
+		w.wg.Add(1)
+		go func() {
+			defer w.wg.Done()
+			w.work(ctx, fmt.Sprintf("%s/%d", w.name, i))
+		}()
+	}
+	w.logger.Debug().Int("workers", w.opts.Workers).Msg("Queue workers started")
+}
+
+// Wait for all the workers to exit
+func (w *Workers) Wait() {
+	w.wg.Wait()
+}
+
+// Add a job to the queue inside the transaction. The payload is encoded as
+// JSON and the job uses the worker pool's default max attempts
+func Enqueue(
+	ctx context.Context,
+	tx *db.SafeTX,
+	kind string,
+	payload interface{},
+) (int64, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return 0, errors.Wrap(err, "json.Marshal")
+	}
+	id, err := tx.Queue().Enqueue(ctx, kind, data, time.Now().Unix(), 0)
+	if err != nil {
+		return 0, errors.Wrap(err, "tx.Queue().Enqueue")
+	}
+	return id, nil
+}
+
+// Claim and run jobs until the context is cancelled
+func (w *Workers) work(ctx context.Context, worker string) {
+	for ctx.Err() == nil {
+		stats := w.conn.LockStats()
+		if atomic.LoadUint32(w.maint) == 1 || stats.Paused || stats.PauseRequested {
+			sleep(ctx, w.opts.PollInterval)
+			continue
+		}
+		job, err := w.claim(ctx, worker)
+		if err != nil {
+			if ctx.Err() == nil {
+				w.logger.Warn().Err(err).Str("worker", worker).Msg("Failed to claim job")
+			}
+			sleep(ctx, w.opts.PollInterval)
+			continue
+		}
+		if job == nil {
+			sleep(ctx, w.opts.PollInterval)
+			continue
+		}
+		w.process(ctx, worker, job)
+	}
+}
+
+// Claim the next job ready to run. Returns nil if the queue is empty
+func (w *Workers) claim(ctx context.Context, worker string) (*db.QueuedJob, error) {
+	tx, err := w.conn.Begin(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "conn.Begin")
+	}
+	now := time.Now()
+	job, err := tx.Queue().Claim(
+		ctx,
+		worker,
+		now.Unix(),
+		now.Add(w.opts.VisibilityTimeout).Unix(),
+	)
+	if err != nil {
+		tx.Rollback()
+		return nil, errors.Wrap(err, "tx.Queue().Claim")
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, errors.Wrap(err, "tx.Commit")
+	}
+	return job, nil
+}
+
+// Run the handler for the job and record the result
+func (w *Workers) process(ctx context.Context, worker string, job *db.QueuedJob) {
+	maxAttempts := job.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = w.opts.MaxAttempts
+	}
+	log := w.logger.With().Str("worker", worker).Int64("job_id", job.ID).
+		Str("kind", job.Kind).Int("attempt", job.Attempts).Logger()
+
+	var err error
+	if job.Attempts > maxAttempts {
+		// The claim on the last attempt expired without the job finishing
+		err = errors.New("Visibility timeout exceeded on final attempt")
+	} else if handler, ok := w.handlers[job.Kind]; !ok {
+		err = errors.Errorf("No handler for job kind %s", job.Kind)
+	} else {
+		err = w.run(ctx, handler, job)
+	}
+
+	// The run context may have expired, so finish with a fresh one
+	finishCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	tx, txErr := w.conn.Begin(finishCtx)
+	if txErr != nil {
+		log.Warn().Err(txErr).Msg("Failed to record job result")
+		return
+	}
+	dead := job.Attempts >= maxAttempts
+	if err == nil {
+		txErr = tx.Queue().Complete(finishCtx, job.ID, worker)
+	} else {
+		retryAt := time.Now().Add(w.backoff(job.Attempts)).Unix()
+		txErr = tx.Queue().Fail(finishCtx, job.ID, worker, err.Error(), retryAt, dead)
+	}
+	if txErr != nil {
+		tx.Rollback()
+		log.Warn().Err(txErr).Msg("Failed to record job result")
+		return
+	}
+	txErr = tx.Commit()
+	if txErr != nil {
+		log.Warn().Err(txErr).Msg("Failed to record job result")
+		return
+	}
+
+	switch {
+	case err == nil:
+		log.Debug().Msg("Job completed")
+	case dead:
+		log.Error().Err(err).Msg("Job failed on final attempt and is dead")
+	default:
+		log.Warn().Err(err).Msg("Job failed and will be retried")
+	}
+}
+
+// Run the handler with the visibility timeout, turning panics into errors
+func (w *Workers) run(
+	ctx context.Context,
+	handler Handler,
+	job *db.QueuedJob,
+) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, w.opts.VisibilityTimeout)
+	defer cancel()
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Errorf("Job panicked: %v", r)
+		}
+	}()
+	return handler(ctx, w.conn, job)
+}
+
+// Get the delay before retrying a job that has failed the given number of
+// attempts. Doubles each attempt up to MaxBackoff, with up to 25% jitter
+func (w *Workers) backoff(attempts int) time.Duration {
+	delay := w.opts.Backoff
+	for i := 1; i < attempts && delay < w.opts.MaxBackoff; i++ {
+		delay *= 2
+	}
+	delay = min(delay, w.opts.MaxBackoff)
+	if delay >= 4 {
+		delay += rand.N(delay / 4)
+	}
+	return delay
+}
+
+// Wait for the duration or until the context is cancelled
+func sleep(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
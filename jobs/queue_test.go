@@ -0,0 +1,128 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"projectreshoot/db"
+	"projectreshoot/tests"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorkers(t *testing.T) {
+	cfg, err := tests.TestConfig()
+	require.NoError(t, err)
+	logger := tests.NilLogger()
+	ver, err := strconv.ParseInt(cfg.DBName, 10, 0)
+	require.NoError(t, err)
+	testconn, err := tests.SetupTestDB(ver)
+	require.NoError(t, err)
+	conn := db.MakeSafe(testconn, logger)
+	defer conn.Close()
+
+	var maint uint32
+	workers := NewWorkers(conn, logger, &maint, "test", WorkerOptions{
+		Workers:           1,
+		PollInterval:      10 * time.Millisecond,
+		VisibilityTimeout: time.Second,
+		MaxAttempts:       2,
+		Backoff:           0,
+	})
+	var ran, failed int32
+	workers.Handle("ok", func(ctx context.Context, conn *db.SafeConn, job *db.QueuedJob) error {
+		atomic.AddInt32(&ran, 1)
+		return nil
+	})
+	workers.Handle("fail", func(ctx context.Context, conn *db.SafeConn, job *db.QueuedJob) error {
+		atomic.AddInt32(&failed, 1)
+		return errors.New("broken")
+	})
+	workers.Handle("panic", func(ctx context.Context, conn *db.SafeConn, job *db.QueuedJob) error {
+		panic("oops")
+	})
+
+	// Process everything in the queue with a single worker
+	drain := func(t *testing.T) {
+		for {
+			job, err := workers.claim(t.Context(), "test/0")
+			require.NoError(t, err)
+			if job == nil {
+				return
+			}
+			workers.process(t.Context(), "test/0", job)
+		}
+	}
+	enqueue := func(t *testing.T, kind string) int64 {
+		tx, err := conn.Begin(t.Context())
+		require.NoError(t, err)
+		id, err := Enqueue(t.Context(), tx, kind, map[string]int{"user": 1})
+		require.NoError(t, err)
+		require.NoError(t, tx.Commit())
+		return id
+	}
+	list := func(t *testing.T, status string) []db.QueuedJob {
+		tx, err := conn.BeginRead(t.Context())
+		require.NoError(t, err)
+		defer tx.Rollback()
+		jobs, err := tx.Queue().List(t.Context(), status, 10)
+		require.NoError(t, err)
+		return jobs
+	}
+
+	t.Run("Completed jobs are removed", func(t *testing.T) {
+		enqueue(t, "ok")
+		drain(t)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&ran))
+		assert.Empty(t, list(t, db.QueuePending))
+	})
+	t.Run("Failed jobs are retried then dead", func(t *testing.T) {
+		id := enqueue(t, "fail")
+		drain(t)
+		assert.Equal(t, int32(2), atomic.LoadInt32(&failed))
+		dead := list(t, db.QueueDead)
+		require.Len(t, dead, 1)
+		assert.Equal(t, id, dead[0].ID)
+		assert.Equal(t, "broken", dead[0].LastError)
+	})
+	t.Run("Panics and unknown kinds fail the job", func(t *testing.T) {
+		enqueue(t, "panic")
+		enqueue(t, "unknown")
+		drain(t)
+		dead := list(t, db.QueueDead)
+		require.Len(t, dead, 3)
+		assert.Contains(t, dead[1].LastError, "panicked")
+		assert.Contains(t, dead[2].LastError, "No handler")
+	})
+	t.Run("Workers don't claim jobs during maintenance", func(t *testing.T) {
+		atomic.StoreUint32(&maint, 1)
+		ctx, cancel := context.WithCancel(t.Context())
+		workers.Start(ctx)
+		enqueue(t, "ok")
+		time.Sleep(50 * time.Millisecond)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&ran))
+
+		atomic.StoreUint32(&maint, 0)
+		assert.Eventually(t, func() bool {
+			return atomic.LoadInt32(&ran) == 2
+		}, time.Second, 10*time.Millisecond)
+		cancel()
+		workers.Wait()
+	})
+	t.Run("Backoff doubles up to the max", func(t *testing.T) {
+		w := NewWorkers(conn, logger, &maint, "test", WorkerOptions{
+			Backoff:    time.Second,
+			MaxBackoff: 4 * time.Second,
+		})
+		assert.GreaterOrEqual(t, w.backoff(1), time.Second)
+		assert.Less(t, w.backoff(1), 2*time.Second)
+		assert.GreaterOrEqual(t, w.backoff(2), 2*time.Second)
+		assert.GreaterOrEqual(t, w.backoff(10), 4*time.Second)
+		assert.Less(t, w.backoff(10), 5*time.Second)
+	})
+}
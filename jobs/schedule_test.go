@@ -0,0 +1,49 @@
+package jobs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSchedule(t *testing.T) {
+	start := time.Date(2025, time.March, 14, 10, 7, 30, 0, time.UTC)
+	tests := []struct {
+		name string
+		spec string
+		next time.Time
+	}{
+		{"Every minute", "* * * * *", time.Date(2025, time.March, 14, 10, 8, 0, 0, time.UTC)},
+		{"Step", "*/15 * * * *", time.Date(2025, time.March, 14, 10, 15, 0, 0, time.UTC)},
+		{"Daily", "30 3 * * *", time.Date(2025, time.March, 15, 3, 30, 0, 0, time.UTC)},
+		{"List and range", "0 9-11,14 * * *", time.Date(2025, time.March, 14, 11, 0, 0, 0, time.UTC)},
+		{"Day of week", "0 0 * * 1", time.Date(2025, time.March, 17, 0, 0, 0, 0, time.UTC)},
+		{"Day of month or week", "0 0 1 * 1", time.Date(2025, time.March, 17, 0, 0, 0, 0, time.UTC)},
+		{"Month", "0 0 1 6 *", time.Date(2025, time.June, 1, 0, 0, 0, 0, time.UTC)},
+		{"Hourly", "@hourly", time.Date(2025, time.March, 14, 11, 0, 0, 0, time.UTC)},
+		{"Every", "@every 10m", time.Date(2025, time.March, 14, 10, 10, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := ParseSchedule(tt.spec)
+			require.NoError(t, err)
+			assert.Equal(t, tt.next, schedule.Next(start))
+		})
+	}
+
+	invalid := []string{"", "* * * *", "60 * * * *", "* 24 * * *", "*/0 * * * *", "5-1 * * * *", "@every 1ms", "@every soon"}
+	for _, spec := range invalid {
+		t.Run("Invalid "+spec, func(t *testing.T) {
+			_, err := ParseSchedule(spec)
+			assert.Error(t, err)
+		})
+	}
+
+	t.Run("Impossible schedule has no next run", func(t *testing.T) {
+		schedule, err := ParseSchedule("0 0 30 2 *")
+		require.NoError(t, err)
+		assert.True(t, schedule.Next(start).IsZero())
+	})
+}
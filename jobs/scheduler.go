@@ -0,0 +1,280 @@
+package jobs
+
+import (
+	"context"
+	"math/rand/v2"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"projectreshoot/db"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// A job to run on a schedule
+type Job struct {
+	Name     string        // Unique name of the job, used as the lease key
+	Schedule Schedule      // When the job runs
+	Jitter   time.Duration // Max random delay added to each run
+	Timeout  time.Duration // Max time a run can take before it is cancelled
+	Run      func(ctx context.Context, conn *db.SafeConn) error
+}
+
+// Results of a run
+const (
+	ResultOK          = "ok"
+	ResultFailed      = "failed"
+	ResultMaintenance = "skipped_maintenance"
+	ResultLeaseHeld   = "skipped_lease_held"
+)
+
+// Status of a job on this instance
+type Status struct {
+	Name         string    `json:"name"`
+	NextRun      time.Time `json:"next_run"`
+	LastRun      time.Time `json:"last_run"`
+	LastResult   string    `json:"last_result"`
+	LastError    string    `json:"last_error,omitempty"`
+	LastDuration float64   `json:"last_duration_ms"`
+	Runs         int       `json:"runs"`
+	Failures     int       `json:"failures"`
+}
+
+// Runs jobs on their schedules. Each run takes a lease in the database so
+// only one instance sharing the database runs it, and runs are skipped while
+// the server is in maintenance mode
+type Scheduler struct {
+	conn   *db.SafeConn
+	logger *zerolog.Logger
+	maint  *uint32
+	holder string
+	jobs   []Job
+	mu     sync.RWMutex
+	status map[string]*Status
+	wg     sync.WaitGroup
+}
+
+// Create a new scheduler. holder identifies this instance in the job leases
+// and must be unique between instances sharing the database
+func NewScheduler(
+	conn *db.SafeConn,
+	logger *zerolog.Logger,
+	maint *uint32,
+	holder string,
+) *Scheduler {
+	return &Scheduler{
+		conn:   conn,
+		logger: logger,
+		maint:  maint,
+		holder: holder,
+		status: map[string]*Status{},
+	}
+}
+
+// Add a job to the scheduler. Must be called before Start
+func (s *Scheduler) Add(job Job) error {
+	if job.Name == "" || job.Schedule == nil || job.Run == nil {
+		return errors.New("Job must have a name, schedule and run function")
+	}
+	if _, exists := s.status[job.Name]; exists {
+		return errors.Errorf("Job already added: %s", job.Name)
+	}
+	if job.Timeout <= 0 {
+		return errors.Errorf("Job %s must have a timeout", job.Name)
+	}
+	s.jobs = append(s.jobs, job)
+	s.status[job.Name] = &Status{Name: job.Name}
+	return nil
+}
+
+// Start running the jobs. Jobs stop being scheduled when the context is
+// cancelled, use Wait to wait for running jobs to finish
+func (s *Scheduler) Start(ctx context.Context) {
+	for _, job := range s.jobs {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.loop(ctx, job)
+		}()
+	}
+	s.logger.Debug().Int("jobs", len(s.jobs)).Msg("Job scheduler started")
+}
+
+// Wait for all the job loops to exit
+func (s *Scheduler) Wait() {
+	s.wg.Wait()
+}
+
+// Get the status of all the jobs, sorted by name. Safe to call on a nil
+// Scheduler
+func (s *Scheduler) Status() []Status {
+	if s == nil {
+		return nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	statuses := make([]Status, 0, len(s.status))
+	for _, status := range s.status {
+		statuses = append(statuses, *status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
+
+// Wait for each scheduled run of the job until the context is cancelled
+func (s *Scheduler) loop(ctx context.Context, job Job) {
+	for {
+		runAt := job.Schedule.Next(time.Now())
+		if runAt.IsZero() {
+			s.logger.Warn().Str("job", job.Name).Msg("Job has no future runs")
+			return
+		}
+		s.mu.Lock()
+		s.status[job.Name].NextRun = runAt
+		s.mu.Unlock()
+
+		wait := time.Until(runAt)
+		if job.Jitter > 0 {
+			wait += rand.N(job.Jitter)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		s.run(ctx, job, runAt)
+	}
+}
+
+// Run the job for the given scheduled time if the lease can be taken
+func (s *Scheduler) run(ctx context.Context, job Job, runAt time.Time) {
+	stats := s.conn.LockStats()
+	if atomic.LoadUint32(s.maint) == 1 || stats.Paused || stats.PauseRequested {
+		s.logger.Info().Str("job", job.Name).Msg("Skipping job during maintenance")
+		s.record(job.Name, ResultMaintenance, nil, 0)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, job.Timeout)
+	defer cancel()
+	acquired, err := s.acquireLease(ctx, job, runAt)
+	if err != nil {
+		s.logger.Warn().Err(err).Str("job", job.Name).Msg("Failed to acquire job lease")
+		s.record(job.Name, ResultFailed, err, 0)
+		return
+	}
+	if !acquired {
+		s.logger.Debug().Str("job", job.Name).Msg("Job lease held by another instance")
+		s.record(job.Name, ResultLeaseHeld, nil, 0)
+		return
+	}
+
+	s.logger.Debug().Str("job", job.Name).Msg("Running job")
+	start := time.Now()
+	err = job.Run(ctx, s.conn)
+	duration := time.Since(start)
+	result := ResultOK
+	if err != nil {
+		result = ResultFailed
+		s.logger.Error().Err(err).Str("job", job.Name).Msg("Job failed")
+	} else {
+		s.logger.Info().Str("job", job.Name).Dur("duration", duration).Msg("Job finished")
+	}
+	s.record(job.Name, result, err, duration)
+
+	// The run context may have expired, so release with a fresh one
+	releaseCtx, releaseCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer releaseCancel()
+	err = s.releaseLease(releaseCtx, job, result, err)
+	if err != nil {
+		s.logger.Warn().Err(err).Str("job", job.Name).Msg("Failed to release job lease")
+	}
+}
+
+// Take the lease for the scheduled run. The lease expires after the job
+// timeout so a crashed instance can't hold it forever
+func (s *Scheduler) acquireLease(
+	ctx context.Context,
+	job Job,
+	runAt time.Time,
+) (bool, error) {
+	tx, err := s.conn.Begin(ctx)
+	if err != nil {
+		return false, errors.Wrap(err, "conn.Begin")
+	}
+	now := time.Now()
+	acquired, err := tx.Jobs().AcquireLease(
+		ctx,
+		job.Name,
+		s.holder,
+		runAt.Unix(),
+		now.Add(job.Timeout).Unix(),
+		now.Unix(),
+	)
+	if err != nil {
+		tx.Rollback()
+		return false, errors.Wrap(err, "tx.Jobs().AcquireLease")
+	}
+	err = tx.Commit()
+	if err != nil {
+		return false, errors.Wrap(err, "tx.Commit")
+	}
+	return acquired, nil
+}
+
+// Release the lease, recording the result of the run
+func (s *Scheduler) releaseLease(
+	ctx context.Context,
+	job Job,
+	result string,
+	runErr error,
+) error {
+	errMsg := ""
+	if runErr != nil {
+		errMsg = runErr.Error()
+	}
+	tx, err := s.conn.Begin(ctx)
+	if err != nil {
+		return errors.Wrap(err, "conn.Begin")
+	}
+	err = tx.Jobs().ReleaseLease(
+		ctx,
+		job.Name,
+		s.holder,
+		time.Now().Unix(),
+		result,
+		errMsg,
+	)
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "tx.Jobs().ReleaseLease")
+	}
+	return tx.Commit()
+}
+
+// Record the result of a run in the job status
+func (s *Scheduler) record(name string, result string, err error, duration time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := s.status[name]
+	status.LastRun = time.Now()
+	status.LastResult = result
+	status.LastError = ""
+	status.LastDuration = float64(duration.Microseconds()) / 1000
+	if err != nil {
+		status.LastError = err.Error()
+	}
+	if result == ResultOK || result == ResultFailed {
+		status.Runs++
+	}
+	if result == ResultFailed {
+		status.Failures++
+	}
+}
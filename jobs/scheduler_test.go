@@ -0,0 +1,82 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"projectreshoot/db"
+	"projectreshoot/tests"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScheduler(t *testing.T) {
+	cfg, err := tests.TestConfig()
+	require.NoError(t, err)
+	logger := tests.NilLogger()
+	ver, err := strconv.ParseInt(cfg.DBName, 10, 0)
+	require.NoError(t, err)
+	testconn, err := tests.SetupTestDB(ver)
+	require.NoError(t, err)
+	conn := db.MakeSafe(testconn, logger)
+	defer conn.Close()
+
+	var maint uint32
+	first := NewScheduler(conn, logger, &maint, "first")
+	second := NewScheduler(conn, logger, &maint, "second")
+	var runs int32
+	job := Job{
+		Name:     "count",
+		Schedule: everySchedule{interval: time.Hour},
+		Timeout:  time.Second,
+		Run: func(ctx context.Context, conn *db.SafeConn) error {
+			atomic.AddInt32(&runs, 1)
+			return nil
+		},
+	}
+	require.NoError(t, first.Add(job))
+	require.NoError(t, second.Add(job))
+
+	t.Run("Duplicate jobs are rejected", func(t *testing.T) {
+		assert.Error(t, first.Add(job))
+	})
+	t.Run("Only one instance runs each scheduled run", func(t *testing.T) {
+		runAt := time.Now().Truncate(time.Second)
+		first.run(t.Context(), job, runAt)
+		second.run(t.Context(), job, runAt)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&runs))
+		assert.Equal(t, ResultOK, first.Status()[0].LastResult)
+		assert.Equal(t, ResultLeaseHeld, second.Status()[0].LastResult)
+
+		second.run(t.Context(), job, runAt.Add(time.Second))
+		assert.Equal(t, int32(2), atomic.LoadInt32(&runs))
+		assert.Equal(t, ResultOK, second.Status()[0].LastResult)
+	})
+	t.Run("Runs are skipped during maintenance", func(t *testing.T) {
+		atomic.StoreUint32(&maint, 1)
+		defer atomic.StoreUint32(&maint, 0)
+		first.run(t.Context(), job, time.Now().Add(time.Minute))
+		assert.Equal(t, int32(2), atomic.LoadInt32(&runs))
+		assert.Equal(t, ResultMaintenance, first.Status()[0].LastResult)
+	})
+	t.Run("Failed runs are recorded", func(t *testing.T) {
+		failing := job
+		failing.Name = "failing"
+		failing.Run = func(ctx context.Context, conn *db.SafeConn) error {
+			return errors.New("broken")
+		}
+		require.NoError(t, first.Add(failing))
+		first.run(t.Context(), failing, time.Now())
+		statuses := first.Status()
+		require.Len(t, statuses, 2)
+		assert.Equal(t, "failing", statuses[1].Name)
+		assert.Equal(t, ResultFailed, statuses[1].LastResult)
+		assert.Equal(t, "broken", statuses[1].LastError)
+		assert.Equal(t, 1, statuses[1].Failures)
+	})
+}
@@ -7,6 +7,7 @@ import (
 
 	"projectreshoot/config"
 	"projectreshoot/db"
+	"projectreshoot/tracing"
 
 	"github.com/golang-jwt/jwt"
 	"github.com/google/uuid"
@@ -22,6 +23,8 @@ func ParseAccessToken(
 	tx *db.SafeTX,
 	tokenString string,
 ) (*AccessToken, error) {
+	ctx, span := tracing.Start(ctx, "jwt.ParseAccessToken")
+	defer span.End()
 	if tokenString == "" {
 		return nil, errors.New("Access token string not provided")
 	}
@@ -95,6 +98,8 @@ func ParseRefreshToken(
 	tx *db.SafeTX,
 	tokenString string,
 ) (*RefreshToken, error) {
+	ctx, span := tracing.Start(ctx, "jwt.ParseRefreshToken")
+	defer span.End()
 	if tokenString == "" {
 		return nil, errors.New("Refresh token string not provided")
 	}
@@ -266,3 +271,41 @@ func getTokenJTI(jti interface{}) (uuid.UUID, error) {
 	}
 	return jtiUUID, nil
 }
+
+// Parse an access or refresh token, chosen by its scope claim. Does the
+// same validation as ParseAccessToken and ParseRefreshToken
+func ParseToken(
+	config *config.Config,
+	ctx context.Context,
+	tx *db.SafeTX,
+	tokenString string,
+) (Token, error) {
+	if tokenString == "" {
+		return nil, errors.New("Token string not provided")
+	}
+	claims, err := parseToken(config.SecretKey, tokenString)
+	if err != nil {
+		return nil, errors.Wrap(err, "parseToken")
+	}
+	scope, err := getTokenScope(claims["scope"])
+	if err != nil {
+		return nil, errors.Wrap(err, "getTokenScope")
+	}
+	// Returned separately so a failed parse gives a nil Token
+	switch scope {
+	case "access":
+		token, err := ParseAccessToken(config, ctx, tx, tokenString)
+		if err != nil {
+			return nil, err
+		}
+		return token, nil
+	case "refresh":
+		token, err := ParseRefreshToken(config, ctx, tx, tokenString)
+		if err != nil {
+			return nil, err
+		}
+		return token, nil
+	default:
+		return nil, errors.Errorf("Unknown token scope: %s", scope)
+	}
+}
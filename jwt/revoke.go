@@ -3,31 +3,26 @@ package jwt
 import (
 	"context"
 	"projectreshoot/db"
+	"projectreshoot/metrics"
 
 	"github.com/pkg/errors"
 )
 
 // Revoke a token by adding it to the database
 func RevokeToken(ctx context.Context, tx *db.SafeTX, t Token) error {
-	jti := t.GetJTI()
-	exp := t.GetEXP()
-	query := `INSERT INTO jwtblacklist (jti, exp) VALUES (?, ?)`
-	_, err := tx.Exec(ctx, query, jti, exp)
+	err := tx.Tokens().RevokeToken(ctx, t.GetJTI(), t.GetEXP())
 	if err != nil {
-		return errors.Wrap(err, "tx.Exec")
+		return errors.Wrap(err, "tx.Tokens().RevokeToken")
 	}
+	metrics.TokenRevocations.WithLabelValues(t.GetScope()).Inc()
 	return nil
 }
 
 // Check if a token has been revoked. Returns true if not revoked.
 func CheckTokenNotRevoked(ctx context.Context, tx *db.SafeTX, t Token) (bool, error) {
-	jti := t.GetJTI()
-	query := `SELECT 1 FROM jwtblacklist WHERE jti = ? LIMIT 1`
-	rows, err := tx.Query(ctx, query, jti)
+	valid, err := tx.Tokens().CheckTokenNotRevoked(ctx, t.GetJTI())
 	if err != nil {
-		return false, errors.Wrap(err, "tx.Query")
+		return false, errors.Wrap(err, "tx.Tokens().CheckTokenNotRevoked")
 	}
-	defer rows.Close()
-	revoked := rows.Next()
-	return !revoked, nil
+	return valid, nil
 }
@@ -39,16 +39,24 @@ type RefreshToken struct {
 }
 
 func (a AccessToken) GetUser(ctx context.Context, tx *db.SafeTX) (*db.User, error) {
-	user, err := db.GetUserFromID(ctx, tx, a.SUB)
+	user, err := tx.Users().GetUserFromID(ctx, a.SUB)
 	if err != nil {
-		return nil, errors.Wrap(err, "db.GetUserFromID")
+		return nil, errors.Wrap(err, "tx.Users().GetUserFromID")
+	}
+	err = user.CheckTokenAllowed(a.IAT)
+	if err != nil {
+		return nil, errors.Wrap(err, "user.CheckTokenAllowed")
 	}
 	return user, nil
 }
 func (r RefreshToken) GetUser(ctx context.Context, tx *db.SafeTX) (*db.User, error) {
-	user, err := db.GetUserFromID(ctx, tx, r.SUB)
+	user, err := tx.Users().GetUserFromID(ctx, r.SUB)
+	if err != nil {
+		return nil, errors.Wrap(err, "tx.Users().GetUserFromID")
+	}
+	err = user.CheckTokenAllowed(r.IAT)
 	if err != nil {
-		return nil, errors.Wrap(err, "db.GetUserFromID")
+		return nil, errors.Wrap(err, "user.CheckTokenAllowed")
 	}
 	return user, nil
 }
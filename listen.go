@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"projectreshoot/config"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// First file descriptor passed with socket activation, after stdin, stdout
+// and stderr
+const listenFDsStart = 3
+
+// Prefix of listen addresses that are Unix socket paths
+const unixPrefix = config.UnixPrefix
+
+// Name of a passed socket to use for the admin listener, set with
+// FileDescriptorName in the systemd socket unit
+const adminFDName = "admin"
+
+// A listener passed to the process, with its name from LISTEN_FDNAMES
+type inheritedListener struct {
+	net.Listener
+	name string
+}
+
+// Get the listeners passed to the process with the systemd socket activation
+// protocol, either by systemd or a parent process handing over its sockets.
+// LISTEN_PID is checked when set so listeners meant for the parent aren't
+// taken. The envars are cleared so child processes don't inherit them.
+// Returns nil if no listeners were passed
+func inheritedListeners() ([]inheritedListener, error) {
+	fds := os.Getenv("LISTEN_FDS")
+	pid := os.Getenv("LISTEN_PID")
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if fds == "" {
+		return nil, nil
+	}
+	if pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(fds)
+	if err != nil || count < 1 {
+		return nil, errors.Errorf("LISTEN_FDS: invalid count %q", fds)
+	}
+	listeners := make([]inheritedListener, 0, count)
+	for i := range count {
+		fd := listenFDsStart + i
+		file := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		// FileListener dups the descriptor, so the original is closed either way
+		ln, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			for _, ln := range listeners {
+				ln.Close()
+			}
+			return nil, errors.Wrap(err, "net.FileListener")
+		}
+		name := ""
+		if i < len(names) {
+			name = names[i]
+		}
+		listeners = append(listeners, inheritedListener{Listener: ln, name: name})
+	}
+	return listeners, nil
+}
+
+// Listen on an address from the config, either "host:port" or
+// "unix:<path>". Unix sockets are given the permissions and owner from the
+// config. A socket file left behind by a previous process is removed
+func listenAddr(config *config.Config, addr string) (net.Listener, error) {
+	path, isUnix := strings.CutPrefix(addr, unixPrefix)
+	if !isUnix {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, errors.Wrap(err, "net.Listen")
+		}
+		return ln, nil
+	}
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode().Type() != fs.ModeSocket {
+			return nil, errors.Errorf("%s exists and isn't a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, errors.Wrap(err, "os.Remove")
+		}
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, errors.Wrap(err, "net.Listen")
+	}
+	if err := os.Chmod(path, config.UnixSocketMode); err != nil {
+		ln.Close()
+		return nil, errors.Wrap(err, "os.Chmod")
+	}
+	if config.UnixSocketOwner != "" {
+		uid, gid, err := lookupOwner(config.UnixSocketOwner)
+		if err != nil {
+			ln.Close()
+			return nil, errors.Wrap(err, "lookupOwner")
+		}
+		if err := os.Lchown(path, uid, gid); err != nil {
+			ln.Close()
+			return nil, errors.Wrap(err, "os.Lchown")
+		}
+	}
+	return ln, nil
+}
+
+// Get the uid and gid for an owner in the form "user", "user:group" or
+// ":group". Names or numeric IDs can be used. Returns -1 for a part that
+// isn't set so it's left unchanged
+func lookupOwner(owner string) (int, int, error) {
+	userName, groupName, _ := strings.Cut(owner, ":")
+	uid, gid := -1, -1
+	if userName != "" {
+		u, err := user.Lookup(userName)
+		if err != nil {
+			u, err = user.LookupId(userName)
+		}
+		if err != nil {
+			return 0, 0, errors.Wrap(err, "user.Lookup")
+		}
+		uid, _ = strconv.Atoi(u.Uid)
+	}
+	if groupName != "" {
+		g, err := user.LookupGroup(groupName)
+		if err != nil {
+			g, err = user.LookupGroupId(groupName)
+		}
+		if err != nil {
+			return 0, 0, errors.Wrap(err, "user.LookupGroup")
+		}
+		gid, _ = strconv.Atoi(g.Gid)
+	}
+	return uid, gid, nil
+}
+
+// Listeners for the site and the admin endpoints
+type listeners struct {
+	public []net.Listener
+	admin  net.Listener // nil if there is no admin listener
+}
+
+// Close all the listeners
+func (l *listeners) Close() {
+	for _, ln := range l.public {
+		ln.Close()
+	}
+	if l.admin != nil {
+		l.admin.Close()
+	}
+}
+
+// Get the listeners for the server. Uses the sockets passed to the process
+// if there are any, so restarts don't refuse connections, otherwise listens
+// on the addresses in the config. A passed socket named "admin" is used for
+// the admin listener, the rest serve the site
+func listen(config *config.Config, logger *zerolog.Logger) (*listeners, error) {
+	inherited, err := inheritedListeners()
+	if err != nil {
+		return nil, errors.Wrap(err, "inheritedListeners")
+	}
+	result := &listeners{}
+	for _, ln := range inherited {
+		logger.Info().Str("address", ln.Addr().String()).Str("name", ln.name).
+			Msg("Using a socket passed to the process")
+		wrapped := &closeOnceListener{Listener: ln.Listener}
+		if ln.name == adminFDName && result.admin == nil {
+			result.admin = wrapped
+		} else {
+			result.public = append(result.public, wrapped)
+		}
+	}
+	if len(result.public) > 0 {
+		logger.Info().Msg("Serving on the passed sockets, LISTEN, HOST and PORT are ignored")
+	} else {
+		for _, addr := range config.ListenAddrs() {
+			ln, err := listenAddr(config, addr)
+			if err != nil {
+				result.Close()
+				return nil, errors.Wrap(err, addr)
+			}
+			result.public = append(result.public, &closeOnceListener{Listener: ln})
+		}
+	}
+	if result.admin == nil && config.AdminListen != "" {
+		ln, err := listenAddr(config, config.AdminListen)
+		if err != nil {
+			result.Close()
+			return nil, errors.Wrap(err, config.AdminListen)
+		}
+		result.admin = &closeOnceListener{Listener: ln}
+	}
+	return result, nil
+}
+
+// Listener that can be closed more than once, so the server can stop
+// accepting before it's shut down
+type closeOnceListener struct {
+	net.Listener
+	once sync.Once
+	err  error
+}
+
+func (l *closeOnceListener) Close() error {
+	l.once.Do(func() { l.err = l.Listener.Close() })
+	return l.err
+}
+
+// Tracks the connections that have been accepted but haven't sent a request
+// yet. The server drops requests read after shutdown starts, so these are
+// given the chance to send theirs first
+type newConns struct {
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+// Set as the server's ConnState hook
+func (n *newConns) track(conn net.Conn, state http.ConnState) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.conns == nil {
+		n.conns = make(map[net.Conn]struct{})
+	}
+	if state == http.StateNew {
+		n.conns[conn] = struct{}{}
+	} else {
+		delete(n.conns, conn)
+	}
+}
+
+// Wait until every new connection has sent a request or closed, or the
+// context is done
+func (n *newConns) wait(ctx context.Context) {
+	for {
+		n.mu.Lock()
+		count := len(n.conns)
+		n.mu.Unlock()
+		if count == 0 {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
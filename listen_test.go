@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"projectreshoot/config"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Runs the server when started by TestSocketHandoff
+func TestServeChild(t *testing.T) {
+	if os.Getenv("PROJECTRESHOOT_SERVE_CHILD") != "1" {
+		t.Skip("Only run by TestSocketHandoff")
+	}
+	err := run(context.Background(), nil, os.Stdout, os.Stderr, []string{"serve", "--test"})
+	require.NoError(t, err)
+}
+
+func TestSocketHandoff(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	file, err := ln.(*net.TCPListener).File()
+	require.NoError(t, err)
+	ln.Close()
+	defer file.Close()
+	url := "http://" + ln.Addr().String()
+
+	// Starts a server on the socket. The channel is closed once it's listening
+	start := func() (*exec.Cmd, chan struct{}) {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestServeChild$")
+		cmd.Env = append(os.Environ(),
+			"PROJECTRESHOOT_SERVE_CHILD=1",
+			"LISTEN_FDS=1",
+			"SECRET_KEY=.",
+			"LOG_LEVEL=info",
+			"LOG_OUTPUT=console",
+		)
+		cmd.ExtraFiles = []*os.File{file}
+		stdout, err := cmd.StdoutPipe()
+		require.NoError(t, err)
+		require.NoError(t, cmd.Start())
+		listening := make(chan struct{})
+		go func() {
+			scanner := bufio.NewScanner(stdout)
+			for scanner.Scan() {
+				if strings.Contains(scanner.Text(), "Listening for requests") {
+					close(listening)
+					break
+				}
+			}
+			io.Copy(io.Discard, stdout)
+		}()
+		return cmd, listening
+	}
+	waitListening := func(listening chan struct{}) {
+		select {
+		case <-listening:
+		case <-time.After(10 * time.Second):
+			t.Fatal("Server didn't start listening")
+		}
+	}
+
+	first, listening := start()
+	defer first.Process.Kill()
+	waitListening(listening)
+
+	// Requests sent while the servers are swapped must all succeed
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	var sent, failed atomic.Int64
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			sent.Add(1)
+			resp, err := client.Get(url + "/livez")
+			if err != nil {
+				failed.Add(1)
+				continue
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				failed.Add(1)
+			}
+		}
+	}()
+
+	second, listening := start()
+	defer second.Process.Kill()
+	waitListening(listening)
+	require.NoError(t, first.Process.Signal(syscall.SIGTERM))
+	require.NoError(t, first.Wait())
+	time.Sleep(100 * time.Millisecond)
+	close(stop)
+	<-done
+	assert.NotZero(t, sent.Load())
+	assert.Zero(t, failed.Load())
+
+	resp, err := client.Get(url + "/livez")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NoError(t, second.Process.Signal(syscall.SIGTERM))
+	require.NoError(t, second.Wait())
+}
+
+func TestListen(t *testing.T) {
+	logger := zerolog.Nop()
+	dir := t.TempDir()
+	socket := filepath.Join(dir, "site.sock")
+	cfg := &config.Config{
+		Listen:         []string{"127.0.0.1:0", "unix:" + socket},
+		AdminListen:    "unix:" + filepath.Join(dir, "admin.sock"),
+		UnixSocketMode: 0600,
+	}
+
+	lns, err := listen(cfg, &logger)
+	require.NoError(t, err)
+	require.Len(t, lns.public, 2)
+	require.NotNil(t, lns.admin)
+	assert.True(t, isTCP(lns.public[0]))
+	assert.False(t, isTCP(lns.public[1]))
+	info, err := os.Stat(socket)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})}
+	go srv.Serve(lns.public[1])
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+	}}
+	resp, err := client.Get("http://unix/")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	srv.Close()
+	lns.Close()
+	_, err = os.Stat(socket)
+	assert.ErrorIs(t, err, fs.ErrNotExist, "Socket file is removed when closed")
+
+	t.Run("Stale socket files are replaced", func(t *testing.T) {
+		stale, err := net.Listen("unix", socket)
+		require.NoError(t, err)
+		stale.(*net.UnixListener).SetUnlinkOnClose(false)
+		stale.Close()
+		ln, err := listenAddr(cfg, "unix:"+socket)
+		require.NoError(t, err)
+		ln.Close()
+	})
+
+	t.Run("Other files aren't replaced", func(t *testing.T) {
+		path := filepath.Join(dir, "file")
+		require.NoError(t, os.WriteFile(path, nil, 0600))
+		_, err := listenAddr(cfg, "unix:"+path)
+		assert.ErrorContains(t, err, "isn't a socket")
+	})
+}
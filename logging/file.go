@@ -0,0 +1,244 @@
+package logging
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Layout of the timestamp added to the names of rotated log files
+const rotatedTimeFormat = "20060102T150405.000"
+
+// Options for the log file
+type FileOptions struct {
+	Dir            string        // Directory to write the log file to
+	Name           string        // Name of the log file, i.e. server.log
+	MaxSize        int64         // Size in bytes to rotate the file at. 0 disables
+	RotateInterval time.Duration // Time to rotate the file after it's opened. 0 disables
+	MaxAge         time.Duration // Age to delete rotated files at. 0 keeps them
+	MaxBackups     int           // Number of rotated files to keep. 0 keeps them all
+	Compress       bool          // Gzip rotated files
+}
+
+// Log file that rotates itself by size and age, and can be reopened after
+// being moved by an external tool like logrotate
+type LogFile struct {
+	opts   FileOptions
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+	wg     sync.WaitGroup // Compression and cleanup running in the background
+	bgMu   sync.Mutex     // Runs the background work one rotation at a time
+}
+
+// Open the log file in append mode, creating it and the directory if needed.
+// Remember to call Close() when finished writing to the log file
+func OpenLogFile(opts FileOptions) (*LogFile, error) {
+	if opts.Dir == "" {
+		opts.Dir = "."
+	}
+	if err := os.MkdirAll(opts.Dir, 0750); err != nil {
+		return nil, errors.Wrap(err, "os.MkdirAll")
+	}
+	f := &LogFile{opts: opts}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Get the path of the log file
+func (f *LogFile) Path() string {
+	return filepath.Join(f.opts.Dir, f.opts.Name)
+}
+
+// Open the file at the path. Must hold the lock
+func (f *LogFile) open() error {
+	file, err := os.OpenFile(f.Path(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return errors.Wrap(err, "os.OpenFile")
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return errors.Wrap(err, "file.Stat")
+	}
+	f.file = file
+	f.size = info.Size()
+	f.opened = time.Now()
+	return nil
+}
+
+// Write to the log file, rotating it first if the write would take it past
+// the max size or it's due to be rotated
+func (f *LogFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	overSize := f.opts.MaxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.opts.MaxSize
+	overAge := f.opts.RotateInterval > 0 && time.Since(f.opened) >= f.opts.RotateInterval
+	if overSize || overAge {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Close the file and open it again at the same path. Used after the file was
+// moved by logrotate so writes go to a new file
+func (f *LogFile) Reopen() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file != nil {
+		f.file.Close()
+	}
+	return f.open()
+}
+
+// Rotate the log file now
+func (f *LogFile) Rotate() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rotate()
+}
+
+// Move the file aside with the time in its name and open a new file. The
+// moved file is compressed and old files removed in the background. Must
+// hold the lock
+func (f *LogFile) rotate() error {
+	if f.file != nil {
+		f.file.Close()
+		f.file = nil
+	}
+	ext := filepath.Ext(f.opts.Name)
+	base := strings.TrimSuffix(f.opts.Name, ext)
+	rotated := filepath.Join(f.opts.Dir,
+		base+"-"+time.Now().Format(rotatedTimeFormat)+ext)
+	if err := os.Rename(f.Path(), rotated); err != nil && !os.IsNotExist(err) {
+		// Keep logging to the current file rather than losing the logs
+		f.open()
+		return errors.Wrap(err, "os.Rename")
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		f.bgMu.Lock()
+		defer f.bgMu.Unlock()
+		if f.opts.Compress {
+			compressFile(rotated)
+		}
+		f.cleanup()
+	}()
+	return nil
+}
+
+// Gzip the file, removing the original if successful
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "os.Open")
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0640)
+	if err != nil {
+		return errors.Wrap(err, "os.OpenFile")
+	}
+	gz := gzip.NewWriter(dst)
+	_, err = io.Copy(gz, src)
+	if err == nil {
+		err = gz.Close()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return errors.Wrap(err, "gzip")
+	}
+	return os.Remove(path)
+}
+
+// A rotated log file
+type rotatedFile struct {
+	path    string
+	rotated time.Time
+}
+
+// Get the rotated files for the log file, newest first
+func (f *LogFile) rotatedFiles() ([]rotatedFile, error) {
+	ext := filepath.Ext(f.opts.Name)
+	prefix := strings.TrimSuffix(f.opts.Name, ext) + "-"
+	entries, err := os.ReadDir(f.opts.Dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "os.ReadDir")
+	}
+	files := []rotatedFile{}
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".gz")
+		stamp, ok := strings.CutPrefix(name, prefix)
+		if !ok || !strings.HasSuffix(stamp, ext) {
+			continue
+		}
+		rotated, err := time.ParseInLocation(rotatedTimeFormat,
+			strings.TrimSuffix(stamp, ext), time.Local)
+		if err != nil {
+			continue
+		}
+		files = append(files, rotatedFile{
+			path:    filepath.Join(f.opts.Dir, entry.Name()),
+			rotated: rotated,
+		})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].rotated.After(files[j].rotated)
+	})
+	return files, nil
+}
+
+// Remove rotated files past the max age or max number of backups
+func (f *LogFile) cleanup() error {
+	if f.opts.MaxAge == 0 && f.opts.MaxBackups == 0 {
+		return nil
+	}
+	files, err := f.rotatedFiles()
+	if err != nil {
+		return err
+	}
+	for i, file := range files {
+		tooMany := f.opts.MaxBackups > 0 && i >= f.opts.MaxBackups
+		tooOld := f.opts.MaxAge > 0 && time.Since(file.rotated) > f.opts.MaxAge
+		if tooMany || tooOld {
+			os.Remove(file.path)
+		}
+	}
+	return nil
+}
+
+// Close the log file, waiting for any compression running in the background
+func (f *LogFile) Close() error {
+	f.mu.Lock()
+	var err error
+	if f.file != nil {
+		err = f.file.Close()
+		f.file = nil
+	}
+	f.mu.Unlock()
+	f.wg.Wait()
+	return err
+}
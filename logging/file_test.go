@@ -0,0 +1,133 @@
+package logging
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Get the names of the files in the directory
+func listDir(t *testing.T, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+// Read a file, decompressing it if it's gzipped
+func readLog(t *testing.T, path string) string {
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+	var r io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(file)
+		require.NoError(t, err)
+		r = gz
+	}
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestLogFile(t *testing.T) {
+	t.Run("Rotates by size and compresses", func(t *testing.T) {
+		dir := t.TempDir()
+		f, err := OpenLogFile(FileOptions{
+			Dir:      dir,
+			Name:     "server-3010.log",
+			MaxSize:  10,
+			Compress: true,
+		})
+		require.NoError(t, err)
+		_, err = f.Write([]byte("first\n"))
+		require.NoError(t, err)
+		_, err = f.Write([]byte("second\n"))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+
+		info, err := os.Stat(filepath.Join(dir, "server-3010.log"))
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+		assert.Equal(t, "second\n", readLog(t, filepath.Join(dir, "server-3010.log")))
+		names := listDir(t, dir)
+		require.Len(t, names, 2)
+		assert.Regexp(t, `^server-3010-\d{8}T\d{6}\.\d{3}\.log\.gz$`, names[0])
+		assert.Equal(t, "first\n", readLog(t, filepath.Join(dir, names[0])))
+	})
+
+	t.Run("Rotates by age", func(t *testing.T) {
+		dir := t.TempDir()
+		f, err := OpenLogFile(FileOptions{
+			Dir:            dir,
+			Name:           "server.log",
+			RotateInterval: 20 * time.Millisecond,
+		})
+		require.NoError(t, err)
+		defer f.Close()
+		f.Write([]byte("first\n"))
+		f.Write([]byte("first again\n"))
+		assert.Len(t, listDir(t, dir), 1)
+		time.Sleep(30 * time.Millisecond)
+		f.Write([]byte("second\n"))
+		assert.Len(t, listDir(t, dir), 2)
+	})
+
+	t.Run("Keeps the max backups", func(t *testing.T) {
+		dir := t.TempDir()
+		f, err := OpenLogFile(FileOptions{Dir: dir, Name: "server.log", MaxBackups: 2})
+		require.NoError(t, err)
+		// Another instance's files are left alone
+		other := filepath.Join(dir, "server-3011-20200101T000000.000.log")
+		require.NoError(t, os.WriteFile(other, nil, 0640))
+		for range 4 {
+			f.Write([]byte("line\n"))
+			require.NoError(t, f.Rotate())
+			// Rotated file names have millisecond precision
+			time.Sleep(2 * time.Millisecond)
+		}
+		require.NoError(t, f.Close())
+		rotated, err := f.rotatedFiles()
+		require.NoError(t, err)
+		assert.Len(t, rotated, 2)
+		assert.FileExists(t, other)
+	})
+
+	t.Run("Removes files past the max age", func(t *testing.T) {
+		dir := t.TempDir()
+		old := filepath.Join(dir, "server-"+
+			time.Now().Add(-48*time.Hour).Format(rotatedTimeFormat)+".log.gz")
+		require.NoError(t, os.WriteFile(old, nil, 0640))
+		f, err := OpenLogFile(FileOptions{Dir: dir, Name: "server.log", MaxAge: 24 * time.Hour})
+		require.NoError(t, err)
+		require.NoError(t, f.Rotate())
+		require.NoError(t, f.Close())
+		assert.NoFileExists(t, old)
+		assert.Len(t, listDir(t, dir), 2)
+	})
+
+	t.Run("Reopens after being moved", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "server.log")
+		f, err := OpenLogFile(FileOptions{Dir: dir, Name: "server.log"})
+		require.NoError(t, err)
+		defer f.Close()
+		f.Write([]byte("before\n"))
+		require.NoError(t, os.Rename(path, path+".1"))
+		f.Write([]byte("moved\n"))
+		require.NoError(t, f.Reopen())
+		f.Write([]byte("after\n"))
+		assert.Equal(t, "before\nmoved\n", readLog(t, path+".1"))
+		assert.Equal(t, "after\n", readLog(t, path))
+	})
+}
@@ -0,0 +1,89 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// Formats a log output can be written in
+const (
+	FormatPretty = "pretty" // Human readable, coloured for the console
+	FormatJSON   = "json"   // One JSON object per line, as zerolog writes it
+	FormatLogfmt = "logfmt" // key=value pairs, one event per line
+)
+
+// Wrap the writer so events are written in the format. Colour is only used
+// for the pretty format on the console
+func formatWriter(w io.Writer, format string, console bool) (io.Writer, error) {
+	switch format {
+	case FormatJSON:
+		return w, nil
+	case FormatPretty:
+		return zerolog.ConsoleWriter{Out: w, NoColor: !console}, nil
+	case FormatLogfmt:
+		return logfmtWriter(w), nil
+	}
+	return nil, errors.Errorf("unknown log format %q", format)
+}
+
+// Get a ConsoleWriter that writes events as logfmt
+func logfmtWriter(w io.Writer) zerolog.ConsoleWriter {
+	return zerolog.ConsoleWriter{
+		Out:        w,
+		NoColor:    true,
+		PartsOrder: []string{zerolog.TimestampFieldName, zerolog.LevelFieldName, zerolog.MessageFieldName},
+		FormatTimestamp: func(i any) string {
+			return "time=" + logfmtTime(i)
+		},
+		FormatLevel: func(i any) string {
+			return "level=" + logfmtValue(i)
+		},
+		FormatMessage: func(i any) string {
+			return "msg=" + logfmtValue(i)
+		},
+		FormatFieldName: func(i any) string {
+			return fmt.Sprintf("%s=", i)
+		},
+		// Field values are already quoted by the ConsoleWriter when needed
+		FormatFieldValue: func(i any) string {
+			return fmt.Sprintf("%s", i)
+		},
+		FormatErrFieldName: func(i any) string {
+			return fmt.Sprintf("%s=", i)
+		},
+		FormatErrFieldValue: func(i any) string {
+			return fmt.Sprintf("%s", i)
+		},
+	}
+}
+
+// Format the timestamp of an event as RFC 3339. Timestamps are written as
+// unix seconds by the logger
+func logfmtTime(i any) string {
+	if n, ok := i.(json.Number); ok {
+		if secs, err := n.Int64(); err == nil {
+			return time.Unix(secs, 0).UTC().Format(time.RFC3339)
+		}
+	}
+	return logfmtValue(i)
+}
+
+// Format the level or message for logfmt, quoting it if it has spaces,
+// quotes or equals signs, or is empty
+func logfmtValue(i any) string {
+	s := ""
+	if i != nil {
+		s = fmt.Sprintf("%s", i)
+	}
+	if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+		return strconv.Quote(s)
+	}
+	return s
+}
@@ -2,8 +2,8 @@ package logging
 
 import (
 	"io"
-	"os"
-	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
@@ -13,6 +13,15 @@ import (
 // Takes a log level as string and converts it to a zerolog.Level interface.
 // If the string is not a valid input it will return zerolog.InfoLevel
 func GetLogLevel(level string) zerolog.Level {
+	logLevel, err := ParseLogLevel(level)
+	if err != nil {
+		return zerolog.InfoLevel
+	}
+	return logLevel
+}
+
+// Parse the name of a log level. Returns an error if the name isn't valid
+func ParseLogLevel(level string) (zerolog.Level, error) {
 	levels := map[string]zerolog.Level{
 		"trace": zerolog.TraceLevel,
 		"debug": zerolog.DebugLevel,
@@ -22,63 +31,111 @@ func GetLogLevel(level string) zerolog.Level {
 		"fatal": zerolog.FatalLevel,
 		"panic": zerolog.PanicLevel,
 	}
-	logLevel, valid := levels[level]
+	logLevel, valid := levels[strings.ToLower(strings.TrimSpace(level))]
 	if !valid {
-		return zerolog.InfoLevel
+		return zerolog.InfoLevel, errors.New(
+			"must be one of trace, debug, info, warn, error, fatal or panic")
 	}
-	return logLevel
+	return logLevel, nil
 }
 
-// Returns a pointer to a new log file with the specified path.
-// Remember to call file.Close() when finished writing to the log file
-func GetLogFile(path string) (*os.File, error) {
-	logPath := filepath.Join(path, "server.log")
-	file, err := os.OpenFile(
-		logPath,
-		os.O_APPEND|os.O_CREATE|os.O_WRONLY,
-		0663,
-	)
-	if err != nil {
-		return nil, errors.Wrap(err, "os.OpenFile")
-	}
-	return file, nil
+// Max number of events logged at a level in each period. Events past the
+// burst are dropped until the next period
+type SampleRate struct {
+	Burst  uint32
+	Period time.Duration
 }
 
-// Get a pointer to a new zerolog.Logger with the specified level and output
-// Can provide a file, writer or both. Must provide at least one of the two
-func GetLogger(
-	logLevel zerolog.Level,
-	w io.Writer,
-	logFile *os.File,
-	logDir string,
-) (*zerolog.Logger, error) {
-	if w == nil && logFile == nil {
+// Options for the logger
+type Options struct {
+	Level         zerolog.Level                // Minimum level logged
+	Console       io.Writer                    // Console output. Not used if nil
+	ConsoleFormat string                       // FormatPretty, FormatJSON or FormatLogfmt. Defaults to pretty
+	File          io.Writer                    // File output. Not used if nil
+	FileFormat    string                       // FormatPretty, FormatJSON or FormatLogfmt. Defaults to JSON
+	ErrorSink     io.Writer                    // Also gets error level events and above as JSON. Not used if nil
+	Redact        []string                     // Names of fields to redact from every output
+	Sample        map[zerolog.Level]SampleRate // Sampling for high volume levels like debug
+}
+
+// Get a pointer to a new zerolog.Logger with the specified level and outputs.
+// Can provide a console, file or both. Must provide at least one of the two
+func GetLogger(opts Options) (*zerolog.Logger, error) {
+	if opts.Console == nil && opts.File == nil {
 		return nil, errors.New("No Writer provided for log output.")
 	}
 
 	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
 	zerolog.ErrorStackMarshaler = pkgerrors.MarshalStack
 
-	var consoleWriter zerolog.ConsoleWriter
-	if w != nil {
-		consoleWriter = zerolog.ConsoleWriter{Out: w}
+	writers := []io.Writer{}
+	if opts.File != nil {
+		format := opts.FileFormat
+		if format == "" {
+			format = FormatJSON
+		}
+		w, err := formatWriter(opts.File, format, false)
+		if err != nil {
+			return nil, errors.Wrap(err, "file")
+		}
+		writers = append(writers, w)
 	}
-
-	var output io.Writer
-	if logFile != nil {
-		if w != nil {
-			output = zerolog.MultiLevelWriter(logFile, consoleWriter)
-		} else {
-			output = logFile
+	if opts.Console != nil {
+		format := opts.ConsoleFormat
+		if format == "" {
+			format = FormatPretty
+		}
+		w, err := formatWriter(opts.Console, format, true)
+		if err != nil {
+			return nil, errors.Wrap(err, "console")
 		}
-	} else {
-		output = consoleWriter
+		writers = append(writers, w)
 	}
+	if opts.ErrorSink != nil {
+		writers = append(writers, &zerolog.FilteredLevelWriter{
+			Writer: zerolog.LevelWriterAdapter{Writer: opts.ErrorSink},
+			Level:  zerolog.ErrorLevel,
+		})
+	}
+	output := newRedactWriter(zerolog.MultiLevelWriter(writers...), opts.Redact)
+
 	logger := zerolog.New(output).
 		With().
 		Timestamp().
-		Logger().
-		Level(logLevel)
+		Logger()
+	if len(opts.Sample) > 0 {
+		logger = logger.Sample(levelSampler(opts.Sample))
+	}
+	SetLevel(opts.Level)
 
 	return &logger, nil
 }
+
+// Get a sampler applying the sample rate for each level. Levels without a
+// rate are always logged
+func levelSampler(rates map[zerolog.Level]SampleRate) zerolog.Sampler {
+	sampler := zerolog.LevelSampler{}
+	for level, rate := range rates {
+		// Without a NextSampler events past the burst are dropped
+		burst := &zerolog.BurstSampler{Burst: rate.Burst, Period: rate.Period}
+		switch level {
+		case zerolog.TraceLevel:
+			sampler.TraceSampler = burst
+		case zerolog.DebugLevel:
+			sampler.DebugSampler = burst
+		case zerolog.InfoLevel:
+			sampler.InfoSampler = burst
+		case zerolog.WarnLevel:
+			sampler.WarnSampler = burst
+		case zerolog.ErrorLevel:
+			sampler.ErrorSampler = burst
+		}
+	}
+	return sampler
+}
+
+// Set the minimum level logged. Applies to every logger so the level can be
+// changed while running
+func SetLevel(logLevel zerolog.Level) {
+	zerolog.SetGlobalLevel(logLevel)
+}
@@ -0,0 +1,103 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetLogger(t *testing.T) {
+	t.Run("Formats each output", func(t *testing.T) {
+		var console, file bytes.Buffer
+		logger, err := GetLogger(Options{
+			Level:         zerolog.InfoLevel,
+			Console:       &console,
+			ConsoleFormat: FormatLogfmt,
+			File:          &file,
+			FileFormat:    FormatJSON,
+		})
+		require.NoError(t, err)
+		logger.Info().Str("path", "/about").Int("status", 200).Msg("Request served")
+
+		event := map[string]any{}
+		require.NoError(t, json.Unmarshal(file.Bytes(), &event))
+		assert.Equal(t, "Request served", event["message"])
+		assert.Equal(t, "/about", event["path"])
+		assert.Regexp(t,
+			`^time=\S+Z level=info msg="Request served" path=/about status=200\n$`,
+			console.String())
+	})
+
+	t.Run("Pretty output to a file has no colour", func(t *testing.T) {
+		var file bytes.Buffer
+		logger, err := GetLogger(Options{File: &file, FileFormat: FormatPretty})
+		require.NoError(t, err)
+		logger.Info().Msg("Hello")
+		assert.Contains(t, file.String(), "INF Hello")
+		assert.NotContains(t, file.String(), "\x1b[")
+	})
+
+	t.Run("Unknown formats are an error", func(t *testing.T) {
+		_, err := GetLogger(Options{Console: &bytes.Buffer{}, ConsoleFormat: "xml"})
+		assert.Error(t, err)
+	})
+
+	t.Run("Fields are redacted", func(t *testing.T) {
+		var file bytes.Buffer
+		logger, err := GetLogger(Options{
+			File:   &file,
+			Redact: []string{"password", "access_token"},
+		})
+		require.NoError(t, err)
+		logger.Info().
+			Str("Password", `hunter2 "quoted"`).
+			Dict("tokens", zerolog.Dict().Str("access_token", "abc.def")).
+			Str("username", "alice").
+			Msg("Login")
+		assert.NotContains(t, file.String(), "hunter2")
+		assert.NotContains(t, file.String(), "abc.def")
+		event := map[string]any{}
+		require.NoError(t, json.Unmarshal(file.Bytes(), &event))
+		assert.Equal(t, redacted, event["Password"])
+		assert.Equal(t, "alice", event["username"])
+	})
+
+	t.Run("Errors are sent to the error sink", func(t *testing.T) {
+		var file, errs bytes.Buffer
+		logger, err := GetLogger(Options{File: &file, ErrorSink: &errs})
+		require.NoError(t, err)
+		logger.Info().Msg("Fine")
+		logger.Warn().Msg("Hmm")
+		logger.Error().Msg("Broken")
+		assert.Equal(t, 3, strings.Count(file.String(), "\n"))
+		assert.Equal(t, 1, strings.Count(errs.String(), "\n"))
+		assert.Contains(t, errs.String(), "Broken")
+	})
+
+	t.Run("Debug events are sampled", func(t *testing.T) {
+		var file bytes.Buffer
+		logger, err := GetLogger(Options{
+			Level: zerolog.DebugLevel,
+			File:  &file,
+			Sample: map[zerolog.Level]SampleRate{
+				zerolog.DebugLevel: {Burst: 3, Period: time.Hour},
+			},
+		})
+		require.NoError(t, err)
+		defer SetLevel(zerolog.InfoLevel)
+		sub := logger.With().Str("component", "db").Logger()
+		for range 5 {
+			logger.Debug().Msg("Read lock acquired")
+			sub.Debug().Msg("Read lock released")
+		}
+		logger.Info().Msg("Not sampled")
+		assert.Equal(t, 3, strings.Count(file.String(), `"level":"debug"`))
+		assert.Contains(t, file.String(), "Not sampled")
+	})
+}
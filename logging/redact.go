@@ -0,0 +1,55 @@
+package logging
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+// Value written in place of redacted fields
+const redacted = "[REDACTED]"
+
+// Replaces the string values of fields with secret names before events are
+// written, so secrets and tokens logged by mistake don't reach the outputs.
+// Field names are matched without case, including in nested objects
+type redactWriter struct {
+	next    zerolog.LevelWriter
+	pattern *regexp.Regexp
+}
+
+// Wrap the writer so the fields are redacted. Returns the writer as is if
+// there are no fields
+func newRedactWriter(next zerolog.LevelWriter, fields []string) zerolog.LevelWriter {
+	if len(fields) == 0 {
+		return next
+	}
+	quoted := make([]string, len(fields))
+	for i, field := range fields {
+		quoted[i] = regexp.QuoteMeta(field)
+	}
+	// Matches "field":"value" with escaped characters in the value
+	pattern := regexp.MustCompile(
+		`(?i)("(?:` + strings.Join(quoted, "|") + `)":)"(?:[^"\\]|\\.)*"`)
+	return &redactWriter{next: next, pattern: pattern}
+}
+
+func (w *redactWriter) redact(p []byte) []byte {
+	return w.pattern.ReplaceAll(p, []byte(`$1"`+redacted+`"`))
+}
+
+// The length of the original event is returned so callers don't see a
+// short write when the redacted event is shorter
+func (w *redactWriter) Write(p []byte) (int, error) {
+	if _, err := w.next.Write(w.redact(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w *redactWriter) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	if _, err := w.next.WriteLevel(level, w.redact(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -2,40 +2,55 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"embed"
 	"flag"
 	"fmt"
-	"io"
 	"io/fs"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strconv"
 	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
 
+	"projectreshoot/assets"
+	"projectreshoot/certs"
 	"projectreshoot/config"
 	"projectreshoot/db"
-	"projectreshoot/logging"
+	"projectreshoot/jobs"
+	"projectreshoot/metrics"
 	"projectreshoot/server"
 	"projectreshoot/tests"
+	"projectreshoot/tracing"
 
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
 )
 
+//go:generate go run ./assets/vendor static
+//go:generate go run ./assets/precompress static
+
 //go:embed static/*
 var embeddedStatic embed.FS
 
-// Gets the static files
-func getStaticFiles(logger *zerolog.Logger) (http.FileSystem, error) {
+// Gets the static files and builds the asset manifest
+func getStaticFiles(logger *zerolog.Logger) (*assets.Manifest, error) {
 	if _, err := os.Stat("static"); err == nil {
-		// Use actual filesystem in development
+		// Use actual filesystem in development, rehashing files as they change
 		logger.Debug().Msg("Using filesystem for static files")
-		return http.Dir("static"), nil
+		if err := assets.CheckVendored(os.DirFS("static")); err != nil {
+			logger.Warn().Err(err).Msg("Pages will load without the vendored libraries")
+		}
+		manifest, err := assets.NewManifest(os.DirFS("static"), true)
+		if err != nil {
+			return nil, errors.Wrap(err, "assets.NewManifest")
+		}
+		return manifest, nil
 	} else {
 		// Use embedded filesystem in production
 		logger.Debug().Msg("Using embedded static files")
@@ -43,144 +58,528 @@ func getStaticFiles(logger *zerolog.Logger) (http.FileSystem, error) {
 		if err != nil {
 			return nil, errors.Wrap(err, "fs.Sub")
 		}
-		return http.FS(subFS), nil
+		if err := assets.CheckVendored(subFS); err != nil {
+			return nil, errors.Wrap(err, "assets.CheckVendored")
+		}
+		manifest, err := assets.NewManifest(subFS, false)
+		if err != nil {
+			return nil, errors.Wrap(err, "assets.NewManifest")
+		}
+		return manifest, nil
+	}
+}
+
+// Get the database connection options from the config
+func dbOptions(config *config.Config) db.Options {
+	return db.Options{
+		JournalMode:   config.DBJournalMode,
+		BusyTimeout:   config.DBBusyTimeout * time.Millisecond,
+		Synchronous:   config.DBSynchronous,
+		ForeignKeys:   config.DBForeignKeys,
+		MaxReadConns:  config.DBMaxReadConns,
+		MaxWriteConns: config.DBMaxWriteConns,
 	}
 }
 
 var maint uint32 // atomic: 1 if in maintenance mode
 
-// Handle SIGUSR1 and SIGUSR2 syscalls to toggle maintenance mode
-func handleMaintSignals(
-	conn *db.SafeConn,
-	srv *http.Server,
+// Get a name for this instance that is unique between instances sharing the
+// database
+func instanceName(config *config.Config) (string, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", errors.Wrap(err, "os.Hostname")
+	}
+	return net.JoinHostPort(hostname, config.Port), nil
+}
+
+// Set up the queue worker pool from the config. Returns nil if there are no
+// workers or no job kinds to handle. Handlers for deferred work are
+// registered here
+func setupWorkers(
+	config *config.Config,
 	logger *zerolog.Logger,
+	conn *db.SafeConn,
+) (*jobs.Workers, error) {
+	if config.QueueWorkers == 0 {
+		logger.Info().Msg("Queue workers disabled")
+		return nil, nil
+	}
+	name, err := instanceName(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "instanceName")
+	}
+	workers := jobs.NewWorkers(conn, logger, &maint, name, jobs.WorkerOptions{
+		Workers:           config.QueueWorkers,
+		PollInterval:      config.QueuePollInterval * time.Second,
+		VisibilityTimeout: config.QueueVisibility * time.Second,
+		MaxAttempts:       config.QueueMaxAttempts,
+		Backoff:           config.QueueBackoff * time.Second,
+	})
+	// No job kinds are enqueued yet. Register handlers here with
+	// workers.Handle as they are added
+	if !workers.HasHandlers() {
+		logger.Warn().Int("workers", config.QueueWorkers).
+			Msg("No queue job kinds registered, the worker pool is inert and won't be started")
+		return nil, nil
+	}
+	return workers, nil
+}
+
+// Set up the scheduled jobs from the config. Returns nil if jobs are disabled
+func setupJobs(
 	config *config.Config,
-) {
+	logger *zerolog.Logger,
+	conn *db.SafeConn,
+) (*jobs.Scheduler, error) {
+	if !config.JobsEnabled {
+		logger.Info().Msg("Scheduled jobs disabled")
+		return nil, nil
+	}
+	holder, err := instanceName(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "instanceName")
+	}
+	sched := jobs.NewScheduler(conn, logger, &maint, holder)
+
+	tokenCleanup, err := jobs.ParseSchedule(config.JobTokenCleanup)
+	if err != nil {
+		return nil, errors.Wrap(err, "JOB_TOKEN_CLEANUP")
+	}
+	auditRetention, err := jobs.ParseSchedule(config.JobAuditRetention)
+	if err != nil {
+		return nil, errors.Wrap(err, "JOB_AUDIT_RETENTION")
+	}
+	toAdd := []jobs.Job{
+		jobs.TokenCleanup(tokenCleanup, logger),
+		jobs.AuditRetention(
+			auditRetention,
+			time.Duration(config.AuditRetentionDays)*24*time.Hour,
+			logger,
+		),
+	}
+	if config.RateLimitStore == "db" {
+		rateLimitPrune, err := jobs.ParseSchedule(config.JobRateLimitPrune)
+		if err != nil {
+			return nil, errors.Wrap(err, "JOB_RATE_LIMIT_PRUNE")
+		}
+		toAdd = append(toAdd, jobs.RateLimitPrune(rateLimitPrune, logger))
+	}
+	if config.BackupDir != "" && conn.Backend().Name() == "sqlite" {
+		backup, err := jobs.ParseSchedule(config.JobBackup)
+		if err != nil {
+			return nil, errors.Wrap(err, "JOB_BACKUP")
+		}
+		toAdd = append(toAdd, jobs.Backup(
+			backup,
+			config.BackupDir,
+			config.DBName,
+			config.BackupKeep,
+			logger,
+		))
+	}
+	for _, job := range toAdd {
+		job.Jitter = config.JobJitter * time.Second
+		err = sched.Add(job)
+		if err != nil {
+			return nil, errors.Wrap(err, "sched.Add")
+		}
+	}
+	return sched, nil
+}
+
+// Maintenance markers let the commands see that an instance has paused the
+// database, as the lock itself only covers the server's process. They are
+// named "<DBName>.maint.<instance>", next to the SQLite database
+const maintMarkerInfix = ".maint."
+
+// Get the path of the maintenance marker for this instance
+func maintMarker(config *config.Config) (string, error) {
+	name, err := instanceName(config)
+	if err != nil {
+		return "", errors.Wrap(err, "instanceName")
+	}
+	return config.DBName + maintMarkerInfix + name, nil
+}
+
+// Returns true if any instance has paused the database for maintenance
+func inMaintenance(config *config.Config) bool {
+	markers, _ := filepath.Glob(config.DBName + maintMarkerInfix + "*")
+	return len(markers) > 0
+}
+
+// Starts and ends maintenance mode, from the signals or the admin endpoints
+type maintenance struct {
+	mu     sync.Mutex // Stops maintenance starting and ending at the same time
+	conn   *db.SafeConn
+	logger *zerolog.Logger
+	config *config.Config
+	marker string // Path of the maintenance marker. Not written if empty
+}
+
+// Create or remove the maintenance marker
+func (m *maintenance) setMarker(on bool) {
+	if m.marker == "" {
+		return
+	}
+	var err error
+	if on {
+		err = os.WriteFile(m.marker, []byte(strconv.Itoa(os.Getpid())), 0644)
+	} else {
+		err = os.Remove(m.marker)
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		m.logger.Warn().Err(err).Str("path", m.marker).
+			Msg("Failed to update the maintenance marker")
+	}
+}
+
+// Start maintenance mode and pause the database. Does nothing if already in
+// maintenance mode
+func (m *maintenance) StartMaintenance() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if atomic.LoadUint32(&maint) == 1 {
+		return
+	}
+	atomic.StoreUint32(&maint, 1)
+	m.setMarker(true)
+	m.logger.Info().Msg("Attempting to acquire database lock")
+	m.conn.Pause(m.config.DBLockTimeout * time.Second)
+}
+
+// Resume the database and end maintenance mode. Does nothing if not in
+// maintenance mode
+func (m *maintenance) EndMaintenance() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if atomic.LoadUint32(&maint) == 0 {
+		return
+	}
+	m.logger.Info().Msg("Releasing database lock")
+	m.conn.Resume()
+	atomic.StoreUint32(&maint, 0)
+	m.setMarker(false)
+}
+
+// Handle SIGUSR1 and SIGUSR2 syscalls to toggle maintenance mode
+func handleMaintSignals(m *maintenance, srv *http.Server, logger *zerolog.Logger) {
 	logger.Debug().Msg("Starting signal listener")
 	ch := make(chan os.Signal, 1)
 	srv.RegisterOnShutdown(func() {
 		logger.Debug().Msg("Shutting down signal listener")
+		signal.Stop(ch)
 		close(ch)
 	})
 	go func() {
 		for sig := range ch {
 			switch sig {
 			case syscall.SIGUSR1:
-				if atomic.LoadUint32(&maint) != 1 {
-					atomic.StoreUint32(&maint, 1)
-					logger.Info().Msg("Signal received: Starting maintenance")
-					logger.Info().Msg("Attempting to acquire database lock")
-					conn.Pause(config.DBLockTimeout * time.Second)
-				}
+				logger.Info().Msg("Signal received: Starting maintenance")
+				m.StartMaintenance()
 			case syscall.SIGUSR2:
-				if atomic.LoadUint32(&maint) != 0 {
-					logger.Info().Msg("Signal received: Maintenance over")
-					logger.Info().Msg("Releasing database lock")
-					conn.Resume()
-					atomic.StoreUint32(&maint, 0)
-				}
+				logger.Info().Msg("Signal received: Maintenance over")
+				m.EndMaintenance()
 			}
 		}
+		// Don't leave the marker behind if shut down during maintenance
+		m.setMarker(false)
 	}()
 	signal.Notify(ch, syscall.SIGUSR1, syscall.SIGUSR2)
 }
 
-// Initializes and runs the server
-func run(ctx context.Context, w io.Writer, args map[string]string) error {
-	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt)
-	defer cancel()
+// Serve the metrics, health checks and maintenance endpoints on the admin
+// listener so they aren't exposed publicly. Served with TLS on TCP if
+// tlsCerts isn't nil, requiring client certs if TLS_CLIENT_CA is set.
+// Returns nil if there is no admin listener
+func startAdminServer(
+	config *config.Config,
+	logger *zerolog.Logger,
+	ln net.Listener,
+	handler http.Handler,
+	tlsCerts *certs.Reloader,
+) (*http.Server, error) {
+	if ln == nil {
+		return nil, nil
+	}
+	// No write timeout as starting maintenance waits for the database lock
+	adminServer := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: config.ReadHeaderTimeout * time.Second,
+	}
+	useTLS := tlsCerts != nil && isTCP(ln)
+	if useTLS {
+		tlsConfig, err := serverTLSConfig(config, tlsCerts)
+		if err != nil {
+			return nil, err
+		}
+		if config.TLSClientCA != "" {
+			err := certs.RequireClientCerts(tlsConfig, config.TLSClientCA)
+			if err != nil {
+				return nil, errors.Wrap(err, "certs.RequireClientCerts")
+			}
+		}
+		adminServer.TLSConfig = tlsConfig
+	}
+	go serveListener(adminServer, ln, useTLS, logger.With().Str("listener", "admin").Logger())
+	return adminServer, nil
+}
 
-	config, err := config.GetConfig(args)
+// Returns true if the listener is TCP, rather than a Unix socket
+func isTCP(ln net.Listener) bool {
+	_, ok := ln.Addr().(*net.TCPAddr)
+	return ok
+}
+
+// Serve requests from the listener until the server is shut down
+func serveListener(srv *http.Server, ln net.Listener, useTLS bool, logger zerolog.Logger) {
+	logger.Info().Str("network", ln.Addr().Network()).Str("address", ln.Addr().String()).
+		Bool("tls", useTLS).Msg("Listening for requests")
+	var err error
+	if useTLS {
+		// The certificate comes from the TLSConfig
+		err = srv.ServeTLS(ln, "", "")
+	} else {
+		err = srv.Serve(ln)
+	}
+	if err != nil && err != http.ErrServerClosed && !errors.Is(err, net.ErrClosed) {
+		logger.Error().Err(err).Msg("Error listening and serving")
+	}
+}
+
+// Load the TLS certificate if TLS_CERT_FILE is set and reload it when the
+// files change until the context is done. Returns nil if TLS isn't enabled
+func setupTLS(
+	ctx context.Context,
+	config *config.Config,
+	logger *zerolog.Logger,
+) (*certs.Reloader, error) {
+	if config.TLSCertFile == "" {
+		return nil, nil
+	}
+	tlsCerts, err := certs.NewReloader(config.TLSCertFile, config.TLSKeyFile, logger)
 	if err != nil {
-		return errors.Wrap(err, "server.GetConfig")
+		return nil, errors.Wrap(err, "certs.NewReloader")
 	}
+	go tlsCerts.Watch(ctx, config.TLSReloadInterval*time.Second)
+	return tlsCerts, nil
+}
 
-	// Return the version of the database required
-	if args["dbver"] == "true" {
-		fmt.Fprintf(w, "Database version: %s\n", config.DBName)
-		return nil
+// Get the tls.Config for a listener serving the certificate from tlsCerts
+func serverTLSConfig(config *config.Config, tlsCerts *certs.Reloader) (*tls.Config, error) {
+	version, err := certs.Version(config.TLSMinVersion)
+	if err != nil {
+		return nil, errors.Wrap(err, "certs.Version")
 	}
+	return certs.ServerConfig(tlsCerts, version), nil
+}
 
-	var logfile *os.File = nil
-	if config.LogOutput == "both" || config.LogOutput == "file" {
-		logfile, err = logging.GetLogFile(config.LogDir)
-		if err != nil {
-			return errors.Wrap(err, "logging.GetLogFile")
-		}
-		defer logfile.Close()
+// Redirect plain HTTP requests to HTTPS if TLS_REDIRECT_ADDR is set.
+// Returns nil if not started
+func startRedirectServer(config *config.Config, logger *zerolog.Logger) *http.Server {
+	if config.TLSRedirectAddr == "" {
+		return nil
 	}
+	redirectServer := &http.Server{
+		Addr:              config.TLSRedirectAddr,
+		Handler:           server.RedirectHTTPS(config.TrustedHost, config.HTTPSPort()),
+		ReadHeaderTimeout: config.ReadHeaderTimeout * time.Second,
+	}
+	go func() {
+		logger.Info().Str("address", redirectServer.Addr).Msg("Redirecting HTTP to HTTPS")
+		if err := redirectServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Error().Err(err).Msg("Error serving HTTPS redirects")
+		}
+	}()
+	return redirectServer
+}
+
+// Options for the serve command
+type serveOptions struct {
+	Test   bool // Use an in-memory test database
+	Tester bool // Run the tester function instead of serving
+}
 
-	var consoleWriter io.Writer
-	if config.LogOutput == "both" || config.LogOutput == "console" {
-		consoleWriter = w
+// Run the web server
+func serveCommand(global *config.Flags) *command {
+	var opts serveOptions
+	return &command{
+		name:    "serve",
+		summary: "Run the web server. The default when no command is given",
+		setup:   setupServer,
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&global.Host, "host", global.Host, "Override host to listen on")
+			fs.StringVar(&global.Port, "port", global.Port, "Override port to listen on")
+			fs.BoolVar(&opts.Test, "test", false, "Run server in test mode")
+			fs.BoolVar(&opts.Tester, "tester", false, "Run tester function instead of main program")
+		},
+		run: func(ctx context.Context, env *cmdEnv, args []string) error {
+			return serve(ctx, env, opts)
+		},
 	}
+}
 
-	logger, err := logging.GetLogger(
-		config.LogLevel,
-		consoleWriter,
-		logfile,
-		config.LogDir,
-	)
+// Connect to the in-memory test database, migrated to the current version
+func connectTestDB(
+	ctx context.Context,
+	config *config.Config,
+	logger *zerolog.Logger,
+) (*db.SafeConn, error) {
+	logger.Debug().Msg("Server in test mode, using test database")
+	ver, err := strconv.ParseInt(config.DBName, 10, 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "strconv.ParseInt")
+	}
+	testconn, err := tests.SetupTestDB(ver)
+	if err != nil {
+		return nil, errors.Wrap(err, "tests.SetupTestDB")
+	}
+	conn := db.MakeSafe(testconn, logger)
+	err = conn.Prepare(ctx, db.SQLite.Statements()...)
 	if err != nil {
-		return errors.Wrap(err, "logging.GetLogger")
+		return nil, errors.Wrap(err, "conn.Prepare")
 	}
+	return conn, nil
+}
 
-	logger.Debug().Msg("Config loaded and logger started")
-	logger.Debug().Msg("Connecting to database")
-	var conn *db.SafeConn
-	if args["test"] == "true" {
-		logger.Debug().Msg("Server in test mode, using test database")
-		ver, err := strconv.ParseInt(config.DBName, 10, 0)
-		if err != nil {
-			return errors.Wrap(err, "strconv.ParseInt")
-		}
-		testconn, err := tests.SetupTestDB(ver)
-		if err != nil {
-			return errors.Wrap(err, "tests.SetupTestDB")
+// Initializes and runs the server
+func serve(ctx context.Context, env *cmdEnv, opts serveOptions) error {
+	config, logger := env.config, env.logger
+	shutdownTracing, err := tracing.Setup(ctx, tracing.Options{
+		Exporter:    config.TraceExporter,
+		Endpoint:    config.TraceEndpoint,
+		File:        config.TraceFile,
+		SampleRatio: config.TraceSampleRatio,
+		ServiceName: "projectreshoot",
+	})
+	if err != nil {
+		return errors.Wrap(err, "tracing.Setup")
+	}
+	defer func() {
+		// Flush any spans that haven't been exported
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(shutdownCtx); err != nil {
+			logger.Error().Err(err).Msg("Error shutting down tracing")
 		}
-		conn = db.MakeSafe(testconn, logger)
+	}()
+	var conn *db.SafeConn
+	if opts.Test {
+		conn, err = connectTestDB(ctx, config, logger)
 	} else {
-		conn, err = db.ConnectToDatabase(config.DBName, logger)
-		if err != nil {
-			return errors.Wrap(err, "db.ConnectToDatabase")
-		}
+		conn, err = env.connect()
+	}
+	if err != nil {
+		return err
 	}
 	defer conn.Close()
 
 	logger.Debug().Msg("Getting static files")
-	staticFS, err := getStaticFiles(logger)
+	manifest, err := getStaticFiles(logger)
 	if err != nil {
 		return errors.Wrap(err, "getStaticFiles")
 	}
+	assets.SetDefault(manifest)
+	assets.SetScripts(assets.DefaultScripts(config.FrontendDebug))
+
+	logger.Debug().Msg("Setting up scheduled jobs")
+	sched, err := setupJobs(config, logger, conn)
+	if err != nil {
+		return errors.Wrap(err, "setupJobs")
+	}
+
+	logger.Debug().Msg("Setting up queue workers")
+	workers, err := setupWorkers(config, logger, conn)
+	if err != nil {
+		return errors.Wrap(err, "setupWorkers")
+	}
+
+	// Maintenance markers aren't used with the in-memory test database
+	marker := ""
+	if !opts.Test {
+		marker, err = maintMarker(config)
+		if err != nil {
+			return errors.Wrap(err, "maintMarker")
+		}
+	}
+	maintCtl := &maintenance{conn: conn, logger: logger, config: config, marker: marker}
 
 	logger.Debug().Msg("Setting up HTTP server")
-	srv := server.NewServer(config, logger, conn, &staticFS, &maint)
+	limits := server.RateLimitStore(config, conn)
+	admin := &adminControl{maintenance: maintCtl}
+	reload := newReloader(config, env.flags, logger,
+		serverBuilder(logger, conn, manifest, sched, limits, admin))
+	admin.reloader = reload
 	httpServer := &http.Server{
-		Addr:              net.JoinHostPort(config.Host, config.Port),
-		Handler:           srv,
+		Handler:           reload.Handler(server.Public),
 		ReadHeaderTimeout: config.ReadHeaderTimeout * time.Second,
 		WriteTimeout:      config.WriteTimeout * time.Second,
 		IdleTimeout:       config.IdleTimeout * time.Second,
 	}
 
-	// Runs function for testing in dev if --test flag true
-	if args["tester"] == "true" {
+	// Runs function for testing in dev if --tester flag true
+	if opts.Tester {
 		logger.Debug().Msg("Running tester function")
 		test(config, logger, conn, httpServer)
 		return nil
 	}
 
+	lns, err := listen(config, logger)
+	if err != nil {
+		return errors.Wrap(err, "listen")
+	}
+	tlsCerts, err := setupTLS(ctx, config, logger)
+	if err != nil {
+		lns.Close()
+		return errors.Wrap(err, "setupTLS")
+	}
+	if tlsCerts != nil {
+		httpServer.TLSConfig, err = serverTLSConfig(config, tlsCerts)
+		if err != nil {
+			lns.Close()
+			return err
+		}
+	}
+	adminServer, err := startAdminServer(config, logger, lns.admin,
+		reload.Handler(server.Admin), tlsCerts)
+	if err != nil {
+		lns.Close()
+		return errors.Wrap(err, "startAdminServer")
+	}
+	var conns newConns
+	httpServer.ConnState = conns.track
 	// Setups a channel to listen for os.Signal
-	handleMaintSignals(conn, httpServer, logger, config)
+	handleMaintSignals(maintCtl, httpServer, logger)
+	handleReloadSignal(reload, httpServer, logger, env.logFiles)
 
-	// Runs the http server
-	logger.Debug().Msg("Starting up the HTTP server")
-	go func() {
-		logger.Info().Str("address", httpServer.Addr).Msg("Listening for requests")
-		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			logger.Error().Err(err).Msg("Error listening and serving")
+	// Runs the scheduled jobs and queue workers until shutdown
+	if sched != nil {
+		sched.Start(ctx)
+		defer sched.Wait()
+	}
+	if workers != nil {
+		workers.Start(ctx)
+		defer workers.Wait()
+	}
+
+	// Reports the connection state in the metrics
+	metrics.SetConnStats(func() metrics.ConnStats {
+		stats := conn.LockStats()
+		return metrics.ConnStats{
+			ReadLocks:   stats.ReadLocks,
+			Waiting:     stats.Waiting,
+			Paused:      stats.Paused,
+			Maintenance: atomic.LoadUint32(&maint) == 1,
 		}
-	}()
+	})
+	redirectServer := startRedirectServer(config, logger)
+
+	// Runs the http server on each listener. Unix sockets are for a proxy on
+	// the same machine so are served without TLS
+	logger.Debug().Msg("Starting up the HTTP server")
+	for _, ln := range lns.public {
+		go serveListener(httpServer, ln, tlsCerts != nil && isTCP(ln), *logger)
+	}
 
 	// Handles graceful shutdown
 	var wg sync.WaitGroup
@@ -188,45 +587,41 @@ func run(ctx context.Context, w io.Writer, args map[string]string) error {
 	go func() {
 		defer wg.Done()
 		<-ctx.Done()
+		// Stops accepting first. With a passed socket new connections wait
+		// in its backlog for the next process
+		logger.Info().Msg("Finishing requests in flight")
+		for _, ln := range lns.public {
+			ln.Close()
+		}
 		shutdownCtx := context.Background()
-		shutdownCtx, cancel := context.WithTimeout(shutdownCtx, 10*time.Second)
+		shutdownCtx, cancel := context.WithTimeout(shutdownCtx, config.ShutdownTimeout*time.Second)
 		defer cancel()
+		newCtx, cancelNew := context.WithTimeout(shutdownCtx, config.ReadHeaderTimeout*time.Second)
+		conns.wait(newCtx)
+		cancelNew()
 		if err := httpServer.Shutdown(shutdownCtx); err != nil {
 			logger.Error().Err(err).Msg("Error shutting down server")
 		}
+		if adminServer != nil {
+			if err := adminServer.Shutdown(shutdownCtx); err != nil {
+				logger.Error().Err(err).Msg("Error shutting down admin server")
+			}
+		}
+		if redirectServer != nil {
+			if err := redirectServer.Shutdown(shutdownCtx); err != nil {
+				logger.Error().Err(err).Msg("Error shutting down redirect server")
+			}
+		}
 	}()
 	wg.Wait()
 	logger.Info().Msg("Shutting down")
 	return nil
 }
 
-// Start of runtime. Parse commandline arguments & flags, Initializes context
-// and starts the server
+// Start of runtime. Runs the command given on the commandline
 func main() {
-	// Parse commandline args
-	host := flag.String("host", "", "Override host to listen on")
-	port := flag.String("port", "", "Override port to listen on")
-	test := flag.Bool("test", false, "Run server in test mode")
-	tester := flag.Bool("tester", false, "Run tester function instead of main program")
-	dbver := flag.Bool("dbver", false, "Get the version of the database required")
-	loglevel := flag.String("loglevel", "", "Set log level")
-	logoutput := flag.String("logoutput", "", "Set log destination (file, console or both)")
-	flag.Parse()
-
-	// Map the args for easy access
-	args := map[string]string{
-		"host":      *host,
-		"port":      *port,
-		"test":      strconv.FormatBool(*test),
-		"tester":    strconv.FormatBool(*tester),
-		"dbver":     strconv.FormatBool(*dbver),
-		"loglevel":  *loglevel,
-		"logoutput": *logoutput,
-	}
-
-	// Start the server
 	ctx := context.Background()
-	if err := run(ctx, os.Stdout, args); err != nil {
+	if err := run(ctx, os.Stdin, os.Stdout, os.Stderr, os.Args[1:]); err != nil {
 		fmt.Fprintf(os.Stderr, "%s\n", err)
 		os.Exit(1)
 	}
@@ -18,21 +18,21 @@ func Test_main(t *testing.T) {
 	ctx := context.Background()
 	ctx, cancel := context.WithCancel(ctx)
 	t.Cleanup(cancel)
-	args := map[string]string{"test": "true"}
+	args := []string{"serve", "--test"}
 	var stdout bytes.Buffer
 	os.Setenv("SECRET_KEY", ".")
 	os.Setenv("HOST", "127.0.0.1")
 	os.Setenv("PORT", "3232")
 	runSrvErr := make(chan error)
 	go func() {
-		if err := run(ctx, &stdout, args); err != nil {
+		if err := run(ctx, nil, &stdout, &stdout, args); err != nil {
 			runSrvErr <- err
 			return
 		}
 	}()
 
 	go func() {
-		err := waitForReady(ctx, 10*time.Second, "http://127.0.0.1:3232/healthz")
+		err := waitForReady(ctx, 10*time.Second, "http://127.0.0.1:3232/readyz")
 		if err != nil {
 			runSrvErr <- err
 			return
@@ -71,6 +71,8 @@ func Test_main(t *testing.T) {
 		case <-time.After(250 * time.Millisecond):
 			t.Errorf("Not found")
 		}
+		require.Equal(t, http.StatusServiceUnavailable, getStatus(t, "/readyz"))
+		require.Equal(t, http.StatusOK, getStatus(t, "/livez"))
 	})
 
 	t.Run("SIGUSR2 releases database global lock", func(t *testing.T) {
@@ -96,7 +98,48 @@ func Test_main(t *testing.T) {
 		case <-time.After(250 * time.Millisecond):
 			t.Errorf("Not found")
 		}
+		require.Equal(t, http.StatusOK, getStatus(t, "/readyz"))
 	})
+
+	t.Run("SIGHUP reloads the config", func(t *testing.T) {
+		t.Setenv("CSP_REPORT_ONLY", "true")
+		t.Setenv("PORT", "3233")
+		done := make(chan bool)
+		go func() {
+			expected := "Config reloaded"
+			for {
+				if strings.Contains(stdout.String(), expected) {
+					done <- true
+					return
+				}
+				time.Sleep(100 * time.Millisecond)
+			}
+		}()
+
+		proc, err := os.FindProcess(os.Getpid())
+		require.NoError(t, err)
+		proc.Signal(syscall.SIGHUP)
+
+		select {
+		case <-done:
+			t.Log("found")
+		case <-time.After(time.Second):
+			t.Fatalf("Not found")
+		}
+		require.Contains(t, stdout.String(), "Setting can't be changed without a restart")
+		resp, err := http.Get("http://127.0.0.1:3232/about")
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.NotEmpty(t, resp.Header.Get("Content-Security-Policy-Report-Only"))
+	})
+}
+
+// Get the response status code of the given path on the test server
+func getStatus(t *testing.T, path string) int {
+	resp, err := http.Get("http://127.0.0.1:3232" + path)
+	require.NoError(t, err)
+	resp.Body.Close()
+	return resp.StatusCode
 }
 
 func waitForReady(
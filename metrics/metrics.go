@@ -0,0 +1,142 @@
+package metrics
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Registry with all the application metrics and the Go runtime metrics
+var registry = prometheus.NewRegistry()
+
+var (
+	// Requests handled, by route pattern, method and status code
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "Number of HTTP requests handled",
+	}, []string{"route", "method", "status"})
+
+	// Time taken to handle requests, by route pattern and method
+	HTTPDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Time taken to handle HTTP requests",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	// Panics recovered while handling requests
+	Panics = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "http_panics_total",
+		Help: "Number of panics recovered while handling requests",
+	})
+
+	// Outcome of authenticating requests
+	AuthOutcomes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_outcomes_total",
+		Help: "Outcome of authenticating requests",
+	}, []string{"outcome"})
+
+	// Requests rejected by a rate limit, by route group
+	RateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_rate_limited_total",
+		Help: "Number of requests rejected by a rate limit",
+	}, []string{"rule"})
+
+	// Tokens revoked, by token scope
+	TokenRevocations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "token_revocations_total",
+		Help: "Number of tokens revoked",
+	}, []string{"scope"})
+
+	// Time spent waiting for a database read lock
+	DBLockWait = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "db_lock_wait_seconds",
+		Help:    "Time spent waiting for a database read lock",
+		Buckets: []float64{.0001, .001, .005, .01, .05, .1, .5, 1, 5, 10},
+	})
+
+	// Time transactions were open, by mode and how they finished
+	DBTxDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "db_transaction_duration_seconds",
+		Help:    "Time database transactions were open",
+		Buckets: []float64{.0005, .001, .005, .01, .025, .05, .1, .25, .5, 1, 5},
+	}, []string{"mode", "result"})
+)
+
+// Outcomes for AuthOutcomes
+const (
+	AuthAccessValid = "access_valid"
+	AuthRefreshed   = "refreshed"
+	AuthFailed      = "failed"
+	AuthAnonymous   = "anonymous"
+)
+
+// State of the database connection reported at scrape time
+type ConnStats struct {
+	ReadLocks   uint32 // Number of open transactions
+	Waiting     int32  // Number of transactions waiting for a read lock
+	Paused      bool   // True if the global lock is held
+	Maintenance bool   // True if the server is in maintenance mode
+}
+
+// Function called at scrape time to get the connection state
+var connStats atomic.Pointer[func() ConnStats]
+
+// Set the function used to get the connection state at scrape time
+func SetConnStats(fn func() ConnStats) {
+	connStats.Store(&fn)
+}
+
+// Get the connection state, or the zero value if SetConnStats wasn't called
+func getConnStats() ConnStats {
+	fn := connStats.Load()
+	if fn == nil {
+		return ConnStats{}
+	}
+	return (*fn)()
+}
+
+func boolFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests,
+		HTTPDuration,
+		Panics,
+		AuthOutcomes,
+		RateLimited,
+		TokenRevocations,
+		DBLockWait,
+		DBTxDuration,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "db_read_locks",
+			Help: "Number of open database transactions",
+		}, func() float64 { return float64(getConnStats().ReadLocks) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "db_lock_waiting",
+			Help: "Number of transactions waiting for a read lock",
+		}, func() float64 { return float64(getConnStats().Waiting) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "db_paused",
+			Help: "1 if the database global lock is held",
+		}, func() float64 { return boolFloat(getConnStats().Paused) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "maintenance_mode",
+			Help: "1 if the server is in maintenance mode",
+		}, func() float64 { return boolFloat(getConnStats().Maintenance) }),
+	)
+}
+
+// Handler that serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,35 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnStats(t *testing.T) {
+	server := httptest.NewServer(Handler())
+	defer server.Close()
+	scrape := func() string {
+		resp, err := http.Get(server.URL)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(body)
+	}
+
+	assert.Contains(t, scrape(), "db_read_locks 0")
+	SetConnStats(func() ConnStats {
+		return ConnStats{ReadLocks: 3, Waiting: 1, Paused: true, Maintenance: true}
+	})
+	defer SetConnStats(func() ConnStats { return ConnStats{} })
+	scraped := scrape()
+	assert.Contains(t, scraped, "db_read_locks 3")
+	assert.Contains(t, scraped, "db_lock_waiting 1")
+	assert.Contains(t, scraped, "db_paused 1")
+	assert.Contains(t, scraped, "maintenance_mode 1")
+}
@@ -3,6 +3,7 @@ package middleware
 import (
 	"context"
 	"net/http"
+	"strings"
 	"sync/atomic"
 	"time"
 
@@ -12,9 +13,12 @@ import (
 	"projectreshoot/db"
 	"projectreshoot/handler"
 	"projectreshoot/jwt"
+	"projectreshoot/metrics"
+	"projectreshoot/tracing"
 
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // Attempt to use a valid refresh token to generate a new token pair
@@ -50,37 +54,19 @@ func refreshAuthTokens(
 	return user, nil
 }
 
-// Check the cookies for token strings and attempt to authenticate them
-func getAuthenticatedUser(
+// Check the cookies for an access token and attempt to authenticate it.
+// Only reads from the database
+func getAccessUser(
 	config *config.Config,
 	ctx context.Context,
 	tx *db.SafeTX,
-	w http.ResponseWriter,
 	r *http.Request,
 ) (*contexts.AuthenticatedUser, error) {
-	// Get token strings from cookies
-	atStr, rtStr := cookies.GetTokenStrings(r)
-	// Attempt to parse the access token
+	atStr, _ := cookies.GetTokenStrings(r)
 	aT, err := jwt.ParseAccessToken(config, ctx, tx, atStr)
 	if err != nil {
-		// Access token invalid, attempt to parse refresh token
-		rT, err := jwt.ParseRefreshToken(config, ctx, tx, rtStr)
-		if err != nil {
-			return nil, errors.Wrap(err, "jwt.ParseRefreshToken")
-		}
-		// Refresh token valid, attempt to get a new token pair
-		user, err := refreshAuthTokens(config, ctx, tx, w, r, rT)
-		if err != nil {
-			return nil, errors.Wrap(err, "refreshAuthTokens")
-		}
-		// New token pair sent, return the authorized user
-		authUser := contexts.AuthenticatedUser{
-			User:  user,
-			Fresh: time.Now().Unix(),
-		}
-		return &authUser, nil
+		return nil, errors.Wrap(err, "jwt.ParseAccessToken")
 	}
-	// Access token valid
 	user, err := aT.GetUser(ctx, tx)
 	if err != nil {
 		return nil, errors.Wrap(err, "aT.GetUser")
@@ -92,8 +78,36 @@ func getAuthenticatedUser(
 	return &authUser, nil
 }
 
+// Check the cookies for a refresh token and attempt to use it to issue a
+// new token pair
+func getRefreshedUser(
+	config *config.Config,
+	ctx context.Context,
+	tx *db.SafeTX,
+	w http.ResponseWriter,
+	r *http.Request,
+) (*contexts.AuthenticatedUser, error) {
+	_, rtStr := cookies.GetTokenStrings(r)
+	rT, err := jwt.ParseRefreshToken(config, ctx, tx, rtStr)
+	if err != nil {
+		return nil, errors.Wrap(err, "jwt.ParseRefreshToken")
+	}
+	// Refresh token valid, attempt to get a new token pair
+	user, err := refreshAuthTokens(config, ctx, tx, w, r, rT)
+	if err != nil {
+		return nil, errors.Wrap(err, "refreshAuthTokens")
+	}
+	// New token pair sent, return the authorized user
+	authUser := contexts.AuthenticatedUser{
+		User:  user,
+		Fresh: time.Now().Unix(),
+	}
+	return &authUser, nil
+}
+
 // Attempt to authenticate the user and add their account details
-// to the request context
+// to the request context. The access token is checked in a read only
+// transaction, a write transaction is only started to refresh the tokens
 func Authentication(
 	logger *zerolog.Logger,
 	config *config.Config,
@@ -102,41 +116,90 @@ func Authentication(
 	maint *uint32,
 ) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path == "/static/css/output.css" ||
-			r.URL.Path == "/static/favicon.ico" {
+		if strings.HasPrefix(r.URL.Path, "/static/") ||
+			r.URL.Path == "/healthz" ||
+			r.URL.Path == "/livez" ||
+			r.URL.Path == "/readyz" ||
+			r.URL.Path == "/metrics" {
 			next.ServeHTTP(w, r)
 			return
 		}
-		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		log := contexts.GetLogger(r.Context(), logger)
+		// Span covers authenticating the user but not the rest of the chain
+		spanCtx, span := tracing.Start(r.Context(), "middleware.Authentication")
+		ctx, cancel := context.WithTimeout(spanCtx, 10*time.Second)
 		defer cancel()
 		if atomic.LoadUint32(maint) == 1 {
 			cancel()
 		}
 
-		// Start the transaction
-		tx, err := conn.Begin(ctx)
+		// Start the read only transaction
+		tx, err := conn.BeginRead(ctx)
 		if err != nil {
 			// Failed to start transaction, skip auth
-			logger.Warn().Err(err).
+			log.Warn().Err(err).
 				Msg("Skipping Auth - unable to start a transaction")
+			tracing.Fail(span, err)
+			span.End()
 			handler.ErrorPage(http.StatusServiceUnavailable, w, r)
 			return
 		}
-		user, err := getAuthenticatedUser(config, ctx, tx, w, r)
+		user, err := getAccessUser(config, ctx, tx, r)
+		tx.Commit()
+		outcome := metrics.AuthAccessValid
+		if err != nil {
+			// Access token invalid, attempt to refresh if a refresh token
+			// was provided
+			if _, rtStr := cookies.GetTokenStrings(r); rtStr != "" {
+				tx, err = conn.Begin(ctx)
+				if err != nil {
+					log.Warn().Err(err).
+						Msg("Skipping Auth - unable to start a transaction")
+					tracing.Fail(span, err)
+					span.End()
+					handler.ErrorPage(http.StatusServiceUnavailable, w, r)
+					return
+				}
+				user, err = getRefreshedUser(config, ctx, tx, w, r)
+				if err != nil {
+					tx.Rollback()
+				} else {
+					handler.RecordAudit(ctx, tx, r, log, user.ID, handler.AuditRefresh, "")
+					err = errors.Wrap(tx.Commit(), "tx.Commit")
+					if err == nil {
+						outcome = metrics.AuthRefreshed
+					}
+				}
+			}
+		}
 		if err != nil {
-			tx.Rollback()
-			// User auth failed, delete the cookies to avoid repeat requests
+			outcome = metrics.AuthFailed
+			if atStr, rtStr := cookies.GetTokenStrings(r); atStr == "" && rtStr == "" {
+				outcome = metrics.AuthAnonymous
+			}
+			metrics.AuthOutcomes.WithLabelValues(outcome).Inc()
+			span.SetAttributes(attribute.String("auth.outcome", outcome))
+			span.End()
+			// User auth failed, delete the cookies to avoid repeat requests,
+			// dropping any new tokens from a refresh that wasn't committed
+			w.Header().Del("Set-Cookie")
 			cookies.DeleteCookie(w, "access", "/")
 			cookies.DeleteCookie(w, "refresh", "/")
-			logger.Debug().
-				Str("remote_addr", r.RemoteAddr).
+			log.Debug().
 				Err(err).
 				Msg("Failed to authenticate user")
 			next.ServeHTTP(w, r)
 			return
 		}
-		tx.Commit()
+		metrics.AuthOutcomes.WithLabelValues(outcome).Inc()
+		span.SetAttributes(
+			attribute.String("auth.outcome", outcome),
+			attribute.Int("user.id", user.ID),
+		)
+		span.End()
 		uctx := contexts.SetUser(r.Context(), user)
+		userLogger := log.With().Int("user_id", user.ID).Logger()
+		uctx = contexts.SetLogger(uctx, &userLogger)
 		newReq := r.WithContext(uctx)
 		next.ServeHTTP(w, newReq)
 	})
@@ -130,6 +130,46 @@ func TestAuthenticationMiddleware(t *testing.T) {
 			assert.Equal(t, strconv.Itoa(tt.id), string(body))
 		})
 	}
+
+	t.Run("Refreshes are recorded in the audit log", func(t *testing.T) {
+		var count int
+		err := conn.QueryRow(
+			"SELECT COUNT(*) FROM audit_log WHERE user_id = 1 AND event = 'refresh'",
+		).Scan(&count)
+		require.NoError(t, err)
+		assert.Equal(t, 1, count)
+	})
+
+	// Changes user 1 and checks the response to the fresh access token
+	checkChange := func(t *testing.T, change func(users db.UserStore) error, expectedCode int) {
+		tx, err := sconn.Begin(t.Context())
+		require.NoError(t, err)
+		require.NoError(t, change(tx.Users()))
+		require.NoError(t, tx.Commit())
+
+		req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+		req.AddCookie(&http.Cookie{Name: "access", Value: tokens["accessFresh"]})
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, expectedCode, resp.StatusCode)
+	}
+	t.Run("Locked users are refused", func(t *testing.T) {
+		checkChange(t, func(users db.UserStore) error {
+			return users.UpdateLockedAt(t.Context(), 1, 1739672300)
+		}, http.StatusUnauthorized)
+		checkChange(t, func(users db.UserStore) error {
+			return users.UpdateLockedAt(t.Context(), 1, 0)
+		}, http.StatusOK)
+	})
+	t.Run("Tokens issued before the user's tokens were revoked are refused", func(t *testing.T) {
+		checkChange(t, func(users db.UserStore) error {
+			return users.UpdateTokensValidAfter(t.Context(), 1, 1739672300)
+		}, http.StatusUnauthorized)
+		checkChange(t, func(users db.UserStore) error {
+			return users.UpdateTokensValidAfter(t.Context(), 1, 0)
+		}, http.StatusOK)
+	})
 }
 
 // get the tokens to test with
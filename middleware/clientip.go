@@ -0,0 +1,113 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"projectreshoot/contexts"
+
+	"github.com/rs/zerolog"
+)
+
+// Resolve the IP address of the client and set it in the context. The
+// forwarding headers are only used when the connection comes from a trusted
+// proxy, and are read right to left so a client can't spoof its address by
+// sending the headers itself. Peers on a Unix socket are only trusted if
+// trustUnix is set
+func ClientIP(
+	logger *zerolog.Logger,
+	trusted []netip.Prefix,
+	trustUnix bool,
+	next http.Handler,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := resolveClientIP(r, trusted, trustUnix)
+		ctx := contexts.SetClientIP(r.Context(), ip)
+		if ip.IsValid() {
+			reqLogger := contexts.GetLogger(ctx, logger).With().
+				Str("client_ip", ip.String()).
+				Logger()
+			ctx = contexts.SetLogger(ctx, &reqLogger)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Get the client IP for the request. The chain of addresses is the
+// forwarding header followed by the peer address. Walking from the right,
+// the first address that isn't a trusted proxy is the client. The Forwarded
+// header is used if set, otherwise X-Forwarded-For
+func resolveClientIP(r *http.Request, trusted []netip.Prefix, trustUnix bool) netip.Addr {
+	peer := parseHostIP(r.RemoteAddr)
+	// Peers on a Unix socket have no address. Any local process that can
+	// connect to the socket could set the headers, so they are only trusted
+	// when enabled
+	_, unixPeer := r.Context().Value(http.LocalAddrContextKey).(*net.UnixAddr)
+	if !(unixPeer && trustUnix) && (!peer.IsValid() || !isTrusted(peer, trusted)) {
+		return peer
+	}
+	var hops []string
+	if forwarded := r.Header.Values("Forwarded"); len(forwarded) > 0 {
+		hops = forwardedFor(forwarded)
+	} else {
+		for _, val := range r.Header.Values("X-Forwarded-For") {
+			hops = append(hops, strings.Split(val, ",")...)
+		}
+	}
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := parseHostIP(strings.TrimSpace(hops[i]))
+		if !ip.IsValid() {
+			// Can't trust anything left of a malformed hop
+			break
+		}
+		client = ip
+		if !isTrusted(ip, trusted) {
+			break
+		}
+	}
+	return client
+}
+
+// Get the for= values from Forwarded headers (RFC 7239) in order
+func forwardedFor(values []string) []string {
+	hops := []string{}
+	for _, val := range values {
+		for _, element := range strings.Split(val, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+				if found && strings.EqualFold(key, "for") {
+					hops = append(hops, strings.Trim(value, `"`))
+				}
+			}
+		}
+	}
+	return hops
+}
+
+// Parse an IP address that may have a port and IPv6 brackets. IPv4 mapped
+// IPv6 addresses are unmapped. Returns the zero Addr if invalid
+func parseHostIP(hostport string) netip.Addr {
+	host := hostport
+	if h, _, err := net.SplitHostPort(hostport); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return ip.Unmap().WithZone("")
+}
+
+// Check if the address is in one of the trusted prefixes
+func isTrusted(ip netip.Addr, trusted []netip.Prefix) bool {
+	for _, prefix := range trusted {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
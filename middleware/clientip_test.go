@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"projectreshoot/contexts"
+	"projectreshoot/tests"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveClientIP(t *testing.T) {
+	trusted := []netip.Prefix{
+		netip.MustParsePrefix("127.0.0.1/32"),
+		netip.MustParsePrefix("::1/128"),
+		netip.MustParsePrefix("10.0.0.0/8"),
+	}
+	tests := []struct {
+		name       string
+		remoteAddr string
+		header     string
+		value      string
+		expected   string
+	}{
+		{"Direct connection", "203.0.113.7:5000", "", "", "203.0.113.7"},
+		{"Untrusted peer headers ignored", "203.0.113.7:5000",
+			"X-Forwarded-For", "198.51.100.1", "203.0.113.7"},
+		{"Trusted proxy", "127.0.0.1:5000",
+			"X-Forwarded-For", "198.51.100.1", "198.51.100.1"},
+		{"Spoofed left entries ignored", "127.0.0.1:5000",
+			"X-Forwarded-For", "1.1.1.1, 198.51.100.1", "198.51.100.1"},
+		{"Chain of trusted proxies", "127.0.0.1:5000",
+			"X-Forwarded-For", "198.51.100.1, 10.0.0.2, 10.0.0.3", "198.51.100.1"},
+		{"All hops trusted", "127.0.0.1:5000",
+			"X-Forwarded-For", "10.0.0.2", "10.0.0.2"},
+		{"Malformed hop stops the walk", "127.0.0.1:5000",
+			"X-Forwarded-For", "198.51.100.1, garbage", "127.0.0.1"},
+		{"IPv6 peer", "[::1]:5000",
+			"X-Forwarded-For", "2001:db8::1", "2001:db8::1"},
+		{"Forwarded header", "127.0.0.1:5000",
+			"Forwarded", `for=192.0.2.60;proto=http, for="[2001:db8:cafe::17]:4711"`,
+			"2001:db8:cafe::17"},
+		{"IPv4 mapped peer is unmapped", "[::ffff:203.0.113.7]:5000", "", "", "203.0.113.7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			assert.Equal(t, tt.expected, resolveClientIP(req, trusted, false).String())
+		})
+	}
+}
+
+func TestResolveClientIPUnixSocket(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "@"
+	local := &net.UnixAddr{Name: "/run/projectreshoot.sock", Net: "unix"}
+	req = req.WithContext(context.WithValue(req.Context(), http.LocalAddrContextKey, local))
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	assert.False(t, resolveClientIP(req, nil, false).IsValid(),
+		"Unix socket peers aren't trusted unless enabled")
+	assert.Equal(t, "198.51.100.1", resolveClientIP(req, nil, true).String())
+}
+
+func TestClientIPMiddleware(t *testing.T) {
+	var ip netip.Addr
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip = contexts.GetClientIP(r.Context())
+	})
+	trusted := []netip.Prefix{netip.MustParsePrefix("127.0.0.1/32")}
+	handler := ClientIP(tests.NilLogger(), trusted, false, next)
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "127.0.0.1:5000"
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, "198.51.100.1", ip.String())
+}
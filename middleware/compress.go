@@ -0,0 +1,266 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Options for the Compress middleware
+type CompressOptions struct {
+	Enabled bool     // Flag for compressing responses
+	MinSize int      // Responses smaller than this many bytes are not compressed
+	Types   []string // MIME types to compress. "type/*" matches all subtypes
+}
+
+// Writer for a content coding that can be reused with Reset
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// A content coding supported by Compress
+type encoding struct {
+	name string
+	pool *sync.Pool
+}
+
+// Supported content codings, in order of preference when the client accepts
+// more than one with the same q-value
+var encodings = []encoding{
+	{"br", &sync.Pool{New: func() any {
+		return brotli.NewWriterLevel(nil, 5)
+	}}},
+	{"zstd", &sync.Pool{New: func() any {
+		enc, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		return enc
+	}}},
+	{"gzip", &sync.Pool{New: func() any {
+		return gzip.NewWriter(nil)
+	}}},
+}
+
+// Compress the response with the best content coding accepted by the client.
+// Only responses with an allowed Content-Type and a body of at least MinSize
+// bytes are compressed. Responses that are flushed before MinSize is reached
+// are compressed so streaming works
+func Compress(next http.Handler, opts CompressOptions) http.Handler {
+	if !opts.Enabled {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		enc, ok := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if !ok || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{
+			ResponseWriter: w,
+			opts:           &opts,
+			enc:            enc,
+			status:         http.StatusOK,
+		}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// Pick the supported content coding with the highest q-value in the
+// Accept-Encoding header. Returns false if none are acceptable
+func negotiateEncoding(header string) (encoding, bool) {
+	if header == "" {
+		return encoding{}, false
+	}
+	qvalues := map[string]float64{}
+	wildcard := -1.0
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			key, val, found := strings.Cut(strings.TrimSpace(param), "=")
+			if found && strings.ToLower(strings.TrimSpace(key)) == "q" {
+				parsed, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+				if err != nil {
+					parsed = 0
+				}
+				q = parsed
+			}
+		}
+		if name == "*" {
+			wildcard = q
+			continue
+		}
+		qvalues[name] = q
+	}
+
+	best, bestQ := encoding{}, 0.0
+	for _, enc := range encodings {
+		q, listed := qvalues[enc.name]
+		if !listed {
+			q = wildcard
+		}
+		if q > bestQ {
+			best, bestQ = enc, q
+		}
+	}
+	return best, bestQ > 0
+}
+
+// Check if the media type matches one of the allowed types
+func compressibleType(contentType string, types []string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range types {
+		if prefix, ok := strings.CutSuffix(allowed, "/*"); ok {
+			if strings.HasPrefix(mediaType, prefix+"/") {
+				return true
+			}
+		} else if mediaType == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// Wraps the http.ResponseWriter, buffering the start of the body until it
+// can decide whether to compress the response
+type compressWriter struct {
+	http.ResponseWriter
+	opts        *CompressOptions
+	enc         encoding
+	status      int
+	buf         []byte
+	comp        compressor
+	decided     bool
+	wroteHeader bool
+}
+
+// Record the status code. Headers are sent when the first bytes of the body
+// are written, or straight away for responses that have no body
+func (cw *compressWriter) WriteHeader(statusCode int) {
+	if cw.wroteHeader || cw.decided {
+		return
+	}
+	if statusCode < 200 {
+		cw.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+	cw.status = statusCode
+	cw.wroteHeader = true
+	if !bodyAllowed(statusCode) {
+		cw.decide(false)
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if cw.decided {
+		if cw.comp != nil {
+			return cw.comp.Write(b)
+		}
+		return cw.ResponseWriter.Write(b)
+	}
+	cw.buf = append(cw.buf, b...)
+	if len(cw.buf) >= cw.opts.MinSize {
+		cw.decide(true)
+		err := cw.writeBuffer()
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// Flush the buffered and compressed data to the client
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		cw.decide(len(cw.buf) > 0)
+		if err := cw.writeBuffer(); err != nil {
+			return
+		}
+	}
+	if cw.comp != nil {
+		if err := cw.comp.Flush(); err != nil {
+			return
+		}
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Get the underlying ResponseWriter for http.ResponseController
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// Send any buffered data and finish the compressed stream
+func (cw *compressWriter) Close() error {
+	if !cw.decided {
+		cw.decide(len(cw.buf) >= cw.opts.MinSize)
+		if err := cw.writeBuffer(); err != nil {
+			return err
+		}
+	}
+	if cw.comp == nil {
+		return nil
+	}
+	err := cw.comp.Close()
+	cw.comp.Reset(nil)
+	cw.enc.pool.Put(cw.comp)
+	cw.comp = nil
+	return err
+}
+
+// Decide whether to compress the response and send the headers. Responses
+// are never compressed if they already have a Content-Encoding or their
+// Content-Type isn't allowed
+func (cw *compressWriter) decide(compress bool) {
+	cw.decided = true
+	header := cw.Header()
+	if header.Get("Content-Type") == "" && len(cw.buf) > 0 {
+		header.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+	compress = compress &&
+		bodyAllowed(cw.status) &&
+		cw.status != http.StatusPartialContent &&
+		header.Get("Content-Encoding") == "" &&
+		compressibleType(header.Get("Content-Type"), cw.opts.Types)
+	if compress {
+		header.Set("Content-Encoding", cw.enc.name)
+		header.Del("Content-Length")
+		header.Del("Accept-Ranges")
+		cw.comp = cw.enc.pool.Get().(compressor)
+		cw.comp.Reset(cw.ResponseWriter)
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+}
+
+// Write the buffered start of the body
+func (cw *compressWriter) writeBuffer() error {
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	var err error
+	if cw.comp != nil {
+		_, err = cw.comp.Write(cw.buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(cw.buf)
+	}
+	cw.buf = nil
+	return err
+}
+
+// Check if a response with the status code can have a body
+func bodyAllowed(status int) bool {
+	return status != http.StatusNoContent && status != http.StatusNotModified
+}
@@ -0,0 +1,162 @@
+package middleware
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		header   string
+		expected string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"gzip, deflate, br, zstd", "br"},
+		{"gzip;q=1.0, br;q=0.5", "gzip"},
+		{"br;q=0, gzip", "gzip"},
+		{"zstd, gzip;q=0.9", "zstd"},
+		{"*", "br"},
+		{"*;q=0.5, br;q=0", "zstd"},
+		{"deflate, identity", ""},
+		{"GZIP;Q=0.8", "gzip"},
+		{"gzip;q=0", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			enc, ok := negotiateEncoding(tt.header)
+			assert.Equal(t, tt.expected != "", ok)
+			assert.Equal(t, tt.expected, enc.name)
+		})
+	}
+}
+
+func TestCompressMiddleware(t *testing.T) {
+	body := strings.Repeat("<p>Project Reshoot</p>", 100)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/html", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Length", "2200")
+		w.Write([]byte(body))
+	})
+	mux.HandleFunc("/small", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("small"))
+	})
+	mux.HandleFunc("/icon", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/x-icon")
+		w.Write([]byte(body))
+	})
+	mux.HandleFunc("/sniffed", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<!DOCTYPE html>" + body))
+	})
+	mux.HandleFunc("/notmodified", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/css")
+		w.WriteHeader(http.StatusNotModified)
+	})
+	mux.HandleFunc("/stream", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: first\n\n"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	})
+	opts := CompressOptions{
+		Enabled: true,
+		MinSize: 1024,
+		Types:   []string{"text/*", "application/json"},
+	}
+	server := httptest.NewServer(Compress(mux, opts))
+	defer server.Close()
+	// Transport that doesn't add or decode gzip itself
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+
+	get := func(t *testing.T, path string, accept string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, server.URL+path, nil)
+		require.NoError(t, err)
+		req.Header.Set("Accept-Encoding", accept)
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	decoders := map[string]func(io.Reader) (io.Reader, error){
+		"br": func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil },
+		"zstd": func(r io.Reader) (io.Reader, error) {
+			return zstd.NewReader(r)
+		},
+		"gzip": func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+	}
+	for name, decode := range decoders {
+		t.Run("Compresses with "+name, func(t *testing.T) {
+			resp := get(t, "/html", name)
+			assert.Equal(t, name, resp.Header.Get("Content-Encoding"))
+			assert.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+			// Length set by the handler is for the uncompressed body
+			assert.NotEqual(t, "2200", resp.Header.Get("Content-Length"))
+			reader, err := decode(resp.Body)
+			require.NoError(t, err)
+			decoded, err := io.ReadAll(reader)
+			require.NoError(t, err)
+			assert.Equal(t, body, string(decoded))
+		})
+	}
+	t.Run("Small responses aren't compressed", func(t *testing.T) {
+		resp := get(t, "/small", "gzip")
+		assert.Empty(t, resp.Header.Get("Content-Encoding"))
+		assert.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+		data, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "small", string(data))
+	})
+	t.Run("Types not in the allow-list aren't compressed", func(t *testing.T) {
+		resp := get(t, "/icon", "gzip")
+		assert.Empty(t, resp.Header.Get("Content-Encoding"))
+		data, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, body, string(data))
+	})
+	t.Run("Content type is sniffed if not set", func(t *testing.T) {
+		resp := get(t, "/sniffed", "gzip")
+		assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+		assert.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+	})
+	t.Run("Not modified responses aren't compressed", func(t *testing.T) {
+		resp := get(t, "/notmodified", "gzip")
+		assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+		assert.Empty(t, resp.Header.Get("Content-Encoding"))
+	})
+	t.Run("Unsupported encodings get the identity", func(t *testing.T) {
+		resp := get(t, "/html", "deflate")
+		assert.Empty(t, resp.Header.Get("Content-Encoding"))
+		assert.Equal(t, "2200", resp.Header.Get("Content-Length"))
+	})
+	t.Run("Flushed responses are streamed", func(t *testing.T) {
+		resp := get(t, "/stream", "gzip")
+		assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+		reader, err := gzip.NewReader(resp.Body)
+		require.NoError(t, err)
+		line, err := bufio.NewReader(reader).ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, "data: first\n", line)
+	})
+	t.Run("Disabled passes responses through", func(t *testing.T) {
+		handler := Compress(mux, CompressOptions{Enabled: false})
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/html", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		handler.ServeHTTP(rec, req)
+		assert.Empty(t, rec.Header().Get("Content-Encoding"))
+		assert.Equal(t, body, rec.Body.String())
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"projectreshoot/contexts"
 	"projectreshoot/handler"
+	"strings"
 	"time"
 
 	"github.com/rs/zerolog"
@@ -21,11 +22,20 @@ func (w *wrappedWriter) WriteHeader(statusCode int) {
 	w.statusCode = statusCode
 }
 
+// Flush the underlying ResponseWriter so streamed responses aren't held
+func (w *wrappedWriter) Flush() {
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Get the underlying ResponseWriter for http.ResponseController
+func (w *wrappedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
 // Middleware to add logs to console with details of the request
 func Logging(logger *zerolog.Logger, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path == "/static/css/output.css" ||
-			r.URL.Path == "/static/favicon.ico" {
+		if strings.HasPrefix(r.URL.Path, "/static/") {
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -39,12 +49,10 @@ func Logging(logger *zerolog.Logger, next http.Handler) http.Handler {
 			statusCode:     http.StatusOK,
 		}
 		next.ServeHTTP(wrapped, r)
-		logger.Info().
+		// Request logger already has the request ID, client IP, method and path
+		contexts.GetLogger(r.Context(), logger).Info().
 			Int("status", wrapped.statusCode).
-			Str("method", r.Method).
-			Str("resource", r.URL.Path).
 			Dur("time_elapsed", time.Since(start)).
-			Str("remote_addr", r.Header.Get("X-Forwarded-For")).
 			Msg("Served")
 	})
 }
@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"projectreshoot/metrics"
+)
+
+// Record the count and duration of requests by route pattern. Must wrap the
+// ServeMux directly so the pattern it matched is set on the request
+func Metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		wrapped := &wrappedWriter{
+			ResponseWriter: w,
+			statusCode:     http.StatusOK,
+		}
+		next.ServeHTTP(wrapped, r)
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		metrics.HTTPRequests.
+			WithLabelValues(route, r.Method, strconv.Itoa(wrapped.statusCode)).
+			Inc()
+		metrics.HTTPDuration.
+			WithLabelValues(route, r.Method).
+			Observe(time.Since(start).Seconds())
+	})
+}
@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"projectreshoot/metrics"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("GET /missing", func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	})
+	mux.Handle("GET /metrics", metrics.Handler())
+	server := httptest.NewServer(Metrics(mux))
+	defer server.Close()
+
+	for _, path := range []string{"/users/1", "/users/2", "/missing"} {
+		resp, err := http.Get(server.URL + path)
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+
+	resp, err := http.Get(server.URL + "/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	scraped := string(body)
+
+	// Requests are grouped by route pattern rather than path
+	assert.Contains(t, scraped,
+		`http_requests_total{method="GET",route="GET /users/{id}",status="200"} 2`)
+	assert.Contains(t, scraped,
+		`http_requests_total{method="GET",route="GET /missing",status="404"} 1`)
+	assert.Contains(t, scraped,
+		`http_request_duration_seconds_count{method="GET",route="GET /users/{id}"} 2`)
+	// Runtime and database metrics are always exposed
+	assert.Contains(t, scraped, "go_goroutines")
+	assert.Contains(t, scraped, "db_read_locks")
+	assert.Contains(t, scraped, "maintenance_mode")
+}
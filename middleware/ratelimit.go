@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"projectreshoot/contexts"
+	"projectreshoot/handler"
+	"projectreshoot/metrics"
+	"projectreshoot/ratelimit"
+
+	"github.com/rs/zerolog"
+)
+
+// Limit the requests to next using the rule. The RateLimit-* headers are
+// set on every limited response, and requests over the limit get a 429 with
+// Retry-After. If the store fails the request is let through
+func RateLimit(
+	logger *zerolog.Logger,
+	store ratelimit.Store,
+	rule ratelimit.Rule,
+	next http.Handler,
+) http.Handler {
+	policy := strconv.Itoa(rule.Limit.Requests) + ";w=" +
+		strconv.Itoa(int(rule.Limit.Window.Seconds()))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := rule.Key(r)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		result, err := store.Take(r.Context(), rule.Name+":"+key, rule.Limit, time.Now())
+		if err != nil {
+			contexts.GetLogger(r.Context(), logger).Warn().Err(err).
+				Str("rule", rule.Name).
+				Msg("Failed to check rate limit")
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("RateLimit-Policy", policy)
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		w.Header().Set("RateLimit-Reset", ceilSeconds(result.Reset))
+		if !result.Allowed {
+			metrics.RateLimited.WithLabelValues(rule.Name).Inc()
+			contexts.GetLogger(r.Context(), logger).Info().
+				Str("rule", rule.Name).
+				Msg("Request rate limited")
+			w.Header().Set("Retry-After", ceilSeconds(max(result.RetryAfter, time.Second)))
+			handler.ErrorPage(http.StatusTooManyRequests, w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Format the duration as a whole number of seconds, rounded up
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
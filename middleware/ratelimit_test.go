@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"projectreshoot/contexts"
+	"projectreshoot/ratelimit"
+	"projectreshoot/tests"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type failingStore struct{}
+
+func (failingStore) Take(
+	ctx context.Context,
+	key string,
+	limit ratelimit.Limit,
+	now time.Time,
+) (ratelimit.Result, error) {
+	return ratelimit.Result{}, errors.New("store unavailable")
+}
+
+func TestRateLimit(t *testing.T) {
+	logger := tests.NilLogger()
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	rule := ratelimit.Rule{
+		Name: "test",
+		Limit: ratelimit.Limit{
+			Algorithm: ratelimit.SlidingWindow,
+			Requests:  2,
+			Window:    time.Hour,
+		},
+		Key: ratelimit.ByIP,
+	}
+	serve := func(handler http.Handler, ip string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/login", nil)
+		if ip != "" {
+			ctx := contexts.SetClientIP(req.Context(), netip.MustParseAddr(ip))
+			req = req.WithContext(ctx)
+		}
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("Requests over the limit are rejected", func(t *testing.T) {
+		handler := RateLimit(logger, ratelimit.NewMemoryStore(), rule, ok)
+		rec := serve(handler, "192.0.2.1")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "2", rec.Header().Get("RateLimit-Limit"))
+		assert.Equal(t, "1", rec.Header().Get("RateLimit-Remaining"))
+		assert.Equal(t, "2;w=3600", rec.Header().Get("RateLimit-Policy"))
+		assert.NotEmpty(t, rec.Header().Get("RateLimit-Reset"))
+		assert.Empty(t, rec.Header().Get("Retry-After"))
+
+		serve(handler, "192.0.2.1")
+		rec = serve(handler, "192.0.2.1")
+		assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+		assert.Equal(t, "0", rec.Header().Get("RateLimit-Remaining"))
+		assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+
+		rec = serve(handler, "192.0.2.2")
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+	t.Run("Requests without a key aren't limited", func(t *testing.T) {
+		handler := RateLimit(logger, ratelimit.NewMemoryStore(), rule, ok)
+		for range 3 {
+			rec := serve(handler, "")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Empty(t, rec.Header().Get("RateLimit-Limit"))
+		}
+	})
+	t.Run("Store errors let the request through", func(t *testing.T) {
+		handler := RateLimit(logger, failingStore{}, rule, ok)
+		rec := serve(handler, "192.0.2.1")
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+}
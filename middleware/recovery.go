@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"time"
+
+	"projectreshoot/contexts"
+	"projectreshoot/handler"
+	"projectreshoot/metrics"
+
+	"github.com/rs/zerolog"
+)
+
+// Wraps the http.ResponseWriter to track if the response has been started
+type recoveryWriter struct {
+	http.ResponseWriter
+	started bool
+}
+
+func (w *recoveryWriter) WriteHeader(statusCode int) {
+	w.started = true
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *recoveryWriter) Write(b []byte) (int, error) {
+	w.started = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush the underlying ResponseWriter so streamed responses aren't held
+func (w *recoveryWriter) Flush() {
+	w.started = true
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Get the underlying ResponseWriter for http.ResponseController
+func (w *recoveryWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Recover from panics in the rest of the chain, logging the stack and
+// sending a 500 error response if one hasn't been started. If crashDir is set a
+// crash report is written there for each panic
+func Recover(logger *zerolog.Logger, crashDir string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw := &recoveryWriter{ResponseWriter: w}
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			if rec == http.ErrAbortHandler {
+				// Used to abort the response on purpose, let net/http handle it
+				panic(rec)
+			}
+			stack := debug.Stack()
+			metrics.Panics.Inc()
+			log := contexts.GetLogger(r.Context(), logger)
+			event := log.Error().
+				Str("panic", fmt.Sprint(rec)).
+				Str("stack", string(stack))
+			if crashDir != "" {
+				path, err := writeCrashReport(crashDir, r, rec, stack)
+				if err != nil {
+					log.Warn().Err(err).Msg("Failed to write crash report")
+				} else {
+					event = event.Str("crash_report", path)
+				}
+			}
+			event.Msg("Recovered from panic")
+
+			if rw.started {
+				// Too late to change the response, the client gets a
+				// truncated body
+				return
+			}
+			handler.ErrorPage(http.StatusInternalServerError, w, r)
+		}()
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// Write a report of the panic to a file in dir, returning the path. Headers
+// are left out as they contain the auth cookies
+func writeCrashReport(dir string, r *http.Request, rec any, stack []byte) (string, error) {
+	err := os.MkdirAll(dir, 0750)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now().UTC()
+	id := contexts.GetRequestID(r.Context())
+	name := fmt.Sprintf("crash-%s-%d.txt", now.Format("20060102-150405"), now.UnixNano()%1e9)
+	path := filepath.Join(dir, name)
+	report := fmt.Sprintf(
+		"Time: %s\nRequest ID: %s\nRequest: %s %s\nPanic: %v\n\n%s",
+		now.Format(time.RFC3339Nano), id, r.Method, r.URL.RequestURI(), rec, stack,
+	)
+	err = os.WriteFile(path, []byte(report), 0640)
+	if err != nil {
+		return "", err
+	}
+	return path, nil
+}
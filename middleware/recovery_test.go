@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"projectreshoot/contexts"
+	"projectreshoot/tests"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecover(t *testing.T) {
+	logger := tests.NilLogger()
+	crashDir := t.TempDir()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("something broke")
+	})
+	mux.HandleFunc("/started", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("partial"))
+		panic("broke halfway")
+	})
+	mux.HandleFunc("/abort", func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	})
+	handler := Recover(logger, crashDir, mux)
+	serve := func(path string, htmx bool) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req = req.WithContext(contexts.SetRequestID(req.Context(), "req-1"))
+		if htmx {
+			req.Header.Set("HX-Request", "true")
+		}
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("Error page is rendered", func(t *testing.T) {
+		rec := serve("/panic", false)
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+		assert.Contains(t, rec.Body.String(), "Internal Server Error")
+	})
+	t.Run("HTMX requests get a 500 without a swap", func(t *testing.T) {
+		rec := serve("/panic", true)
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+		assert.Equal(t, "none", rec.Header().Get("HX-Reswap"))
+		assert.Empty(t, rec.Body.String())
+	})
+	t.Run("Started responses are left alone", func(t *testing.T) {
+		rec := serve("/started", false)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "partial", rec.Body.String())
+	})
+	t.Run("Crash reports are written", func(t *testing.T) {
+		entries, err := os.ReadDir(crashDir)
+		require.NoError(t, err)
+		require.NotEmpty(t, entries)
+		report, err := os.ReadFile(filepath.Join(crashDir, entries[0].Name()))
+		require.NoError(t, err)
+		assert.Contains(t, string(report), "Request ID: req-1")
+		assert.Contains(t, string(report), "Panic: something broke")
+		assert.Contains(t, string(report), "goroutine")
+	})
+	t.Run("ErrAbortHandler is repanicked", func(t *testing.T) {
+		assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+			serve("/abort", false)
+		})
+	})
+}
@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"net/http"
+
+	"projectreshoot/contexts"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Max length of an incoming X-Request-ID that will be used
+const maxRequestIDLength = 128
+
+// Set an ID for the request, using the incoming X-Request-ID if it is valid
+// and generating one otherwise. The ID is returned in the response and a
+// logger with the request details is added to the context
+func RequestID(logger *zerolog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = uuid.NewString()
+		}
+		w.Header().Set("X-Request-ID", id)
+
+		logCtx := logger.With().
+			Str("request_id", id).
+			Str("method", r.Method).
+			Str("path", r.URL.Path)
+		if sc := trace.SpanContextFromContext(r.Context()); sc.IsValid() {
+			logCtx = logCtx.Str("trace_id", sc.TraceID().String())
+		}
+		reqLogger := logCtx.Logger()
+		ctx := contexts.SetRequestID(r.Context(), id)
+		ctx = contexts.SetLogger(ctx, &reqLogger)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Check the request ID is safe to log and echo back. Only printable ASCII
+// without spaces or quotes is allowed
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if c <= ' ' || c > '~' || c == '"' || c == '\\' {
+			return false
+		}
+	}
+	return true
+}
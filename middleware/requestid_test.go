@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"projectreshoot/contexts"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	logger := zerolog.New(&buf)
+	var id string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id = contexts.GetRequestID(r.Context())
+		contexts.GetLogger(r.Context(), nil).Info().Msg("handled")
+	})
+	handler := RequestID(&logger, next)
+	serve := func(header string) *httptest.ResponseRecorder {
+		buf.Reset()
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/profile", nil)
+		if header != "" {
+			req.Header.Set("X-Request-ID", header)
+		}
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("Incoming ID is used", func(t *testing.T) {
+		rec := serve("abc-123")
+		assert.Equal(t, "abc-123", id)
+		assert.Equal(t, "abc-123", rec.Header().Get("X-Request-ID"))
+		assert.Contains(t, buf.String(), `"request_id":"abc-123"`)
+		assert.Contains(t, buf.String(), `"path":"/profile"`)
+		assert.Contains(t, buf.String(), `"method":"GET"`)
+	})
+	t.Run("ID is generated if missing", func(t *testing.T) {
+		rec := serve("")
+		assert.Len(t, id, 36)
+		assert.Equal(t, id, rec.Header().Get("X-Request-ID"))
+	})
+	t.Run("Invalid incoming IDs are replaced", func(t *testing.T) {
+		for _, bad := range []string{"has space", "quote\"", strings.Repeat("a", 129)} {
+			rec := serve(bad)
+			assert.NotEqual(t, bad, id)
+			assert.Equal(t, id, rec.Header().Get("X-Request-ID"))
+		}
+	})
+}
@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"projectreshoot/contexts"
+	"projectreshoot/handler"
+)
+
+// Options for the SecurityHeaders middleware
+type SecurityOptions struct {
+	CSP        string // Content-Security-Policy. {nonce} is replaced per request
+	ReportOnly bool   // Flag for sending the policy without enforcing it
+	ReportPath string // Path violation reports are sent to. Disabled if empty
+	HSTS       bool   // Flag for sending Strict-Transport-Security
+	HSTSMaxAge int    // Max age of Strict-Transport-Security in seconds
+}
+
+// Add security headers to every response. A new nonce is generated for each
+// request and set in the context so inline scripts can be allowed by the
+// Content-Security-Policy
+func SecurityHeaders(next http.Handler, opts SecurityOptions) http.Handler {
+	policy := opts.CSP
+	if opts.ReportPath != "" && !strings.Contains(policy, "report-uri") {
+		policy += "; report-uri " + opts.ReportPath + "; report-to csp-endpoint"
+	}
+	cspHeader := "Content-Security-Policy"
+	if opts.ReportOnly {
+		cspHeader = "Content-Security-Policy-Report-Only"
+	}
+	hsts := "max-age=" + strconv.Itoa(opts.HSTSMaxAge) + "; includeSubDomains"
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce, err := newNonce()
+		if err != nil {
+			handler.ErrorPage(http.StatusInternalServerError, w, r)
+			return
+		}
+		header := w.Header()
+		if policy != "" {
+			header.Set(cspHeader, strings.ReplaceAll(policy, "{nonce}", nonce))
+		}
+		if opts.ReportPath != "" {
+			header.Set("Reporting-Endpoints", `csp-endpoint="`+opts.ReportPath+`"`)
+		}
+		if opts.HSTS {
+			header.Set("Strict-Transport-Security", hsts)
+		}
+		header.Set("X-Content-Type-Options", "nosniff")
+		header.Set("X-Frame-Options", "DENY")
+		header.Set("Referrer-Policy", "strict-origin-when-cross-origin")
+		header.Set("Cross-Origin-Opener-Policy", "same-origin")
+
+		ctx := contexts.SetNonce(r.Context(), nonce)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Generate a random base64 encoded nonce
+func newNonce() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
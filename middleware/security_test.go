@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"projectreshoot/contexts"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	var nonce string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce = contexts.GetNonce(r.Context())
+		w.WriteHeader(http.StatusOK)
+	})
+	serve := func(opts SecurityOptions) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		SecurityHeaders(next, opts).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		return rec
+	}
+	opts := SecurityOptions{
+		CSP:        "script-src 'self' 'nonce-{nonce}'",
+		ReportPath: "/csp-report",
+		HSTSMaxAge: 600,
+	}
+
+	t.Run("Nonce is set in the context and policy", func(t *testing.T) {
+		rec := serve(opts)
+		require.NotEmpty(t, nonce)
+		csp := rec.Header().Get("Content-Security-Policy")
+		assert.Contains(t, csp, "'nonce-"+nonce+"'")
+		assert.Contains(t, csp, "report-uri /csp-report")
+		assert.Equal(t, `csp-endpoint="/csp-report"`, rec.Header().Get("Reporting-Endpoints"))
+		assert.Equal(t, "nosniff", rec.Header().Get("X-Content-Type-Options"))
+		assert.Equal(t, "strict-origin-when-cross-origin", rec.Header().Get("Referrer-Policy"))
+		assert.Equal(t, "DENY", rec.Header().Get("X-Frame-Options"))
+	})
+	t.Run("Nonce is unique per request", func(t *testing.T) {
+		serve(opts)
+		first := nonce
+		serve(opts)
+		assert.NotEqual(t, first, nonce)
+	})
+	t.Run("HSTS only sent when enabled", func(t *testing.T) {
+		rec := serve(opts)
+		assert.Empty(t, rec.Header().Get("Strict-Transport-Security"))
+		withSSL := opts
+		withSSL.HSTS = true
+		rec = serve(withSSL)
+		assert.Equal(t, "max-age=600; includeSubDomains", rec.Header().Get("Strict-Transport-Security"))
+	})
+	t.Run("Report only mode doesn't enforce the policy", func(t *testing.T) {
+		reportOnly := opts
+		reportOnly.ReportOnly = true
+		rec := serve(reportOnly)
+		assert.Empty(t, rec.Header().Get("Content-Security-Policy"))
+		assert.True(t, strings.HasPrefix(
+			rec.Header().Get("Content-Security-Policy-Report-Only"), "script-src",
+		))
+	})
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"net/http"
+
+	"projectreshoot/tracing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Start a server span for the request that covers the whole middleware
+// chain. The trace is continued from the traceparent header if there is one
+func Tracing(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracing.Tracer().Start(ctx, "HTTP "+r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.URLPath(r.URL.Path),
+				semconv.UserAgentOriginal(r.UserAgent()),
+			),
+		)
+		defer span.End()
+		wrapped := &wrappedWriter{
+			ResponseWriter: w,
+			statusCode:     http.StatusOK,
+		}
+		next.ServeHTTP(wrapped, r.WithContext(ctx))
+		span.SetAttributes(semconv.HTTPResponseStatusCode(wrapped.statusCode))
+		if wrapped.statusCode >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(wrapped.statusCode))
+		}
+	})
+}
+
+// Start a span for the handler the ServeMux routes the request to. Must wrap
+// the ServeMux (or Metrics) so the matched route pattern can be used to name
+// the handler span and the server span
+func TraceHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server := trace.SpanFromContext(r.Context())
+		ctx, span := tracing.Start(r.Context(), "handler")
+		defer span.End()
+		r = r.WithContext(ctx)
+		next.ServeHTTP(w, r)
+		if r.Pattern != "" {
+			span.SetName("handler " + r.Pattern)
+			span.SetAttributes(attribute.String("http.route", r.Pattern))
+			server.SetName(r.Pattern)
+			server.SetAttributes(semconv.HTTPRoute(r.Pattern))
+		}
+	})
+}
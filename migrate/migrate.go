@@ -1,69 +1,75 @@
-package main
+package migrate
 
 import (
 	"context"
 	"database/sql"
 	"embed"
-	"fmt"
 	"io/fs"
-	"log"
 	"os"
-	"strconv"
+	"strings"
 
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/pkg/errors"
 	"github.com/pressly/goose/v3"
 
 	_ "modernc.org/sqlite"
 )
 
-//go:embed migrations
+//go:embed migrations migrations_postgres
 var migrationsFS embed.FS
 
-func main() {
-	if len(os.Args) != 4 {
-		fmt.Println("Usage: prmigrate <file_path> up-to|down-to <version>")
-		os.Exit(1)
-	}
+// Directions the database can be migrated in
+const (
+	UpTo   = "up-to"
+	DownTo = "down-to"
+)
 
-	filePath := os.Args[1]
-	direction := os.Args[2]
-	versionStr := os.Args[3]
+// Returns true if the target is a PostgreSQL connection URL rather than a
+// SQLite file path
+func IsPostgres(target string) bool {
+	return strings.HasPrefix(target, "postgres://") ||
+		strings.HasPrefix(target, "postgresql://")
+}
 
-	version, err := strconv.Atoi(versionStr)
-	if err != nil {
-		log.Fatalf("Invalid version number: %v", err)
-	}
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		log.Fatalf("Database file does not exist: %v", filePath)
+// Open the database at target, a SQLite file path or PostgreSQL URL, and get
+// a migration provider for it. The SQLite file must already exist
+func Open(target string) (*sql.DB, *goose.Provider, error) {
+	driver, dialect, migrationsDir := "sqlite", goose.DialectSQLite3, "migrations"
+	if IsPostgres(target) {
+		driver, dialect, migrationsDir = "pgx", goose.DialectPostgres, "migrations_postgres"
+	} else if _, err := os.Stat(target); err != nil {
+		return nil, nil, errors.Wrap(err, "os.Stat")
 	}
-	db, err := sql.Open("sqlite", filePath)
+	db, err := sql.Open(driver, target)
 	if err != nil {
-		log.Fatalf("Failed to open database: %v", err)
+		return nil, nil, errors.Wrap(err, "sql.Open")
 	}
-	defer db.Close()
-
-	migrations, err := fs.Sub(migrationsFS, "migrations")
+	migrations, err := fs.Sub(migrationsFS, migrationsDir)
 	if err != nil {
-		log.Fatalf("Failed to get migrations from embedded filesystem")
+		db.Close()
+		return nil, nil, errors.Wrap(err, "fs.Sub")
 	}
-	provider, err := goose.NewProvider(goose.DialectSQLite3, db, migrations)
+	provider, err := goose.NewProvider(dialect, db, migrations)
 	if err != nil {
-		log.Fatalf("Failed to create migration provider: %v", err)
+		db.Close()
+		return nil, nil, errors.Wrap(err, "goose.NewProvider")
 	}
+	return db, provider, nil
+}
 
-	ctx := context.Background()
-
+// Migrate the database up or down to the version
+func To(ctx context.Context, provider *goose.Provider, direction string, version int64) error {
+	var err error
 	switch direction {
-	case "up-to":
-		_, err = provider.UpTo(ctx, int64(version))
-	case "down-to":
-		_, err = provider.DownTo(ctx, int64(version))
+	case UpTo:
+		_, err = provider.UpTo(ctx, version)
+	case DownTo:
+		_, err = provider.DownTo(ctx, version)
 	default:
-		log.Fatalf("Invalid direction: use 'up-to' or 'down-to'")
+		return errors.Errorf("Invalid direction: use '%s' or '%s'", UpTo, DownTo)
 	}
-
 	if err != nil {
-		log.Fatalf("Migration failed: %v", err)
+		return errors.Wrap(err, "provider."+direction)
 	}
-
-	fmt.Println("Migration successful!")
+	return nil
 }
@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS rate_limits (
+    key TEXT PRIMARY KEY,
+    value REAL NOT NULL DEFAULT 0,
+    previous REAL NOT NULL DEFAULT 0,
+    updated INTEGER NOT NULL DEFAULT 0,
+    expires_at INTEGER NOT NULL DEFAULT 0
+) STRICT;
+CREATE INDEX IF NOT EXISTS rate_limits_expires_at ON rate_limits (expires_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS rate_limits_expires_at;
+DROP TABLE IF EXISTS rate_limits;
+-- +goose StatementEnd
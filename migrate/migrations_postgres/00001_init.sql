@@ -0,0 +1,32 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS jwtblacklist (
+jti UUID PRIMARY KEY,
+exp BIGINT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS users (
+    id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    username TEXT NOT NULL,
+    password_hash TEXT NOT NULL DEFAULT '',
+    created_at BIGINT NOT NULL DEFAULT EXTRACT(EPOCH FROM now())::BIGINT,
+    bio TEXT NOT NULL DEFAULT ''
+);
+CREATE UNIQUE INDEX IF NOT EXISTS users_username_unique ON users (LOWER(username));
+CREATE OR REPLACE FUNCTION cleanup_expired_tokens() RETURNS TRIGGER AS $$
+BEGIN
+DELETE FROM jwtblacklist WHERE exp < EXTRACT(EPOCH FROM now());
+RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+CREATE TRIGGER cleanup_expired_tokens
+AFTER INSERT ON jwtblacklist
+FOR EACH STATEMENT EXECUTE FUNCTION cleanup_expired_tokens();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TRIGGER IF EXISTS cleanup_expired_tokens ON jwtblacklist;
+DROP FUNCTION IF EXISTS cleanup_expired_tokens();
+DROP TABLE IF EXISTS jwtblacklist;
+DROP TABLE IF EXISTS users;
+-- +goose StatementEnd
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"projectreshoot/config"
+	"projectreshoot/migrate"
+
+	"github.com/pkg/errors"
+)
+
+// Options for the migrate commands
+type migrateOptions struct {
+	DB string // SQLite file path or PostgreSQL URL, instead of the configured database
+}
+
+// Manage the database schema
+func migrateCommand() *command {
+	var opts migrateOptions
+	flags := func(fs *flag.FlagSet) {
+		fs.StringVar(&opts.DB, "db", "",
+			"SQLite file path or PostgreSQL URL (default the configured database)")
+	}
+	return &command{
+		name:    "migrate",
+		summary: "Show or change the version of the database schema",
+		commands: []*command{
+			{
+				name:    "status",
+				summary: "List the migrations and whether they are applied",
+				flags:   flags,
+				setup:   setupConfig,
+				run: func(ctx context.Context, env *cmdEnv, args []string) error {
+					return migrateStatus(ctx, env, opts)
+				},
+			},
+			{
+				name:    migrate.UpTo,
+				args:    "[version]",
+				summary: "Migrate up to the version, by default the one the server needs",
+				maxArgs: 1,
+				flags:   flags,
+				setup:   setupConfig,
+				run: func(ctx context.Context, env *cmdEnv, args []string) error {
+					return migrateTo(ctx, env, opts, migrate.UpTo, args)
+				},
+			},
+			{
+				name:    migrate.DownTo,
+				args:    "<version>",
+				summary: "Migrate down to the version",
+				minArgs: 1,
+				maxArgs: 1,
+				flags:   flags,
+				setup:   setupConfig,
+				run: func(ctx context.Context, env *cmdEnv, args []string) error {
+					return migrateTo(ctx, env, opts, migrate.DownTo, args)
+				},
+			},
+		},
+	}
+}
+
+// Get the database to migrate, the configured one unless --db is set
+func migrateTarget(cfg *config.Config, opts migrateOptions) string {
+	if opts.DB != "" {
+		return opts.DB
+	}
+	if cfg.DBDriver == "postgres" {
+		return cfg.DatabaseURL
+	}
+	return cfg.DBName + ".db"
+}
+
+// List the migrations and whether they are applied
+func migrateStatus(ctx context.Context, env *cmdEnv, opts migrateOptions) error {
+	conn, provider, err := migrate.Open(migrateTarget(env.config, opts))
+	if err != nil {
+		return errors.Wrap(err, "migrate.Open")
+	}
+	defer conn.Close()
+	statuses, err := provider.Status(ctx)
+	if err != nil {
+		return errors.Wrap(err, "provider.Status")
+	}
+	tw := tabwriter.NewWriter(env.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tSTATE\tAPPLIED AT\tFILE")
+	for _, status := range statuses {
+		applied := ""
+		if !status.AppliedAt.IsZero() {
+			applied = status.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%05d\t%s\t%s\t%s\n",
+			status.Source.Version,
+			status.State,
+			applied,
+			status.Source.Path,
+		)
+	}
+	return tw.Flush()
+}
+
+// Migrate the database up or down to the version in args, or the version
+// the server needs if not given
+func migrateTo(
+	ctx context.Context,
+	env *cmdEnv,
+	opts migrateOptions,
+	direction string,
+	args []string,
+) error {
+	target := config.DBVersion
+	if len(args) > 0 {
+		target = args[0]
+	}
+	version, err := strconv.ParseInt(target, 10, 64)
+	if err != nil {
+		return errors.Errorf("Invalid version number: %s", target)
+	}
+	conn, provider, err := migrate.Open(migrateTarget(env.config, opts))
+	if err != nil {
+		return errors.Wrap(err, "migrate.Open")
+	}
+	defer conn.Close()
+	err = migrate.To(ctx, provider, direction, version)
+	if err != nil {
+		return errors.Wrap(err, "migrate.To")
+	}
+	fmt.Fprintf(env.stdout, "Migrated database to version %05d\n", version)
+	return nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"projectreshoot/db"
+
+	"github.com/pkg/errors"
+)
+
+// Max number of jobs shown by queue list
+const queueListLimit = 100
+
+// Inspect or retry queued jobs
+func queueCommand() *command {
+	return &command{
+		name:    "queue",
+		summary: "Inspect or retry queued jobs",
+		commands: []*command{
+			{
+				name:    "list",
+				args:    "pending|running|dead",
+				summary: "List queued jobs with the status",
+				minArgs: 1,
+				maxArgs: 1,
+				setup:   setupConfig,
+				run: func(ctx context.Context, env *cmdEnv, args []string) error {
+					return withReadTx(ctx, env, func(ctx context.Context, tx *db.SafeTX) error {
+						return queueList(ctx, env, tx, args[0])
+					})
+				},
+			},
+			{
+				name:    "retry",
+				args:    "<id>|all",
+				summary: "Retry the dead queued job with the ID, or all of them",
+				minArgs: 1,
+				maxArgs: 1,
+				setup:   setupConfig,
+				run: func(ctx context.Context, env *cmdEnv, args []string) error {
+					return withTx(ctx, env, func(ctx context.Context, tx *db.SafeTX) error {
+						return queueRetry(ctx, env, tx, args[0])
+					})
+				},
+			},
+		},
+	}
+}
+
+// List the queued jobs with the status
+func queueList(ctx context.Context, env *cmdEnv, tx *db.SafeTX, status string) error {
+	if status != db.QueuePending && status != db.QueueRunning && status != db.QueueDead {
+		return errors.Errorf("Invalid queue status: %s", status)
+	}
+	jobs, err := tx.Queue().List(ctx, status, queueListLimit)
+	if err != nil {
+		return errors.Wrap(err, "tx.Queue().List")
+	}
+	tw := tabwriter.NewWriter(env.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tKIND\tATTEMPTS\tRUN AFTER\tLAST ERROR")
+	for _, job := range jobs {
+		fmt.Fprintf(tw, "%d\t%s\t%d\t%s\t%s\n",
+			job.ID,
+			job.Kind,
+			job.Attempts,
+			time.Unix(job.RunAfter, 0).Format(time.RFC3339),
+			job.LastError,
+		)
+	}
+	return tw.Flush()
+}
+
+// Retry the dead job with the ID, or every dead job if target is "all"
+func queueRetry(ctx context.Context, env *cmdEnv, tx *db.SafeTX, target string) error {
+	now := time.Now().Unix()
+	if target == "all" {
+		count, err := tx.Queue().RetryDead(ctx, now)
+		if err != nil {
+			return errors.Wrap(err, "tx.Queue().RetryDead")
+		}
+		fmt.Fprintf(env.stdout, "Retrying %d dead jobs\n", count)
+		return nil
+	}
+	id, err := strconv.ParseInt(target, 10, 64)
+	if err != nil {
+		return errors.Wrap(err, "strconv.ParseInt")
+	}
+	retried, err := tx.Queue().Retry(ctx, id, now)
+	if err != nil {
+		return errors.Wrap(err, "tx.Queue().Retry")
+	}
+	if !retried {
+		return errors.Errorf("No dead job with ID %d", id)
+	}
+	fmt.Fprintf(env.stdout, "Retrying job %d\n", id)
+	return nil
+}
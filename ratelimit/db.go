@@ -0,0 +1,66 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"projectreshoot/db"
+
+	"github.com/pkg/errors"
+)
+
+// Store that keeps the state in the database so the counts are shared by
+// every instance using it. Each Take is a write transaction, and fails
+// while the database is paused for maintenance, so the RateLimit
+// middleware lets requests through unchecked until it resumes
+type DBStore struct {
+	conn *db.SafeConn
+}
+
+// Create a new DBStore using the connection
+func NewDBStore(conn *db.SafeConn) *DBStore {
+	return &DBStore{conn: conn}
+}
+
+// Take a request from the allowance of the key
+func (d *DBStore) Take(
+	ctx context.Context,
+	key string,
+	limit Limit,
+	now time.Time,
+) (Result, error) {
+	tx, err := d.conn.Begin(ctx)
+	if err != nil {
+		return Result{}, errors.Wrap(err, "conn.Begin")
+	}
+	store := tx.RateLimits()
+	saved, err := store.Get(ctx, key)
+	if err != nil {
+		tx.Rollback()
+		return Result{}, errors.Wrap(err, "store.Get")
+	}
+	var s state
+	if saved != nil && saved.ExpiresAt >= now.Unix() {
+		s = state{
+			value:    saved.Value,
+			previous: saved.Previous,
+			updated:  time.Unix(0, saved.Updated),
+		}
+	}
+	result := limit.take(&s, now)
+	err = store.Put(ctx, key, db.RateLimitState{
+		Value:     s.value,
+		Previous:  s.previous,
+		Updated:   s.updated.UnixNano(),
+		ExpiresAt: limit.expires(s).Unix(),
+	})
+	if err != nil {
+		tx.Rollback()
+		return Result{}, errors.Wrap(err, "store.Put")
+	}
+	err = tx.Commit()
+	if err != nil {
+		return Result{}, errors.Wrap(err, "tx.Commit")
+	}
+	return result, nil
+}
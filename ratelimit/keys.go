@@ -0,0 +1,34 @@
+package ratelimit
+
+import (
+	"net/http"
+	"strconv"
+
+	"projectreshoot/contexts"
+)
+
+// Count requests by the IP address of the client
+func ByIP(r *http.Request) string {
+	ip := contexts.GetClientIP(r.Context())
+	if !ip.IsValid() {
+		return ""
+	}
+	return "ip:" + ip.String()
+}
+
+// Count requests by the logged in user, or by client IP if not logged in
+func ByUser(r *http.Request) string {
+	user := contexts.GetUser(r.Context())
+	if user == nil {
+		return ByIP(r)
+	}
+	return "user:" + strconv.Itoa(user.ID)
+}
+
+// Count all requests to the route together
+func ByRoute(r *http.Request) string {
+	if r.Pattern == "" {
+		return "route:" + r.Method + " " + r.URL.Path
+	}
+	return "route:" + r.Pattern
+}
@@ -0,0 +1,66 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// How often the memory store removes expired keys
+const sweepInterval = time.Minute
+
+// Store that keeps the state in memory. Each instance has its own counts
+type MemoryStore struct {
+	mu        sync.Mutex
+	states    map[string]memoryEntry
+	lastSweep time.Time
+}
+
+type memoryEntry struct {
+	state   state
+	expires time.Time
+}
+
+// Create a new empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{states: map[string]memoryEntry{}}
+}
+
+// Take a request from the allowance of the key
+func (m *MemoryStore) Take(
+	ctx context.Context,
+	key string,
+	limit Limit,
+	now time.Time,
+) (Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if now.Sub(m.lastSweep) >= sweepInterval {
+		m.sweep(now)
+	}
+	entry := m.states[key]
+	if !entry.expires.IsZero() && now.After(entry.expires) {
+		entry = memoryEntry{}
+	}
+	result := limit.take(&entry.state, now)
+	entry.expires = limit.expires(entry.state)
+	m.states[key] = entry
+	return result, nil
+}
+
+// Remove the expired keys. Must be called with the lock held
+func (m *MemoryStore) sweep(now time.Time) {
+	for key, entry := range m.states {
+		if now.After(entry.expires) {
+			delete(m.states, key)
+		}
+	}
+	m.lastSweep = now
+}
+
+// Number of keys in the store
+func (m *MemoryStore) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.states)
+}
@@ -0,0 +1,138 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"time"
+)
+
+// Algorithms used to count requests against a Limit
+const (
+	// Allows bursts of up to Requests, refilled evenly over the Window
+	TokenBucket = "token_bucket"
+	// Allows Requests in any Window, estimated from the count of the current
+	// and previous fixed windows
+	SlidingWindow = "sliding_window"
+)
+
+// Number of requests allowed in a window of time
+type Limit struct {
+	Algorithm string        // TokenBucket or SlidingWindow
+	Requests  int           // Requests allowed in the window
+	Window    time.Duration // Length of the window
+}
+
+// Outcome of taking a request from a key's allowance
+type Result struct {
+	Allowed    bool          // True if the request is within the limit
+	Limit      int           // Requests allowed in the window
+	Remaining  int           // Requests left before the limit is reached
+	Reset      time.Duration // Time until the allowance is fully restored
+	RetryAfter time.Duration // Time until a request would be allowed. 0 if allowed
+}
+
+// Counts requests against limits. Stores must be safe for concurrent use
+type Store interface {
+	// Take a request from the allowance of the key
+	Take(ctx context.Context, key string, limit Limit, now time.Time) (Result, error)
+}
+
+// Gets the key to count a request against. Requests with an empty key are
+// not limited
+type KeyFunc func(r *http.Request) string
+
+// A limit applied to a group of routes
+type Rule struct {
+	Name  string  // Name of the group, used to prefix the store keys
+	Limit Limit   // Requests allowed per key
+	Key   KeyFunc // Key to count requests against
+}
+
+// State of a key between requests
+type state struct {
+	value    float64   // Tokens left, or requests in the current window
+	previous float64   // Requests in the previous window
+	updated  time.Time // Time of the last refill, or start of the current window
+}
+
+// Time the state can be forgotten after, as it would be the same as new
+func (l Limit) expires(s state) time.Time {
+	return s.updated.Add(2 * l.Window)
+}
+
+// Take a request from the state at now, updating it in place
+func (l Limit) take(s *state, now time.Time) Result {
+	if l.Algorithm == SlidingWindow {
+		return l.takeSlidingWindow(s, now)
+	}
+	return l.takeTokenBucket(s, now)
+}
+
+func (l Limit) takeTokenBucket(s *state, now time.Time) Result {
+	capacity := float64(l.Requests)
+	perSecond := capacity / l.Window.Seconds()
+	if s.updated.IsZero() {
+		s.value = capacity
+	} else if now.After(s.updated) {
+		s.value = math.Min(capacity, s.value+now.Sub(s.updated).Seconds()*perSecond)
+	}
+	s.updated = now
+	result := Result{Limit: l.Requests}
+	if s.value >= 1 {
+		s.value--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = seconds((1 - s.value) / perSecond)
+	}
+	result.Remaining = int(s.value)
+	result.Reset = seconds((capacity - s.value) / perSecond)
+	return result
+}
+
+func (l Limit) takeSlidingWindow(s *state, now time.Time) Result {
+	limit := float64(l.Requests)
+	start := now.Truncate(l.Window)
+	if !s.updated.Equal(start) {
+		if s.updated.Add(l.Window).Equal(start) {
+			s.previous = s.value
+		} else {
+			s.previous = 0
+		}
+		s.value = 0
+		s.updated = start
+	}
+	elapsed := now.Sub(start)
+	weight := 1 - elapsed.Seconds()/l.Window.Seconds()
+	count := s.previous*weight + s.value
+	result := Result{Limit: l.Requests, Reset: l.Window - elapsed}
+	if count+1 <= limit {
+		s.value++
+		count++
+		result.Allowed = true
+	} else {
+		result.RetryAfter = l.retryAfter(s, elapsed)
+	}
+	result.Remaining = max(0, int(limit-count))
+	return result
+}
+
+// Time until the weighted count of a sliding window leaves room for another
+// request
+func (l Limit) retryAfter(s *state, elapsed time.Duration) time.Duration {
+	window := l.Window.Seconds()
+	room := float64(l.Requests) - 1
+	if s.value <= room {
+		// The previous window's requests age out during this window
+		wait := window*(1-(room-s.value)/s.previous) - elapsed.Seconds()
+		return seconds(math.Max(wait, 0))
+	}
+	// The current window becomes the previous one and has to age out
+	wait := l.Window - elapsed
+	return wait + seconds(window*(1-room/s.value))
+}
+
+// Convert a number of seconds into a Duration
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
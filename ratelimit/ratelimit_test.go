@@ -0,0 +1,120 @@
+package ratelimit
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"projectreshoot/db"
+	"projectreshoot/tests"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenBucket(t *testing.T) {
+	limit := Limit{Algorithm: TokenBucket, Requests: 3, Window: 3 * time.Second}
+	now := time.Unix(1000, 0)
+	var s state
+
+	t.Run("Bursts up to the limit", func(t *testing.T) {
+		for i := 2; i >= 0; i-- {
+			result := limit.take(&s, now)
+			assert.True(t, result.Allowed)
+			assert.Equal(t, i, result.Remaining)
+		}
+		result := limit.take(&s, now)
+		assert.False(t, result.Allowed)
+		assert.Equal(t, time.Second, result.RetryAfter)
+		assert.Equal(t, 3*time.Second, result.Reset)
+	})
+	t.Run("Refills over the window", func(t *testing.T) {
+		result := limit.take(&s, now.Add(time.Second))
+		assert.True(t, result.Allowed)
+		assert.Equal(t, 0, result.Remaining)
+		result = limit.take(&s, now.Add(10*time.Second))
+		assert.True(t, result.Allowed)
+		assert.Equal(t, 2, result.Remaining)
+	})
+}
+
+func TestSlidingWindow(t *testing.T) {
+	limit := Limit{Algorithm: SlidingWindow, Requests: 4, Window: 10 * time.Second}
+	start := time.Unix(1000, 0)
+	var s state
+
+	t.Run("Allows the limit in a window", func(t *testing.T) {
+		for i := 3; i >= 0; i-- {
+			result := limit.take(&s, start.Add(2*time.Second))
+			assert.True(t, result.Allowed)
+			assert.Equal(t, i, result.Remaining)
+		}
+		result := limit.take(&s, start.Add(2*time.Second))
+		assert.False(t, result.Allowed)
+		assert.Equal(t, 8*time.Second, result.Reset)
+		// Next window starts in 8s, then 1 of the 4 requests has to age out
+		assert.Equal(t, 10500*time.Millisecond, result.RetryAfter)
+	})
+	t.Run("Previous window is weighted", func(t *testing.T) {
+		// Halfway through the next window the 4 previous requests count as 2
+		result := limit.take(&s, start.Add(15*time.Second))
+		assert.True(t, result.Allowed)
+		assert.Equal(t, 1, result.Remaining)
+		result = limit.take(&s, start.Add(15*time.Second))
+		assert.True(t, result.Allowed)
+		result = limit.take(&s, start.Add(15*time.Second))
+		assert.False(t, result.Allowed)
+		// Previous requests count for less than 1 after 7.5s
+		assert.Equal(t, 2500*time.Millisecond, result.RetryAfter)
+	})
+	t.Run("Old windows are forgotten", func(t *testing.T) {
+		result := limit.take(&s, start.Add(time.Minute))
+		assert.True(t, result.Allowed)
+		assert.Equal(t, 3, result.Remaining)
+	})
+}
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore()
+	limit := Limit{Algorithm: SlidingWindow, Requests: 1, Window: time.Second}
+	now := time.Unix(1000, 0)
+	testStore(t, store, limit, now)
+
+	// Expired keys are swept
+	_, err := store.Take(t.Context(), "c", limit, now.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, store.Len())
+}
+
+func TestDBStore(t *testing.T) {
+	cfg, err := tests.TestConfig()
+	require.NoError(t, err)
+	ver, err := strconv.ParseInt(cfg.DBName, 10, 0)
+	require.NoError(t, err)
+	conn, err := tests.SetupTestDB(ver)
+	require.NoError(t, err)
+	sconn := db.MakeSafe(conn, tests.NilLogger())
+	defer sconn.Close()
+
+	store := NewDBStore(sconn)
+	limit := Limit{Algorithm: TokenBucket, Requests: 1, Window: time.Second}
+	testStore(t, store, limit, time.Now())
+}
+
+// Checks keys are counted separately against a limit of 1 request
+func testStore(t *testing.T, store Store, limit Limit, now time.Time) {
+	ctx := context.Background()
+	result, err := store.Take(ctx, "a", limit, now)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+	result, err = store.Take(ctx, "a", limit, now)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+	result, err = store.Take(ctx, "b", limit, now)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+	result, err = store.Take(ctx, "a", limit, now.Add(2*time.Second))
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+}
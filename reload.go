@@ -0,0 +1,148 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"projectreshoot/assets"
+	"projectreshoot/config"
+	"projectreshoot/db"
+	"projectreshoot/handler"
+	"projectreshoot/jobs"
+	"projectreshoot/logging"
+	"projectreshoot/ratelimit"
+	"projectreshoot/server"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// Reloads the config and swaps in handlers built from it
+type reloader struct {
+	mu       sync.Mutex // Stops reloads running at the same time
+	flags    config.Flags
+	config   atomic.Pointer[config.Config]
+	logger   *zerolog.Logger
+	handlers map[server.Role]*server.SwapHandler
+	build    func(*config.Config, server.Role) http.Handler
+}
+
+// Create a reloader serving the handlers built from config for each role
+func newReloader(
+	config *config.Config,
+	flags config.Flags,
+	logger *zerolog.Logger,
+	build func(*config.Config, server.Role) http.Handler,
+) *reloader {
+	rl := &reloader{
+		flags:    flags,
+		logger:   logger,
+		handlers: map[server.Role]*server.SwapHandler{},
+		build:    build,
+	}
+	for _, role := range []server.Role{server.Public, server.Admin} {
+		rl.handlers[role] = server.NewSwapHandler(build(config, role))
+	}
+	rl.config.Store(config)
+	return rl
+}
+
+// Get the handler for the listeners with the role
+func (rl *reloader) Handler(role server.Role) http.Handler {
+	return rl.handlers[role]
+}
+
+// Get the config currently in use
+func (rl *reloader) Config() *config.Config {
+	return rl.config.Load()
+}
+
+// Read the config again and apply the settings that changed. If the new
+// config is invalid the current one is kept. Settings that need a restart
+// are logged and keep their running values
+func (rl *reloader) Reload() (*config.Reload, error) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.logger.Info().Msg("Reloading config")
+	result, err := rl.Config().Reload(rl.flags)
+	if err != nil {
+		rl.logger.Error().Err(err).Msg("Invalid config, keeping the current config")
+		return nil, errors.Wrap(err, "config.Reload")
+	}
+	for _, key := range result.Rejected {
+		rl.logger.Warn().Str("setting", key).
+			Msg("Setting can't be changed without a restart, keeping the running value")
+	}
+	if len(result.Changed) == 0 {
+		rl.logger.Info().Msg("Config reloaded, no changes to apply")
+		return result, nil
+	}
+	next := result.Config
+	logging.SetLevel(next.LogLevel)
+	assets.SetScripts(assets.DefaultScripts(next.FrontendDebug))
+	for role, swap := range rl.handlers {
+		swap.Swap(rl.build(next, role))
+	}
+	rl.config.Store(next)
+	rl.logger.Info().Str("changed", strings.Join(result.Changed, ",")).
+		Msg("Config reloaded")
+	return result, nil
+}
+
+// Runs the actions of the admin endpoints, the same as the signals
+type adminControl struct {
+	*maintenance
+	reloader *reloader // Set once the handlers using it are built
+}
+
+// Reload the config, the same as SIGHUP
+func (a *adminControl) Reload() (*config.Reload, error) {
+	return a.reloader.Reload()
+}
+
+// Get a function that builds the server handlers from a config
+func serverBuilder(
+	logger *zerolog.Logger,
+	conn *db.SafeConn,
+	manifest *assets.Manifest,
+	sched *jobs.Scheduler,
+	limits ratelimit.Store,
+	ctl handler.AdminControl,
+) func(*config.Config, server.Role) http.Handler {
+	return func(config *config.Config, role server.Role) http.Handler {
+		return server.NewServer(role, config, logger, conn, manifest, &maint, sched, limits, ctl)
+	}
+}
+
+// Handle SIGHUP syscalls to reload the config and reopen the log files, so
+// an external tool like logrotate can move the files
+func handleReloadSignal(
+	rl *reloader,
+	srv *http.Server,
+	logger *zerolog.Logger,
+	logFiles []*logging.LogFile,
+) {
+	ch := make(chan os.Signal, 1)
+	srv.RegisterOnShutdown(func() {
+		signal.Stop(ch)
+		close(ch)
+	})
+	go func() {
+		for range ch {
+			for _, file := range logFiles {
+				if err := file.Reopen(); err != nil {
+					logger.Error().Err(err).Str("path", file.Path()).
+						Msg("Failed to reopen the log file")
+				}
+			}
+			logger.Info().Msg("Signal received: Reloading config")
+			rl.Reload()
+		}
+	}()
+	signal.Notify(ch, syscall.SIGHUP)
+}
@@ -0,0 +1,23 @@
+package server
+
+import (
+	"net"
+	"net/http"
+)
+
+// Returns a handler that redirects every request to the same path over
+// HTTPS. The redirect always goes to host so a forged Host header can't send
+// clients elsewhere. The port is left out when it's 443
+func RedirectHTTPS(host string, port string) http.Handler {
+	if port != "" && port != "443" {
+		host = net.JoinHostPort(host, port)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 308 keeps the method and body of requests other than GET and HEAD
+		status := http.StatusPermanentRedirect
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			status = http.StatusMovedPermanently
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), status)
+	})
+}
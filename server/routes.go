@@ -3,10 +3,14 @@ package server
 import (
 	"net/http"
 
+	"projectreshoot/assets"
 	"projectreshoot/config"
 	"projectreshoot/db"
 	"projectreshoot/handler"
+	"projectreshoot/jobs"
+	"projectreshoot/metrics"
 	"projectreshoot/middleware"
+	"projectreshoot/ratelimit"
 	"projectreshoot/view/page"
 
 	"github.com/rs/zerolog"
@@ -18,18 +22,47 @@ func addRoutes(
 	logger *zerolog.Logger,
 	config *config.Config,
 	conn *db.SafeConn,
-	staticFS *http.FileSystem,
+	manifest *assets.Manifest,
+	maint *uint32,
+	sched *jobs.Scheduler,
+	limits ratelimit.Store,
 ) {
-	route := mux.Handle
 	loggedIn := middleware.LoginReq
 	loggedOut := middleware.LogoutReq
 	fresh := middleware.FreshReq
 
-	// Health check
-	mux.HandleFunc("GET /healthz", func(http.ResponseWriter, *http.Request) {})
+	// Rate limits for each group of routes
+	clientLimit := rateLimiter(logger, limits, ratelimit.Rule{
+		Name:  "client",
+		Limit: limitFromRate(ratelimit.TokenBucket, config.RateLimitClient),
+		Key:   ratelimit.ByIP,
+	})
+	authLimit := rateLimiter(logger, limits, ratelimit.Rule{
+		Name:  "auth",
+		Limit: limitFromRate(ratelimit.SlidingWindow, config.RateLimitAuth),
+		Key:   ratelimit.ByIP,
+	})
+	accountLimit := rateLimiter(logger, limits, ratelimit.Rule{
+		Name:  "account",
+		Limit: limitFromRate(ratelimit.SlidingWindow, config.RateLimitAccount),
+		Key:   ratelimit.ByUser,
+	})
+
+	// Routes added with route count towards the client rate limit
+	route := func(pattern string, h http.Handler) {
+		mux.Handle(pattern, clientLimit(h))
+	}
+
+	// Health checks and metrics, unless served on the admin listener
+	if config.AdminListen == "" {
+		addHealthRoutes(mux, config, conn, maint, sched)
+	}
+
+	// Content-Security-Policy violation reports
+	route("POST "+cspReportPath, handler.CSPReport(logger))
 
 	// Static files
-	route("GET /static/", http.StripPrefix("/static/", handler.StaticFS(staticFS)))
+	mux.Handle("GET /static/", http.StripPrefix("/static/", handler.StaticFS(manifest)))
 
 	// Index page and unhandled catchall (404)
 	route("GET /", handler.Root())
@@ -39,17 +72,18 @@ func addRoutes(
 
 	// Login page and handlers
 	route("GET /login", loggedOut(handler.LoginPage(config.TrustedHost)))
-	route("POST /login", loggedOut(handler.LoginRequest(config, logger, conn)))
+	route("POST /login", authLimit(loggedOut(handler.LoginRequest(config, logger, conn))))
 
 	// Register page and handlers
 	route("GET /register", loggedOut(handler.RegisterPage(config.TrustedHost)))
-	route("POST /register", loggedOut(handler.RegisterRequest(config, logger, conn)))
+	route("POST /register", authLimit(loggedOut(handler.RegisterRequest(config, logger, conn))))
 
 	// Logout
 	route("POST /logout", handler.Logout(config, logger, conn))
 
 	// Reauthentication request
-	route("POST /reauthenticate", loggedIn(handler.Reauthenticate(logger, config, conn)))
+	route("POST /reauthenticate",
+		accountLimit(loggedIn(handler.Reauthenticate(logger, config, conn))))
 
 	// Profile page
 	route("GET /profile", loggedIn(handler.ProfilePage()))
@@ -57,7 +91,84 @@ func addRoutes(
 	// Account page
 	route("GET /account", loggedIn(handler.AccountPage()))
 	route("POST /account-select-page", loggedIn(handler.AccountSubpage()))
-	route("POST /change-username", loggedIn(fresh(handler.ChangeUsername(logger, conn))))
-	route("POST /change-bio", loggedIn(handler.ChangeBio(logger, conn)))
-	route("POST /change-password", loggedIn(fresh(handler.ChangePassword(logger, conn))))
+	route("POST /change-username",
+		accountLimit(loggedIn(fresh(handler.ChangeUsername(logger, conn)))))
+	route("POST /change-bio", accountLimit(loggedIn(handler.ChangeBio(logger, conn))))
+	route("POST /change-password",
+		accountLimit(loggedIn(fresh(handler.ChangePassword(logger, conn)))))
+}
+
+// Add the routes served on the admin listener
+func addAdminRoutes(
+	mux *http.ServeMux,
+	logger *zerolog.Logger,
+	config *config.Config,
+	conn *db.SafeConn,
+	maint *uint32,
+	sched *jobs.Scheduler,
+	ctl handler.AdminControl,
+) {
+	addHealthRoutes(mux, config, conn, maint, sched)
+
+	// Maintenance mode, the same as sending SIGUSR1 and SIGUSR2
+	mux.Handle("POST /maintenance/start", handler.Maintenance(logger, ctl, maint, true))
+	mux.Handle("POST /maintenance/end", handler.Maintenance(logger, ctl, maint, false))
+	// Config reload, the same as sending SIGHUP
+	mux.Handle("POST /reload", handler.ReloadConfig(logger, ctl))
+}
+
+// Add the health checks and Prometheus metrics
+func addHealthRoutes(
+	mux *http.ServeMux,
+	config *config.Config,
+	conn *db.SafeConn,
+	maint *uint32,
+	sched *jobs.Scheduler,
+) {
+	// Health checks. /healthz is kept as an alias of /livez
+	mux.Handle("GET /healthz", handler.Livez(config, conn, maint, sched))
+	mux.Handle("GET /livez", handler.Livez(config, conn, maint, sched))
+	mux.Handle("GET /readyz", handler.Readyz(config, conn, maint, sched))
+
+	if config.Metrics {
+		mux.Handle("GET /metrics", metrics.Handler())
+	}
+}
+
+// Get the rate limit store set by the config. Returns nil if rate limiting
+// is off. The store is kept when the server is rebuilt so the counts carry
+// over a config reload
+func RateLimitStore(config *config.Config, conn *db.SafeConn) ratelimit.Store {
+	switch config.RateLimitStore {
+	case "off":
+		return nil
+	case "db":
+		return ratelimit.NewDBStore(conn)
+	default:
+		return ratelimit.NewMemoryStore()
+	}
+}
+
+// Convert a rate from the config into a limit using the algorithm
+func limitFromRate(algorithm string, rate config.Rate) ratelimit.Limit {
+	return ratelimit.Limit{
+		Algorithm: algorithm,
+		Requests:  rate.Requests,
+		Window:    rate.Window,
+	}
+}
+
+// Get a function that wraps handlers in the rate limit rule. Handlers are
+// returned as is if store is nil
+func rateLimiter(
+	logger *zerolog.Logger,
+	store ratelimit.Store,
+	rule ratelimit.Rule,
+) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if store == nil {
+			return next
+		}
+		return middleware.RateLimit(logger, store, rule, next)
+	}
 }
@@ -3,39 +3,113 @@ package server
 import (
 	"net/http"
 
+	"projectreshoot/assets"
 	"projectreshoot/config"
 	"projectreshoot/db"
+	"projectreshoot/handler"
+	"projectreshoot/jobs"
 	"projectreshoot/middleware"
+	"projectreshoot/ratelimit"
 
 	"github.com/rs/zerolog"
 )
 
-// Returns a new http.Handler with all the routes and middleware added
+// Path browsers send Content-Security-Policy violation reports to
+const cspReportPath = "/csp-report"
+
+// Which routes a listener serves
+type Role int
+
+const (
+	Public Role = iota // The site
+	Admin              // Metrics, health checks and maintenance
+)
+
+// Returns a new http.Handler with the routes and middleware for the role
+// added. The config isn't read after this returns, so to apply a reloaded
+// config build a new handler and swap it in with a SwapHandler
 func NewServer(
+	role Role,
 	config *config.Config,
 	logger *zerolog.Logger,
 	conn *db.SafeConn,
-	staticFS *http.FileSystem,
+	manifest *assets.Manifest,
 	maint *uint32,
+	sched *jobs.Scheduler,
+	limits ratelimit.Store,
+	ctl handler.AdminControl,
 ) http.Handler {
+	if role == Admin {
+		return newAdminServer(config, logger, conn, maint, sched, ctl)
+	}
 	mux := http.NewServeMux()
 	addRoutes(
 		mux,
 		logger,
 		config,
 		conn,
-		staticFS,
+		manifest,
+		maint,
+		sched,
+		limits,
 	)
-	var handler http.Handler = mux
+	// Metrics wraps the mux directly to get the matched route pattern
+	handler := middleware.Metrics(mux)
+	handler = middleware.TraceHandler(handler)
 	// Add middleware here, must be added in reverse order of execution
 	// i.e. First in list will get executed last during the request handling
 	handler = middleware.Logging(logger, handler)
 	handler = middleware.Authentication(logger, config, conn, handler, maint)
 
-	// Gzip
-	handler = middleware.Gzip(handler, config.GZIP)
+	// Panic recovery, inside compression so the error page is compressed
+	handler = middleware.Recover(logger, config.CrashDir, handler)
+
+	// Compression
+	handler = middleware.Compress(handler, middleware.CompressOptions{
+		Enabled: config.Compress,
+		MinSize: config.CompressMinSize,
+		Types:   config.CompressTypes,
+	})
+
+	// Security headers and the CSP nonce, set first so every response has them
+	handler = middleware.SecurityHeaders(handler, middleware.SecurityOptions{
+		CSP:        config.CSP,
+		ReportOnly: config.CSPReportOnly,
+		ReportPath: cspReportPath,
+		HSTS:       config.SSL,
+		HSTSMaxAge: config.HSTSMaxAge,
+	})
+
+	// Client IP from the trusted proxy headers
+	handler = middleware.ClientIP(logger, config.TrustedProxies, config.TrustUnixProxies, handler)
+
+	// Request ID and request scoped logger
+	handler = middleware.RequestID(logger, handler)
+
+	// Server span for the request, continuing the trace from the proxy
+	handler = middleware.Tracing(handler)
 
 	// Start the timer for the request chain so logger can have accurate info
 	handler = middleware.StartTimer(handler)
 	return handler
 }
+
+// Returns the handler for the admin listener. It's only reachable by
+// operators so has no auth, rate limits or security headers
+func newAdminServer(
+	config *config.Config,
+	logger *zerolog.Logger,
+	conn *db.SafeConn,
+	maint *uint32,
+	sched *jobs.Scheduler,
+	ctl handler.AdminControl,
+) http.Handler {
+	mux := http.NewServeMux()
+	addAdminRoutes(mux, logger, config, conn, maint, sched, ctl)
+	var handler http.Handler = mux
+	handler = middleware.Logging(logger, handler)
+	handler = middleware.Recover(logger, config.CrashDir, handler)
+	handler = middleware.RequestID(logger, handler)
+	handler = middleware.StartTimer(handler)
+	return handler
+}
@@ -0,0 +1,28 @@
+package server
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// Passes requests to a handler that can be replaced while serving. Requests
+// already being handled finish with the handler they started with
+type SwapHandler struct {
+	current atomic.Pointer[http.Handler]
+}
+
+// Create a new SwapHandler serving with h
+func NewSwapHandler(h http.Handler) *SwapHandler {
+	s := &SwapHandler{}
+	s.Swap(h)
+	return s
+}
+
+// Serve new requests with h
+func (s *SwapHandler) Swap(h http.Handler) {
+	s.current.Store(&h)
+}
+
+func (s *SwapHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	(*s.current.Load()).ServeHTTP(w, r)
+}
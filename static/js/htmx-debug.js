@@ -0,0 +1,2 @@
+// Logs all htmx events to the console. Only loaded when FRONTEND_DEBUG is set
+htmx.logAll();
@@ -9,7 +9,7 @@ import (
 	"github.com/rs/zerolog"
 )
 
-// This function will only be called if the --test commandline flag is set.
+// This function will only be called if the serve --tester flag is set.
 // After the function finishes the application will close.
 // Running command `make tester` will run the test using port 3232 to avoid
 // conflicts on the default 3333. Useful for testing things out during dev.
@@ -9,7 +9,7 @@ import (
 
 func TestConfig() (*config.Config, error) {
 	os.Setenv("SECRET_KEY", ".")
-	cfg, err := config.GetConfig(map[string]string{})
+	cfg, err := config.GetConfig(config.Flags{})
 	if err != nil {
 		return nil, errors.Wrap(err, "config.GetConfig")
 	}
@@ -0,0 +1,4 @@
+INSERT INTO users (id, username, password_hash, created_at, bio) VALUES(1,'testuser','hashedpassword',1738995274, 'bio');
+SELECT setval(pg_get_serial_sequence('users', 'id'), (SELECT MAX(id) FROM users));
+INSERT INTO jwtblacklist VALUES('0a6b338e-930a-43fe-8f70-1a6daed256fa', 33299675344);
+INSERT INTO jwtblacklist VALUES('b7fa51dc-8532-42e1-8756-5d25bfb2003a', 33299675344);
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"projectreshoot/db"
+	"projectreshoot/jwt"
+
+	"github.com/pkg/errors"
+)
+
+// Inspect, revoke or clean up tokens
+func tokenCommand() *command {
+	return &command{
+		name:    "token",
+		summary: "Inspect or revoke access and refresh tokens",
+		commands: []*command{
+			{
+				name:    "inspect",
+				args:    "<token>",
+				summary: "Validate a token and show its claims",
+				minArgs: 1,
+				maxArgs: 1,
+				setup:   setupSecrets,
+				run: func(ctx context.Context, env *cmdEnv, args []string) error {
+					return withReadTx(ctx, env, func(ctx context.Context, tx *db.SafeTX) error {
+						return tokenInspect(ctx, env, tx, args[0])
+					})
+				},
+			},
+			{
+				name:    "revoke",
+				args:    "<token>",
+				summary: "Revoke a token so it can't be used again",
+				minArgs: 1,
+				maxArgs: 1,
+				setup:   setupSecrets,
+				run: func(ctx context.Context, env *cmdEnv, args []string) error {
+					return withTx(ctx, env, func(ctx context.Context, tx *db.SafeTX) error {
+						return tokenRevoke(ctx, env, tx, args[0])
+					})
+				},
+			},
+			{
+				name:    "prune",
+				summary: "Remove revoked tokens that have expired",
+				setup:   setupConfig,
+				run: func(ctx context.Context, env *cmdEnv, args []string) error {
+					return withTx(ctx, env, func(ctx context.Context, tx *db.SafeTX) error {
+						count, err := tx.Tokens().DeleteExpiredTokens(ctx, time.Now().Unix())
+						if err != nil {
+							return errors.Wrap(err, "tx.Tokens().DeleteExpiredTokens")
+						}
+						fmt.Fprintf(env.stdout, "Removed %d expired tokens\n", count)
+						return nil
+					})
+				},
+			},
+		},
+	}
+}
+
+// Validate the token and write its claims
+func tokenInspect(ctx context.Context, env *cmdEnv, tx *db.SafeTX, tokenString string) error {
+	token, err := jwt.ParseToken(env.config, ctx, tx, tokenString)
+	if err != nil {
+		return errors.Wrap(err, "jwt.ParseToken")
+	}
+	user, err := token.GetUser(ctx, tx)
+	if err != nil {
+		return errors.Wrap(err, "token.GetUser")
+	}
+	tw := tabwriter.NewWriter(env.stdout, 0, 4, 2, ' ', 0)
+	switch t := token.(type) {
+	case *jwt.AccessToken:
+		writeClaims(tw, t.Scope, t.JTI.String(), t.ISS, t.TTL, t.IAT, t.EXP)
+		fmt.Fprintf(tw, "Fresh until:\t%s\n", formatUnix(t.Fresh))
+	case *jwt.RefreshToken:
+		writeClaims(tw, t.Scope, t.JTI.String(), t.ISS, t.TTL, t.IAT, t.EXP)
+	}
+	fmt.Fprintf(tw, "User:\t%s (%d)\n", user.Username, user.ID)
+	return tw.Flush()
+}
+
+// Write the claims shared by access and refresh tokens
+func writeClaims(
+	tw *tabwriter.Writer,
+	scope string,
+	jti string,
+	issuer string,
+	ttl string,
+	issued int64,
+	expires int64,
+) {
+	fmt.Fprintf(tw, "Scope:\t%s\n", scope)
+	fmt.Fprintf(tw, "ID:\t%s\n", jti)
+	fmt.Fprintf(tw, "Issuer:\t%s\n", issuer)
+	fmt.Fprintf(tw, "TTL:\t%s\n", ttl)
+	fmt.Fprintf(tw, "Issued at:\t%s\n", formatUnix(issued))
+	fmt.Fprintf(tw, "Expires at:\t%s\n", formatUnix(expires))
+}
+
+// Format a unix timestamp for output
+func formatUnix(t int64) string {
+	return time.Unix(t, 0).Format(time.RFC3339)
+}
+
+// Validate the token and add it to the blacklist
+func tokenRevoke(ctx context.Context, env *cmdEnv, tx *db.SafeTX, tokenString string) error {
+	token, err := jwt.ParseToken(env.config, ctx, tx, tokenString)
+	if err != nil {
+		return errors.Wrap(err, "jwt.ParseToken")
+	}
+	err = jwt.RevokeToken(ctx, tx, token)
+	if err != nil {
+		return errors.Wrap(err, "jwt.RevokeToken")
+	}
+	fmt.Fprintf(env.stdout, "Revoked %s token %s\n", token.GetScope(), token.GetJTI())
+	return nil
+}
@@ -0,0 +1,121 @@
+package tracing
+
+import (
+	"context"
+	"os"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Name of the instrumentation scope used for all spans
+const scope = "projectreshoot"
+
+// Options for the trace exporter
+type Options struct {
+	Exporter    string  // "none", "otlp", "stdout" or "file"
+	Endpoint    string  // OTLP HTTP endpoint. Uses the OTEL_EXPORTER_OTLP_* envars if empty
+	File        string  // Path to write spans to for the file exporter
+	SampleRatio float64 // Ratio of new traces to sample. Parent sampling is respected
+	ServiceName string  // Name of the service the spans are reported under
+}
+
+// Set up the global tracer provider and the W3C trace context propagator so
+// traceparent headers from the proxy are honoured. Returns a function that
+// flushes and stops the exporter. With the "none" exporter spans are not
+// recorded
+func Setup(ctx context.Context, opts Options) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+	noop := func(context.Context) error { return nil }
+
+	var exporter sdktrace.SpanExporter
+	var file *os.File
+	var err error
+	switch opts.Exporter {
+	case "", "none":
+		return noop, nil
+	case "otlp":
+		clientOpts := []otlptracehttp.Option{}
+		if opts.Endpoint != "" {
+			clientOpts = append(clientOpts, otlptracehttp.WithEndpointURL(opts.Endpoint))
+		}
+		exporter, err = otlptracehttp.New(ctx, clientOpts...)
+		if err != nil {
+			return nil, errors.Wrap(err, "otlptracehttp.New")
+		}
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+		if err != nil {
+			return nil, errors.Wrap(err, "stdouttrace.New")
+		}
+	case "file":
+		file, err = os.OpenFile(opts.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, errors.Wrap(err, "os.OpenFile")
+		}
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(file))
+		if err != nil {
+			file.Close()
+			return nil, errors.Wrap(err, "stdouttrace.New")
+		}
+	default:
+		return nil, errors.Errorf("Invalid trace exporter: %s", opts.Exporter)
+	}
+
+	res := resource.NewSchemaless(semconv.ServiceName(opts.ServiceName))
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(
+			sdktrace.TraceIDRatioBased(opts.SampleRatio),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if file != nil {
+			file.Close()
+		}
+		if err != nil {
+			return errors.Wrap(err, "provider.Shutdown")
+		}
+		return nil
+	}, nil
+}
+
+// Get the tracer for the application from the global tracer provider
+func Tracer() trace.Tracer {
+	return otel.Tracer(scope)
+}
+
+// Start a span from the global tracer provider. The span is a child of any
+// span in ctx
+func Start(
+	ctx context.Context,
+	name string,
+	attrs ...attribute.KeyValue,
+) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// Record the error on the span and mark it as failed. Does nothing if err
+// is nil
+func Fail(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
@@ -0,0 +1,36 @@
+package tracing
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetup(t *testing.T) {
+	ctx := context.Background()
+	t.Run("Invalid exporter", func(t *testing.T) {
+		_, err := Setup(ctx, Options{Exporter: "jaeger"})
+		assert.Error(t, err)
+	})
+	t.Run("File exporter writes spans", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "traces.json")
+		shutdown, err := Setup(ctx, Options{
+			Exporter:    "file",
+			File:        path,
+			SampleRatio: 1,
+			ServiceName: "test",
+		})
+		require.NoError(t, err)
+		_, span := Start(ctx, "test-span")
+		span.End()
+		require.NoError(t, shutdown(ctx))
+
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Contains(t, string(data), `"Name":"test-span"`)
+	})
+}
@@ -0,0 +1,489 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"projectreshoot/db"
+
+	"github.com/pkg/errors"
+	"golang.org/x/term"
+)
+
+// Audit log events recorded by the user commands
+const (
+	auditAdminCreate       = "admin_create"
+	auditAdminSetPassword  = "admin_set_password"
+	auditAdminRename       = "admin_rename"
+	auditAdminLock         = "admin_lock"
+	auditAdminUnlock       = "admin_unlock"
+	auditAdminDelete       = "admin_delete"
+	auditAdminRevokeTokens = "admin_revoke_tokens"
+)
+
+// Options for the user commands
+type userOptions struct {
+	JSON bool // Write the result as JSON
+	Yes  bool // Confirm deleting the user
+}
+
+// A user as written by the user commands with --json. Leaves out the
+// password hash
+type userJSON struct {
+	ID               int    `json:"id"`
+	Username         string `json:"username"`
+	Bio              string `json:"bio"`
+	CreatedAt        int64  `json:"created_at"`
+	Locked           bool   `json:"locked"`
+	LockedAt         int64  `json:"locked_at"`
+	TokensValidAfter int64  `json:"tokens_valid_after"`
+}
+
+// A change made to one user, given the arguments after the user. Returns the
+// message to write when done
+type userChange func(
+	ctx context.Context,
+	tx *db.SafeTX,
+	user *db.User,
+	args []string,
+) (string, error)
+
+func newUserJSON(user *db.User) userJSON {
+	return userJSON{
+		ID:               user.ID,
+		Username:         user.Username,
+		Bio:              user.Bio,
+		CreatedAt:        user.Created_at,
+		Locked:           user.Locked(),
+		LockedAt:         user.Locked_at,
+		TokensValidAfter: user.Tokens_valid_after,
+	}
+}
+
+// Manage user accounts. Changes wait for maintenance to finish and are
+// recorded in the audit log
+func userCommand() *command {
+	var opts userOptions
+	flags := func(fs *flag.FlagSet) {
+		fs.BoolVar(&opts.JSON, "json", false, "Write the result as JSON")
+	}
+	// Runs a change to one user, then writes the user and the message once
+	// the change is committed
+	change := func(fn userChange) func(context.Context, *cmdEnv, []string) error {
+		return func(ctx context.Context, env *cmdEnv, args []string) error {
+			var user *db.User
+			var msg string
+			err := withTx(ctx, env, func(ctx context.Context, tx *db.SafeTX) error {
+				var err error
+				user, err = findUser(ctx, tx, args[0])
+				if err != nil {
+					return err
+				}
+				msg, err = fn(ctx, tx, user, args[1:])
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			return writeUser(env, opts, user, msg)
+		}
+	}
+	return &command{
+		name:    "user",
+		summary: "Manage user accounts",
+		commands: []*command{
+			{
+				name:    "list",
+				summary: "List every user",
+				flags:   flags,
+				setup:   setupConfig,
+				run: func(ctx context.Context, env *cmdEnv, args []string) error {
+					return withReadTx(ctx, env, func(ctx context.Context, tx *db.SafeTX) error {
+						return userList(ctx, env, tx, opts)
+					})
+				},
+			},
+			{
+				name:    "show",
+				args:    "<username|id>",
+				summary: "Show a user",
+				minArgs: 1,
+				maxArgs: 1,
+				flags:   flags,
+				setup:   setupConfig,
+				run: func(ctx context.Context, env *cmdEnv, args []string) error {
+					return withReadTx(ctx, env, func(ctx context.Context, tx *db.SafeTX) error {
+						user, err := findUser(ctx, tx, args[0])
+						if err != nil {
+							return err
+						}
+						return writeUser(env, opts, user, "")
+					})
+				},
+			},
+			{
+				name:    "create",
+				args:    "<username>",
+				summary: "Create a user. The password is prompted for or read from stdin",
+				minArgs: 1,
+				maxArgs: 1,
+				flags:   flags,
+				setup:   setupConfig,
+				run: func(ctx context.Context, env *cmdEnv, args []string) error {
+					password, err := readPassword(env)
+					if err != nil {
+						return err
+					}
+					var user *db.User
+					err = withTx(ctx, env, func(ctx context.Context, tx *db.SafeTX) error {
+						user, err = userCreate(ctx, tx, args[0], password)
+						return err
+					})
+					if err != nil {
+						return err
+					}
+					return writeUser(env, opts, user, "Created user "+user.Username)
+				},
+			},
+			{
+				name:    "set-password",
+				args:    "<username|id>",
+				summary: "Set a user's password. The password is prompted for or read from stdin",
+				minArgs: 1,
+				maxArgs: 1,
+				flags:   flags,
+				setup:   setupConfig,
+				run: func(ctx context.Context, env *cmdEnv, args []string) error {
+					password, err := readPassword(env)
+					if err != nil {
+						return err
+					}
+					return change(userSetPassword(password))(ctx, env, args)
+				},
+			},
+			{
+				name:    "rename",
+				args:    "<username|id> <new-username>",
+				summary: "Change a user's username",
+				minArgs: 2,
+				maxArgs: 2,
+				flags:   flags,
+				setup:   setupConfig,
+				run:     change(userRename),
+			},
+			{
+				name:    "lock",
+				args:    "<username|id>",
+				summary: "Stop a user logging in or using their tokens",
+				minArgs: 1,
+				maxArgs: 1,
+				flags:   flags,
+				setup:   setupConfig,
+				run:     change(userSetLocked(true)),
+			},
+			{
+				name:    "unlock",
+				args:    "<username|id>",
+				summary: "Let a locked user log in again",
+				minArgs: 1,
+				maxArgs: 1,
+				flags:   flags,
+				setup:   setupConfig,
+				run:     change(userSetLocked(false)),
+			},
+			{
+				name:    "delete",
+				args:    "<username|id>",
+				summary: "Delete a user. Needs --yes as it can't be undone",
+				minArgs: 1,
+				maxArgs: 1,
+				flags: func(fs *flag.FlagSet) {
+					flags(fs)
+					fs.BoolVar(&opts.Yes, "yes", false, "Confirm deleting the user")
+				},
+				setup: setupConfig,
+				run: func(ctx context.Context, env *cmdEnv, args []string) error {
+					if !opts.Yes {
+						return errors.New("Deleting a user can't be undone, run again with --yes")
+					}
+					return change(userDelete)(ctx, env, args)
+				},
+			},
+			{
+				name:    "revoke-tokens",
+				args:    "<username|id>",
+				summary: "Log a user out everywhere by revoking every token issued to them",
+				minArgs: 1,
+				maxArgs: 1,
+				flags:   flags,
+				setup:   setupConfig,
+				run:     change(userRevokeTokens),
+			},
+		},
+	}
+}
+
+// Get the user by ID if ref is a number, otherwise by username
+func findUser(ctx context.Context, tx *db.SafeTX, ref string) (*db.User, error) {
+	if id, err := strconv.Atoi(ref); err == nil {
+		user, err := tx.Users().GetUserFromID(ctx, id)
+		if err != nil {
+			return nil, errors.Wrap(err, "tx.Users().GetUserFromID")
+		}
+		return user, nil
+	}
+	user, err := tx.Users().GetUserFromUsername(ctx, ref)
+	if err != nil {
+		return nil, errors.Wrap(err, "tx.Users().GetUserFromUsername")
+	}
+	return user, nil
+}
+
+// Write the user as JSON, or the message if set, otherwise the user's details
+func writeUser(env *cmdEnv, opts userOptions, user *db.User, msg string) error {
+	if opts.JSON {
+		return json.NewEncoder(env.stdout).Encode(newUserJSON(user))
+	}
+	if msg != "" {
+		fmt.Fprintln(env.stdout, msg)
+		return nil
+	}
+	tw := tabwriter.NewWriter(env.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "ID:\t%d\n", user.ID)
+	fmt.Fprintf(tw, "Username:\t%s\n", user.Username)
+	fmt.Fprintf(tw, "Joined:\t%s\n", formatUnix(user.Created_at))
+	fmt.Fprintf(tw, "Bio:\t%s\n", user.Bio)
+	if user.Locked() {
+		fmt.Fprintf(tw, "Locked:\t%s\n", formatUnix(user.Locked_at))
+	} else {
+		fmt.Fprintln(tw, "Locked:\tno")
+	}
+	if user.Tokens_valid_after != 0 {
+		fmt.Fprintf(tw, "Tokens revoked:\t%s\n", formatUnix(user.Tokens_valid_after))
+	}
+	return tw.Flush()
+}
+
+// Write every user
+func userList(ctx context.Context, env *cmdEnv, tx *db.SafeTX, opts userOptions) error {
+	users, err := tx.Users().ListUsers(ctx)
+	if err != nil {
+		return errors.Wrap(err, "tx.Users().ListUsers")
+	}
+	if opts.JSON {
+		list := make([]userJSON, 0, len(users))
+		for _, user := range users {
+			list = append(list, newUserJSON(user))
+		}
+		return json.NewEncoder(env.stdout).Encode(list)
+	}
+	tw := tabwriter.NewWriter(env.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tUSERNAME\tJOINED\tLOCKED")
+	for _, user := range users {
+		locked := "no"
+		if user.Locked() {
+			locked = "yes"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n",
+			user.ID,
+			user.Username,
+			formatUnix(user.Created_at),
+			locked,
+		)
+	}
+	return tw.Flush()
+}
+
+// Check the username is free to use
+func checkUsername(ctx context.Context, tx *db.SafeTX, username string) error {
+	if strings.TrimSpace(username) == "" {
+		return errors.New("Username can't be empty")
+	}
+	unique, err := tx.Users().CheckUsernameUnique(ctx, username)
+	if err != nil {
+		return errors.Wrap(err, "tx.Users().CheckUsernameUnique")
+	}
+	if !unique {
+		return errors.New("Username is taken")
+	}
+	return nil
+}
+
+// Create a user with the password
+func userCreate(
+	ctx context.Context,
+	tx *db.SafeTX,
+	username string,
+	password string,
+) (*db.User, error) {
+	err := checkUsername(ctx, tx, username)
+	if err != nil {
+		return nil, err
+	}
+	user, err := db.CreateNewUser(ctx, tx, username, password)
+	if err != nil {
+		return nil, errors.Wrap(err, "db.CreateNewUser")
+	}
+	return user, recordAdminAudit(ctx, tx, user, auditAdminCreate, "")
+}
+
+// Change the user's username to args[0]
+func userRename(
+	ctx context.Context,
+	tx *db.SafeTX,
+	user *db.User,
+	args []string,
+) (string, error) {
+	newUsername := args[0]
+	err := checkUsername(ctx, tx, newUsername)
+	if err != nil {
+		return "", err
+	}
+	oldUsername := user.Username
+	err = user.ChangeUsername(ctx, tx, newUsername)
+	if err != nil {
+		return "", errors.Wrap(err, "user.ChangeUsername")
+	}
+	user.Username = newUsername
+	return "Renamed " + oldUsername + " to " + newUsername,
+		recordAdminAudit(ctx, tx, user, auditAdminRename, oldUsername+" -> "+newUsername)
+}
+
+// Get a change that sets the user's password
+func userSetPassword(password string) userChange {
+	return func(
+		ctx context.Context,
+		tx *db.SafeTX,
+		user *db.User,
+		args []string,
+	) (string, error) {
+		err := user.SetPassword(ctx, tx, password)
+		if err != nil {
+			return "", errors.Wrap(err, "user.SetPassword")
+		}
+		return "Set the password of " + user.Username,
+			recordAdminAudit(ctx, tx, user, auditAdminSetPassword, "")
+	}
+}
+
+// Get a change that locks or unlocks the user
+func userSetLocked(locked bool) userChange {
+	return func(
+		ctx context.Context,
+		tx *db.SafeTX,
+		user *db.User,
+		args []string,
+	) (string, error) {
+		if user.Locked() == locked {
+			if locked {
+				return user.Username + " is already locked", nil
+			}
+			return user.Username + " isn't locked", nil
+		}
+		err := user.SetLocked(ctx, tx, locked)
+		if err != nil {
+			return "", errors.Wrap(err, "user.SetLocked")
+		}
+		if locked {
+			return "Locked " + user.Username,
+				recordAdminAudit(ctx, tx, user, auditAdminLock, "")
+		}
+		return "Unlocked " + user.Username,
+			recordAdminAudit(ctx, tx, user, auditAdminUnlock, "")
+	}
+}
+
+// Delete the user
+func userDelete(
+	ctx context.Context,
+	tx *db.SafeTX,
+	user *db.User,
+	args []string,
+) (string, error) {
+	err := tx.Users().DeleteUser(ctx, user.ID)
+	if err != nil {
+		return "", errors.Wrap(err, "tx.Users().DeleteUser")
+	}
+	return "Deleted " + user.Username,
+		recordAdminAudit(ctx, tx, user, auditAdminDelete, user.Username)
+}
+
+// Revoke every token issued to the user
+func userRevokeTokens(
+	ctx context.Context,
+	tx *db.SafeTX,
+	user *db.User,
+	args []string,
+) (string, error) {
+	err := user.RevokeTokens(ctx, tx)
+	if err != nil {
+		return "", errors.Wrap(err, "user.RevokeTokens")
+	}
+	return "Revoked every token issued to " + user.Username,
+		recordAdminAudit(ctx, tx, user, auditAdminRevokeTokens, "")
+}
+
+// Record a change made by a user command in the audit log
+func recordAdminAudit(
+	ctx context.Context,
+	tx *db.SafeTX,
+	user *db.User,
+	event string,
+	detail string,
+) error {
+	err := tx.Audit().Record(ctx, user.ID, event, "", detail)
+	if err != nil {
+		return errors.Wrap(err, "tx.Audit().Record")
+	}
+	return nil
+}
+
+// Read a new password. Prompts for it twice without echoing if stdin is a
+// terminal, otherwise reads the first line of stdin
+func readPassword(env *cmdEnv) (string, error) {
+	var password string
+	if file, ok := env.stdin.(*os.File); ok && term.IsTerminal(int(file.Fd())) {
+		prompt := func(label string) (string, error) {
+			fmt.Fprint(env.stderr, label)
+			input, err := term.ReadPassword(int(file.Fd()))
+			fmt.Fprintln(env.stderr)
+			if err != nil {
+				return "", errors.Wrap(err, "term.ReadPassword")
+			}
+			return string(input), nil
+		}
+		var err error
+		password, err = prompt("Password: ")
+		if err != nil {
+			return "", err
+		}
+		confirm, err := prompt("Confirm password: ")
+		if err != nil {
+			return "", err
+		}
+		if password != confirm {
+			return "", errors.New("Passwords do not match")
+		}
+	} else {
+		line, err := bufio.NewReader(env.stdin).ReadString('\n')
+		if err != nil && !(errors.Is(err, io.EOF) && line != "") {
+			return "", errors.New("No password given on stdin")
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+	if password == "" {
+		return "", errors.New("Password can't be empty")
+	}
+	if len(password) > 72 {
+		return "", errors.New("Password exceeds maximum length of 72 bytes")
+	}
+	return password, nil
+}
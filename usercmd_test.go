@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"projectreshoot/config"
+	"projectreshoot/tests"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserCommand(t *testing.T) {
+	dir := t.TempDir()
+	ver, err := strconv.ParseInt(config.DBVersion, 10, 0)
+	require.NoError(t, err)
+	require.NoError(t, tests.SetupTestDBFile(filepath.Join(dir, config.DBVersion), ver))
+	t.Chdir(dir)
+	t.Setenv("LOG_LEVEL", "error")
+
+	// Runs the user command with the stdin, returning what was written to stdout
+	runUser := func(ctx context.Context, stdin string, args ...string) (string, error) {
+		var stdout, stderr bytes.Buffer
+		args = append([]string{"user"}, args...)
+		err := run(ctx, strings.NewReader(stdin), &stdout, &stderr, args)
+		return stdout.String(), err
+	}
+	// Runs the user command with --json and decodes the user written
+	runJSON := func(t *testing.T, stdin string, args ...string) userJSON {
+		out, err := runUser(t.Context(), stdin, append(args, "--json")...)
+		require.NoError(t, err)
+		var user userJSON
+		require.NoError(t, json.Unmarshal([]byte(out), &user))
+		return user
+	}
+
+	t.Run("Users are created with the password from stdin", func(t *testing.T) {
+		user := runJSON(t, "password\n", "create", "alice")
+		assert.Equal(t, "alice", user.Username)
+		assert.False(t, user.Locked)
+
+		_, err := runUser(t.Context(), "password\n", "create", "alice")
+		require.ErrorContains(t, err, "Username is taken")
+		_, err = runUser(t.Context(), "", "create", "bob")
+		require.Error(t, err)
+	})
+	t.Run("Users are found by username or ID", func(t *testing.T) {
+		user := runJSON(t, "", "show", "alice")
+		assert.Equal(t, user, runJSON(t, "", "show", strconv.Itoa(user.ID)))
+
+		out, err := runUser(t.Context(), "", "list")
+		require.NoError(t, err)
+		assert.Contains(t, out, "alice")
+	})
+	t.Run("Users are locked, unlocked and renamed", func(t *testing.T) {
+		user := runJSON(t, "", "lock", "alice")
+		assert.True(t, user.Locked)
+		user = runJSON(t, "", "unlock", "alice")
+		assert.False(t, user.Locked)
+		user = runJSON(t, "", "rename", "alice", "carol")
+		assert.Equal(t, "carol", user.Username)
+		user = runJSON(t, "", "revoke-tokens", "carol")
+		assert.NotZero(t, user.TokensValidAfter)
+	})
+	t.Run("Changes wait for maintenance to finish", func(t *testing.T) {
+		marker := config.DBVersion + maintMarkerInfix + "test"
+		require.NoError(t, os.WriteFile(marker, nil, 0600))
+		ctx, cancel := context.WithTimeout(t.Context(), 500*time.Millisecond)
+		defer cancel()
+		_, err := runUser(ctx, "", "lock", "carol")
+		require.ErrorIs(t, err, errMaintenance)
+		require.NoError(t, os.Remove(marker))
+		assert.False(t, runJSON(t, "", "show", "carol").Locked)
+	})
+	t.Run("Deleting needs --yes", func(t *testing.T) {
+		_, err := runUser(t.Context(), "", "delete", "carol")
+		require.Error(t, err)
+		_, err = runUser(t.Context(), "", "delete", "carol", "--yes")
+		require.NoError(t, err)
+		_, err = runUser(t.Context(), "", "show", "carol")
+		require.Error(t, err)
+	})
+}
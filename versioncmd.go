@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+
+	"projectreshoot/config"
+)
+
+// Print the version of the build and the database it needs
+func versionCommand() *command {
+	return &command{
+		name:    "version",
+		summary: "Print the build and the database version it needs",
+		run: func(ctx context.Context, env *cmdEnv, args []string) error {
+			revision, modified := "unknown", false
+			if info, ok := debug.ReadBuildInfo(); ok {
+				for _, setting := range info.Settings {
+					switch setting.Key {
+					case "vcs.revision":
+						revision = setting.Value
+					case "vcs.modified":
+						modified = setting.Value == "true"
+					}
+				}
+			}
+			if modified {
+				revision += " (modified)"
+			}
+			fmt.Fprintf(env.stdout, "Commit: %s\n", revision)
+			fmt.Fprintf(env.stdout, "Go version: %s\n", runtime.Version())
+			// Read by deploy/deploy.sh to pick the migration to run
+			fmt.Fprintf(env.stdout, "Database version: %s\n", config.DBVersion)
+			return nil
+		},
+	}
+}
@@ -0,0 +1,13 @@
+package layout
+
+import "encoding/json"
+
+// Get the htmx config for the page. htmx adds the nonce to inline scripts
+// and indicator styles so they are allowed by the Content-Security-Policy
+func htmxConfig(nonce string) string {
+	config, _ := json.Marshal(map[string]string{
+		"inlineScriptNonce": nonce,
+		"inlineStyleNonce":  nonce,
+	})
+	return string(config)
+}